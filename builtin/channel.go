@@ -28,7 +28,8 @@ var suChannelMethods = methods("chan")
 
 var _ = method(chan_Send, "(value)")
 
-func chan_Send(this, val Value) Value {
+func chan_Send(th *Thread, this Value, args []Value) Value {
+	val := args[0]
 	defer func() {
 		if r := recover(); r != nil {
 			// e.g. send on a closed channel
@@ -39,6 +40,8 @@ func chan_Send(this, val Value) Value {
 	select {
 	case sc.ch <- val:
 		// value sent
+	case <-th.Cancelled():
+		panic("cancelled")
 	case <-time.After(sc_timeout):
 		panic("Channel: Send timeout")
 	}
@@ -47,7 +50,7 @@ func chan_Send(this, val Value) Value {
 
 var _ = method(chan_Recv, "()")
 
-func chan_Recv(this Value) Value {
+func chan_Recv(th *Thread, this Value, _ []Value) Value {
 	sc := this.(*suChannel)
 	select {
 	case val, ok := <-sc.ch:
@@ -58,6 +61,8 @@ func chan_Recv(this Value) Value {
 			val.SetConcurrent()
 		}
 		return val
+	case <-th.Cancelled():
+		panic("cancelled")
 	case <-time.After(sc_timeout):
 		panic("Channel: Recv timeout")
 	}
@@ -65,9 +70,9 @@ func chan_Recv(this Value) Value {
 
 var _ = method(chan_Recv2, "(channel)")
 
-func chan_Recv2(this, arg Value) Value {
+func chan_Recv2(th *Thread, this Value, args []Value) Value {
 	sc := this.(*suChannel)
-	sc2 := arg.(*suChannel)
+	sc2 := args[0].(*suChannel)
 	ob := &SuObject{}
 	select {
 	case val, ok := <-sc.ch:
@@ -86,6 +91,8 @@ func chan_Recv2(this, arg Value) Value {
 			}
 			ob.Add(val)
 		}
+	case <-th.Cancelled():
+		panic("cancelled")
 	case <-time.After(sc_timeout):
 		panic("Channel: Recv2 timeout")
 	}
@@ -0,0 +1,374 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/hacks"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Gzip and Zstd have the same interface as Zlib (Compress and Uncompress)
+// plus Reader and Writer for streaming to and from files, sockets, etc.
+
+type suGzip struct {
+	staticClass[suGzip]
+}
+
+func init() {
+	Global.Builtin("Gzip", &suGzip{})
+}
+
+func (*suGzip) String() string {
+	return "Gzip /* builtin class */"
+}
+
+func (g *suGzip) Equal(other any) bool {
+	return g == other
+}
+
+func (*suGzip) Lookup(_ *Thread, method string) Value {
+	return gzipMethods[method]
+}
+
+var gzipMethods = methods("gzip")
+
+func gzipWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func gzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+var _ = staticMethod(gzip_Compress, "(string)")
+
+func gzip_Compress(arg Value) Value {
+	return compress("Gzip.Compress", arg, gzipWriter)
+}
+
+var _ = staticMethod(gzip_Uncompress, "(string)")
+
+func gzip_Uncompress(arg Value) Value {
+	return uncompress("Gzip.Uncompress", arg, gzipReader)
+}
+
+var _ = staticMethod(gzip_Writer, "(dest, block = false)")
+
+func gzip_Writer(th *Thread, args []Value) Value {
+	return compressWriter(th, "Gzip.Writer", args, gzipWriter)
+}
+
+var _ = staticMethod(gzip_Reader, "(source, block = false)")
+
+func gzip_Reader(th *Thread, args []Value) Value {
+	return compressReader(th, "Gzip.Reader", args, gzipReader)
+}
+
+var _ = staticMethod(gzip_Members, "()")
+
+func gzip_Members() Value {
+	return gzip_members
+}
+
+var gzip_members = methodList(gzipMethods)
+
+//-------------------------------------------------------------------
+
+type suZstd struct {
+	staticClass[suZstd]
+}
+
+func init() {
+	Global.Builtin("Zstd", &suZstd{})
+}
+
+func (*suZstd) String() string {
+	return "Zstd /* builtin class */"
+}
+
+func (z *suZstd) Equal(other any) bool {
+	return z == other
+}
+
+func (*suZstd) Lookup(_ *Thread, method string) Value {
+	return zstdMethods[method]
+}
+
+var zstdMethods = methods("zstd")
+
+func zstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func zstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+var _ = staticMethod(zstd_Compress, "(string)")
+
+func zstd_Compress(arg Value) Value {
+	return compress("Zstd.Compress", arg, zstdWriter)
+}
+
+var _ = staticMethod(zstd_Uncompress, "(string)")
+
+func zstd_Uncompress(arg Value) Value {
+	return uncompress("Zstd.Uncompress", arg, zstdReader)
+}
+
+var _ = staticMethod(zstd_Writer, "(dest, block = false)")
+
+func zstd_Writer(th *Thread, args []Value) Value {
+	return compressWriter(th, "Zstd.Writer", args, zstdWriter)
+}
+
+var _ = staticMethod(zstd_Reader, "(source, block = false)")
+
+func zstd_Reader(th *Thread, args []Value) Value {
+	return compressReader(th, "Zstd.Reader", args, zstdReader)
+}
+
+var _ = staticMethod(zstd_Members, "()")
+
+func zstd_Members() Value {
+	return zstd_members
+}
+
+var zstd_members = methodList(zstdMethods)
+
+//-------------------------------------------------------------------
+
+func compress(which string, arg Value,
+	newWriter func(io.Writer) (io.WriteCloser, error)) Value {
+	var b strings.Builder
+	w, err := newWriter(&b)
+	if err == nil {
+		_, err = io.WriteString(w, ToStr(arg))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	return SuStr(b.String())
+}
+
+func uncompress(which string, arg Value,
+	newReader func(io.Reader) (io.ReadCloser, error)) Value {
+	r, err := newReader(strings.NewReader(ToStr(arg)))
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	defer r.Close()
+	return SuStr(hacks.BStoS(readAllLimited(which, r)))
+}
+
+// readAllLimited reads the rest of r, panicking if it exceeds StringLimit.
+// This prevents "zip bombs" that uncompress to huge sizes.
+func readAllLimited(which string, r io.Reader) []byte {
+	var b bytes.Buffer
+	n, err := b.ReadFrom(io.LimitReader(r, StringLimit+1))
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	if n > StringLimit {
+		panic(which + ": string limit exceeded")
+	}
+	return b.Bytes()
+}
+
+var nCompressStream atomic.Int32
+var _ = AddInfo("builtin.nCompressStream", &nCompressStream)
+
+// suCompressWriter compresses what is written to it
+// and writes the result to the destination (e.g. File).
+// Close must be called to finish the compressed data,
+// it does not close the destination.
+type suCompressWriter struct {
+	ValueBase[*suCompressWriter]
+	which string
+	w     io.WriteCloser
+}
+
+func compressWriter(th *Thread, which string, args []Value,
+	newWriter func(io.Writer) (io.WriteCloser, error)) Value {
+	tow, ok := args[0].(writer)
+	if !ok {
+		panic(which + ": can only write to file, pipe, or socket")
+	}
+	w, err := newWriter(tow.writer())
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	cw := &suCompressWriter{which: which, w: w}
+	nCompressStream.Add(1)
+	if args[1] == False {
+		return cw
+	}
+	// block form
+	defer cw.close()
+	return th.Call(args[1], cw)
+}
+
+func (cw *suCompressWriter) close() {
+	if cw.w == nil {
+		return
+	}
+	nCompressStream.Add(-1)
+	err := cw.w.Close()
+	cw.w = nil
+	if err != nil {
+		panic(cw.which + ": " + err.Error())
+	}
+}
+
+func cwOpen(this Value) *suCompressWriter {
+	cw := this.(*suCompressWriter)
+	if cw.w == nil {
+		panic("can't use a closed " + cw.which)
+	}
+	return cw
+}
+
+func (cw *suCompressWriter) writer() io.Writer {
+	return readerFrom{cwOpen(cw).w}
+}
+
+var _ Value = (*suCompressWriter)(nil)
+
+func (cw *suCompressWriter) Equal(other any) bool {
+	return cw == other
+}
+
+func (*suCompressWriter) Lookup(_ *Thread, method string) Value {
+	return suCompressWriterMethods[method]
+}
+
+var suCompressWriterMethods = methods("cwriter")
+
+var _ = method(cwriter_Write, "(string)")
+
+func cwriter_Write(this, arg Value) Value {
+	cw := cwOpen(this)
+	if _, err := io.WriteString(cw.w, AsStr(arg)); err != nil {
+		panic(cw.which + ": " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(cwriter_Writeline, "(string)")
+
+func cwriter_Writeline(this, arg Value) Value {
+	cw := cwOpen(this)
+	if _, err := io.WriteString(cw.w, AsStr(arg)+"\r\n"); err != nil {
+		panic(cw.which + ": " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(cwriter_Close, "()")
+
+func cwriter_Close(this Value) Value {
+	this.(*suCompressWriter).close()
+	return nil
+}
+
+//-------------------------------------------------------------------
+
+// suCompressReader uncompresses from a source (e.g. File)
+type suCompressReader struct {
+	ValueBase[*suCompressReader]
+	which string
+	r     io.ReadCloser
+	rdr   *bufio.Reader
+}
+
+func compressReader(th *Thread, which string, args []Value,
+	newReader func(io.Reader) (io.ReadCloser, error)) Value {
+	r, err := newReader(sourceReader(which, args[0]))
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	cr := &suCompressReader{which: which, r: r, rdr: bufio.NewReader(r)}
+	nCompressStream.Add(1)
+	if args[1] == False {
+		return cr
+	}
+	// block form
+	defer cr.close()
+	return th.Call(args[1], cr)
+}
+
+func (cr *suCompressReader) close() {
+	if cr.r == nil {
+		return
+	}
+	nCompressStream.Add(-1)
+	cr.r.Close()
+	cr.r = nil
+}
+
+func crOpen(this Value) *suCompressReader {
+	cr := this.(*suCompressReader)
+	if cr.r == nil {
+		panic("can't use a closed " + cr.which)
+	}
+	return cr
+}
+
+func (cr *suCompressReader) reader() *bufio.Reader {
+	return crOpen(cr).rdr
+}
+
+var _ Value = (*suCompressReader)(nil)
+
+func (cr *suCompressReader) Equal(other any) bool {
+	return cr == other
+}
+
+func (*suCompressReader) Lookup(_ *Thread, method string) Value {
+	return suCompressReaderMethods[method]
+}
+
+var suCompressReaderMethods = methods("creader")
+
+var _ = method(creader_Read, "(nbytes=false)")
+
+func creader_Read(this, arg Value) Value {
+	cr := crOpen(this)
+	return limitedRead(cr.which+".Read", cr.rdr, arg)
+}
+
+var _ = method(creader_Readline, "()")
+
+func creader_Readline(this Value) Value {
+	cr := crOpen(this)
+	return Readline(cr.rdr, cr.which+".Readline: ")
+}
+
+var _ = method(creader_CopyTo, "(dest, nbytes = false)")
+
+func creader_CopyTo(th *Thread, this Value, args []Value) Value {
+	return CopyTo(th, crOpen(this).rdr, args[0], args[1])
+}
+
+var _ = method(creader_Close, "()")
+
+func creader_Close(this Value) Value {
+	this.(*suCompressReader).close()
+	return nil
+}
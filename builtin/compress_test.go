@@ -0,0 +1,80 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestCompress(t *testing.T) {
+	assert := assert.T(t)
+	s := SuStr(strings.Repeat("hello world ", 100))
+	c := gzip_Compress(s)
+	assert.That(len(ToStr(c)) < 100)
+	assert.This(gzip_Uncompress(c)).Is(s)
+	c = zstd_Compress(s)
+	assert.That(len(ToStr(c)) < 100)
+	assert.This(zstd_Uncompress(c)).Is(s)
+	assert.This(func() { gzip_Uncompress(s) }).
+		Panics("Gzip.Uncompress: gzip: invalid header")
+	bomb := gzip_Compress(SuStr(strings.Repeat("\x00", StringLimit+1)))
+	assert.This(func() { gzip_Uncompress(bomb) }).
+		Panics("Gzip.Uncompress: string limit exceeded")
+
+	var th Thread
+	filename := filepath.Join(t.TempDir(), "test.gz")
+	f := newSuFile(filename, "w")
+	w := gzip_Writer(&th, []Value{f, False})
+	cwriter_Writeline(w, SuStr("one"))
+	cwriter_Write(w, SuStr("two"))
+	cwriter_Close(w)
+	f.close()
+	f = newSuFile(filename, "r")
+	defer f.close()
+	r := gzip_Reader(&th, []Value{f, False})
+	assert.This(creader_Readline(r)).Is(SuStr("one"))
+	assert.This(creader_Read(r, False)).Is(SuStr("two"))
+	creader_Close(r)
+}
+
+func TestZip(t *testing.T) {
+	assert := assert.T(t)
+	var th Thread
+	dir := t.TempDir()
+	zipfile := SuStr(filepath.Join(dir, "test.zip"))
+	b := filepath.Join(dir, "b.txt")
+	os.WriteFile(b, []byte("world"), 0644)
+	zw := ZipWriter(&th, []Value{zipfile, False})
+	zipw_AddString(zw, SuStr("a.txt"), SuStr("hello"))
+	zipw_AddFile(zw, SuStr(b), False)
+	zipw_Close(zw)
+	zr := ZipReader(&th, []Value{zipfile, False})
+	list := zipr_List(zr).(*SuObject)
+	assert.This(list.ListSize()).Is(2)
+	assert.This(list.ListGet(0).Get(&th, SuStr("name"))).Is(SuStr("a.txt"))
+	assert.This(list.ListGet(0).Get(&th, SuStr("size"))).Is(IntVal(5))
+	assert.This(zipr_Read(zr, SuStr("a.txt"))).Is(SuStr("hello"))
+	assert.This(zipr_Read(zr, SuStr("b.txt"))).Is(SuStr("world"))
+	out := SuStr(filepath.Join(dir, "a.out"))
+	zipr_Extract(zr, SuStr("a.txt"), out)
+	zipr_Close(zr)
+	f := newSuFile(ToStr(out), "r")
+	defer f.close()
+	assert.This(file_Read(f, False)).Is(SuStr("hello"))
+	assert.This(func() { zipr_Read(zr, SuStr("a.txt")) }).
+		Panics("can't use a closed ZipReader")
+
+	// from a stream
+	zf := newSuFile(string(zipfile), "r")
+	defer zf.close()
+	zr = ZipReader(&th, []Value{zf, False})
+	assert.This(zipr_Read(zr, SuStr("b.txt"))).Is(SuStr("world"))
+	zipr_Close(zr)
+}
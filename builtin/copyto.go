@@ -4,6 +4,7 @@
 package builtin
 
 import (
+	"bufio"
 	"io"
 
 	. "github.com/apmckinlay/gsuneido/core"
@@ -14,6 +15,22 @@ type writer interface {
 	writer() io.Writer
 }
 
+// reader must be implemented by sources.
+// It returns the source's own buffered reader
+// so reads through it and through the source's methods stay in sync.
+type reader interface {
+	reader() *bufio.Reader
+}
+
+// sourceReader is used by builtins that read from a stream
+// e.g. File, SocketClient, RunPiped, HttpResponse, or Gzip.Reader
+func sourceReader(which string, source Value) *bufio.Reader {
+	if r, ok := source.(reader); ok {
+		return r.reader()
+	}
+	panic(which + ": invalid source")
+}
+
 // CopyTo copies from src to to, up to nbytes or until src eof.
 // Called by CopyTo in file, socket, and runpiped.
 func CopyTo(th *Thread, src io.Reader, to, nbytes Value) Value {
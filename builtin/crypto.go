@@ -0,0 +1,231 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"hash"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/hacks"
+)
+
+// Keys, data, and results are binary strings,
+// use ToHex or Base64Encode if required.
+// ECDSA keys are DER encoded (PKIX public, PKCS #8 private).
+// See also: Sha256, Argon2id, OpenPGP
+
+func hashFn(which string, name Value) func() hash.Hash {
+	switch ToStr(name) {
+	case "sha1":
+		return sha1.New
+	case "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	}
+	panic(which + ": hash must be 'sha1', 'sha256', or 'sha512'")
+}
+
+func toBytes(v Value) []byte {
+	return hacks.Stobs(ToStr(v))
+}
+
+var _ = builtin(Hmac, "(message, key, hash = 'sha256')")
+
+func Hmac(message, key, hash Value) Value {
+	mac := hmac.New(hashFn("Hmac", hash), toBytes(key))
+	mac.Write(toBytes(message))
+	return SuStr(hacks.BStoS(mac.Sum(nil)))
+}
+
+var _ = builtin(RandomBytes, "(n)")
+
+func RandomBytes(n Value) Value {
+	buf := make([]byte, ToInt(n))
+	rand.Read(buf)
+	return SuStr(hacks.BStoS(buf))
+}
+
+var _ = builtin(Pbkdf2, "(password, salt, iterations, keyLen = 32, hash = 'sha256')")
+
+func Pbkdf2(th *Thread, args []Value) Value {
+	key, err := pbkdf2.Key(hashFn("Pbkdf2", args[4]), ToStr(args[0]),
+		toBytes(args[1]), ToInt(args[2]), ToInt(args[3]))
+	if err != nil {
+		panic("Pbkdf2: " + err.Error())
+	}
+	return SuStr(hacks.BStoS(key))
+}
+
+var _ = builtin(Hkdf, "(secret, salt, info, keyLen = 32, hash = 'sha256')")
+
+func Hkdf(th *Thread, args []Value) Value {
+	key, err := hkdf.Key(hashFn("Hkdf", args[4]), toBytes(args[0]),
+		toBytes(args[1]), ToStr(args[2]), ToInt(args[3]))
+	if err != nil {
+		panic("Hkdf: " + err.Error())
+	}
+	return SuStr(hacks.BStoS(key))
+}
+
+//-------------------------------------------------------------------
+
+func aesGcm(which string, key Value) cipher.AEAD {
+	block, err := aes.NewCipher(toBytes(key))
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	return gcm
+}
+
+var _ = builtin(AesGcmEncrypt, "(plaintext, key, data = '')")
+
+// AesGcmEncrypt returns a random nonce followed by the ciphertext.
+// The key must be 16, 24, or 32 bytes.
+// data is additional authenticated data that is not encrypted.
+func AesGcmEncrypt(plaintext, key, data Value) Value {
+	gcm := aesGcm("AesGcmEncrypt", key)
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	out := gcm.Seal(nonce, nonce, toBytes(plaintext), toBytes(data))
+	return SuStr(hacks.BStoS(out))
+}
+
+var _ = builtin(AesGcmDecrypt, "(ciphertext, key, data = '')")
+
+func AesGcmDecrypt(ciphertext, key, data Value) Value {
+	gcm := aesGcm("AesGcmDecrypt", key)
+	ct := toBytes(ciphertext)
+	if len(ct) < gcm.NonceSize() {
+		panic("AesGcmDecrypt: ciphertext too short")
+	}
+	n := gcm.NonceSize()
+	out, err := gcm.Open(nil, ct[:n], ct[n:], toBytes(data))
+	if err != nil {
+		panic("AesGcmDecrypt: " + err.Error())
+	}
+	return SuStr(hacks.BStoS(out))
+}
+
+//-------------------------------------------------------------------
+
+func keyPairOb(public, private []byte) Value {
+	ob := &SuObject{}
+	ob.Set(SuStr("public"), SuStr(hacks.BStoS(public)))
+	ob.Set(SuStr("private"), SuStr(hacks.BStoS(private)))
+	return ob
+}
+
+var _ = builtin(Ed25519Keys, "()")
+
+// Ed25519Keys returns a new key pair as an object with public and private
+func Ed25519Keys() Value {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("Ed25519Keys: " + err.Error())
+	}
+	return keyPairOb(pub, priv)
+}
+
+var _ = builtin(Ed25519Sign, "(privateKey, message)")
+
+func Ed25519Sign(key, message Value) Value {
+	k := toBytes(key)
+	if len(k) != ed25519.PrivateKeySize {
+		panic("Ed25519Sign: invalid private key")
+	}
+	return SuStr(hacks.BStoS(ed25519.Sign(k, toBytes(message))))
+}
+
+var _ = builtin(Ed25519Verify, "(publicKey, message, signature)")
+
+func Ed25519Verify(key, message, sig Value) Value {
+	k := toBytes(key)
+	if len(k) != ed25519.PublicKeySize {
+		panic("Ed25519Verify: invalid public key")
+	}
+	return SuBool(ed25519.Verify(k, toBytes(message), toBytes(sig)))
+}
+
+var _ = builtin(EcdsaKeys, "(curve = 'P256')")
+
+// EcdsaKeys returns a new key pair as an object with public and private
+func EcdsaKeys(curve Value) Value {
+	var c elliptic.Curve
+	switch ToStr(curve) {
+	case "P256":
+		c = elliptic.P256()
+	case "P384":
+		c = elliptic.P384()
+	case "P521":
+		c = elliptic.P521()
+	default:
+		panic("EcdsaKeys: curve must be 'P256', 'P384', or 'P521'")
+	}
+	priv, err := ecdsa.GenerateKey(c, rand.Reader)
+	if err != nil {
+		panic("EcdsaKeys: " + err.Error())
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		panic("EcdsaKeys: " + err.Error())
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		panic("EcdsaKeys: " + err.Error())
+	}
+	return keyPairOb(pub, der)
+}
+
+var _ = builtin(EcdsaSign, "(privateKey, message, hash = 'sha256')")
+
+// EcdsaSign returns an ASN.1 signature of the hash of the message
+func EcdsaSign(key, message, hash Value) Value {
+	k, err := x509.ParsePKCS8PrivateKey(toBytes(key))
+	priv, ok := k.(*ecdsa.PrivateKey)
+	if err != nil || !ok {
+		panic("EcdsaSign: invalid private key")
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, priv,
+		digest("EcdsaSign", hash, message))
+	if err != nil {
+		panic("EcdsaSign: " + err.Error())
+	}
+	return SuStr(hacks.BStoS(sig))
+}
+
+var _ = builtin(EcdsaVerify, "(publicKey, message, signature, hash = 'sha256')")
+
+func EcdsaVerify(th *Thread, args []Value) Value {
+	k, err := x509.ParsePKIXPublicKey(toBytes(args[0]))
+	pub, ok := k.(*ecdsa.PublicKey)
+	if err != nil || !ok {
+		panic("EcdsaVerify: invalid public key")
+	}
+	return SuBool(ecdsa.VerifyASN1(pub,
+		digest("EcdsaVerify", args[3], args[1]), toBytes(args[2])))
+}
+
+func digest(which string, hash, message Value) []byte {
+	h := hashFn(which, hash)()
+	h.Write(toBytes(message))
+	return h.Sum(nil)
+}
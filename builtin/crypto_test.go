@@ -0,0 +1,51 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestCrypto(t *testing.T) {
+	assert := assert.T(t)
+	hexOf := func(v Value) string {
+		return hex.EncodeToString([]byte(ToStr(v)))
+	}
+	sha1, sha256 := SuStr("sha1"), SuStr("sha256")
+	// RFC 4231 test case 2
+	assert.This(hexOf(Hmac(SuStr("what do ya want for nothing?"),
+		SuStr("Jefe"), sha256))).
+		Is("5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843")
+	// RFC 6070
+	assert.This(hexOf(Pbkdf2(nil, []Value{SuStr("password"), SuStr("salt"),
+		One, IntVal(20), sha1}))).
+		Is("0c60c80f961f0e71f3a9b524af6012062fe037a6")
+	assert.This(len(ToStr(Hkdf(nil, []Value{SuStr("secret"), EmptyStr,
+		SuStr("info"), IntVal(42), sha256})))).Is(42)
+	assert.This(len(ToStr(RandomBytes(IntVal(16))))).Is(16)
+
+	key := RandomBytes(IntVal(32))
+	ct := AesGcmEncrypt(SuStr("hello world"), key, SuStr("data"))
+	assert.This(AesGcmDecrypt(ct, key, SuStr("data"))).Is(SuStr("hello world"))
+	assert.This(func() { AesGcmDecrypt(ct, key, EmptyStr) }).
+		Panics("AesGcmDecrypt: cipher: message authentication failed")
+
+	msg := SuStr("message")
+	keys := Ed25519Keys().(*SuObject)
+	pub, priv := keys.Get(nil, SuStr("public")), keys.Get(nil, SuStr("private"))
+	sig := Ed25519Sign(priv, msg)
+	assert.This(Ed25519Verify(pub, msg, sig)).Is(True)
+	assert.This(Ed25519Verify(pub, SuStr("other"), sig)).Is(False)
+
+	keys = EcdsaKeys(SuStr("P256")).(*SuObject)
+	pub, priv = keys.Get(nil, SuStr("public")), keys.Get(nil, SuStr("private"))
+	sig = EcdsaSign(priv, msg, sha256)
+	assert.This(EcdsaVerify(nil, []Value{pub, msg, sig, sha256})).Is(True)
+	assert.This(EcdsaVerify(nil, []Value{pub, SuStr("other"), sig, sha256})).
+		Is(False)
+}
@@ -0,0 +1,237 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/decode"
+)
+
+// csvDelimiter returns the delimiter rune, e.g. ',' or '\t'
+func csvDelimiter(which string, delim Value) rune {
+	s := ToStr(delim)
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 || n != len(s) {
+		panic(which + ": delimiter must be a single character")
+	}
+	return r
+}
+
+type suCsvReader struct {
+	ValueBase[*suCsvReader]
+	cr     *csv.Reader
+	header []string
+}
+
+var _ = builtin(CsvReader, "(source, delimiter = ',', quote = '\"', "+
+	"header = true, encoding = '')")
+
+// CsvReader reads rows from a string or a stream (e.g. File).
+// If header is true the first row is the column names,
+// if it is a list it is the column names.
+// With column names, rows are returned as records,
+// otherwise they are returned as lists.
+// quote: false treats quotes as ordinary characters within fields.
+// A leading byte order mark is skipped.
+func CsvReader(th *Thread, args []Value) Value {
+	var r io.Reader
+	if s, ok := args[0].ToStr(); ok {
+		r = strings.NewReader(s)
+	} else {
+		r = sourceReader("CsvReader", args[0])
+	}
+	r, err := decode.Reader(r, ToStr(args[4]))
+	if err != nil {
+		panic("CsvReader: " + err.Error())
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = csvDelimiter("CsvReader", args[1])
+	switch {
+	case args[2] == False:
+		cr.LazyQuotes = true
+	case ToStr(args[2]) != `"`:
+		panic(`CsvReader: quote must be '"' or false`)
+	}
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	csr := &suCsvReader{cr: cr}
+	switch hdr := args[3]; {
+	case hdr == True:
+		row := csr.read()
+		if row == nil {
+			panic("CsvReader: missing header")
+		}
+		csr.header = append([]string(nil), row...)
+	case hdr != False:
+		ob := ToContainer(hdr)
+		for i := range ob.ListSize() {
+			csr.header = append(csr.header, ToStr(ob.ListGet(i)))
+		}
+	}
+	return csr
+}
+
+// read returns nil at the end
+func (csr *suCsvReader) read() []string {
+	row, err := csr.cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		panic("CsvReader: " + err.Error())
+	}
+	return row
+}
+
+var _ Value = (*suCsvReader)(nil)
+
+func (csr *suCsvReader) Equal(other any) bool {
+	return csr == other
+}
+
+func (*suCsvReader) Lookup(_ *Thread, method string) Value {
+	return suCsvReaderMethods[method]
+}
+
+var suCsvReaderMethods = methods("csvreader")
+
+var _ = method(csvreader_Next, "()")
+
+// csvreader_Next returns the next row, or the CsvReader itself at the end.
+// Missing fields are "", extra fields (past the header) are ignored.
+func csvreader_Next(this Value) Value {
+	csr := this.(*suCsvReader)
+	row := csr.read()
+	if row == nil {
+		return this
+	}
+	if csr.header == nil {
+		ob := &SuObject{}
+		for _, s := range row {
+			ob.Add(SuStr(s))
+		}
+		return ob
+	}
+	rec := NewSuRecord()
+	for i, col := range csr.header {
+		if i < len(row) && row[i] != "" {
+			rec.Set(SuStr(col), SuStr(row[i]))
+		}
+	}
+	return rec
+}
+
+var _ = method(csvreader_Header, "()")
+
+// csvreader_Header returns a list of the column names, or false
+func csvreader_Header(this Value) Value {
+	csr := this.(*suCsvReader)
+	if csr.header == nil {
+		return False
+	}
+	ob := &SuObject{}
+	for _, col := range csr.header {
+		ob.Add(SuStr(col))
+	}
+	return ob
+}
+
+//-------------------------------------------------------------------
+
+type suCsvWriter struct {
+	ValueBase[*suCsvWriter]
+	cw     *csv.Writer
+	header []Value
+}
+
+var _ = builtin(CsvWriter, "(dest, delimiter = ',', header = false, "+
+	"crlf = false, bom = false)")
+
+// CsvWriter writes rows to a stream (e.g. File).
+// If header is a list of column names, it is written first,
+// and rows are written by getting the values of those columns.
+// Otherwise rows are written from the list values.
+func CsvWriter(dest, delim, header, crlf, bom Value) Value {
+	tow, ok := dest.(writer)
+	if !ok {
+		panic("CsvWriter: can only write to file, pipe, or socket")
+	}
+	w := tow.writer()
+	if bom == True {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			panic("CsvWriter: " + err.Error())
+		}
+	}
+	csw := &suCsvWriter{cw: csv.NewWriter(w)}
+	csw.cw.Comma = csvDelimiter("CsvWriter", delim)
+	csw.cw.UseCRLF = crlf == True
+	if header != False {
+		ob := ToContainer(header)
+		row := make([]string, 0, ob.ListSize())
+		for i := range ob.ListSize() {
+			col := ob.ListGet(i)
+			csw.header = append(csw.header, col)
+			row = append(row, ToStr(col))
+		}
+		csw.write(row)
+	}
+	return csw
+}
+
+func (csw *suCsvWriter) write(row []string) {
+	csw.cw.Write(row)
+	csw.cw.Flush()
+	if err := csw.cw.Error(); err != nil {
+		panic("CsvWriter: " + err.Error())
+	}
+}
+
+var _ Value = (*suCsvWriter)(nil)
+
+func (csw *suCsvWriter) Equal(other any) bool {
+	return csw == other
+}
+
+func (*suCsvWriter) Lookup(_ *Thread, method string) Value {
+	return suCsvWriterMethods[method]
+}
+
+var suCsvWriterMethods = methods("csvwriter")
+
+var _ = method(csvwriter_Write, "(row)")
+
+// csvwriter_Write writes a row. Strings and numbers are written as is,
+// other values are written as their Display
+func csvwriter_Write(th *Thread, this Value, args []Value) Value {
+	csw := this.(*suCsvWriter)
+	ob := ToContainer(args[0])
+	field := func(v Value) string {
+		if v == nil {
+			return ""
+		}
+		if s, ok := v.AsStr(); ok {
+			return s
+		}
+		return Display(th, v)
+	}
+	var row []string
+	if csw.header != nil {
+		row = make([]string, len(csw.header))
+		for i, col := range csw.header {
+			row[i] = field(ob.GetIfPresent(th, col))
+		}
+	} else {
+		row = make([]string, ob.ListSize())
+		for i := range row {
+			row[i] = field(ob.ListGet(i))
+		}
+	}
+	csw.write(row)
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestCsv(t *testing.T) {
+	assert := assert.T(t)
+	var th Thread
+	filename := filepath.Join(t.TempDir(), "test.csv")
+	f := newSuFile(filename, "w")
+	cw := CsvWriter(f, SuStr(";"), SuObjectOfStrs([]string{"name", "n"}),
+		False, True)
+	row := &SuObject{}
+	row.Set(SuStr("name"), SuStr("Smith; Joe"))
+	row.Set(SuStr("n"), IntVal(12))
+	csvwriter_Write(&th, cw, []Value{row})
+	row = &SuObject{}
+	row.Set(SuStr("name"), SuStr(`"Al"`))
+	csvwriter_Write(&th, cw, []Value{row})
+	f.close()
+	b, _ := os.ReadFile(filename)
+	assert.This(string(b)).Is("\ufeffname;n\n\"Smith; Joe\";12\n\"\"\"Al\"\"\";\n")
+
+	f = newSuFile(filename, "r")
+	defer f.close()
+	cr := CsvReader(&th, []Value{f, SuStr(";"), SuStr(`"`), True, EmptyStr})
+	assert.This(Display(&th, csvreader_Header(cr))).Is(`#("name", 'n')`)
+	assert.This(Display(&th, csvreader_Next(cr))).Is(`[name: "Smith; Joe", n: "12"]`)
+	assert.This(Display(&th, csvreader_Next(cr))).Is(`[name: '"Al"']`)
+	assert.This(csvreader_Next(cr)).Is(cr)
+
+	cr = CsvReader(&th, []Value{SuStr("caf\xe9\tb\n"), SuStr("\t"), False,
+		False, SuStr("windows-1252")})
+	assert.This(Display(&th, csvreader_Next(cr))).Is(`#("caf\xc3\xa9", 'b')`)
+}
@@ -100,6 +100,21 @@ func db_Load(th *Thread, args []Value) Value {
 		SuObjectOf(SuStr("Database.Load"), args[0], args[1], args[2], args[3]))
 }
 
+var _ = staticMethod(db_LoadCsv,
+	"(table, from, delimiter = ',', encoding = '')")
+
+// db_LoadCsv replaces the contents of a table from a CSV file
+// (on the server) with a header row. It uses the same bulk path as Load
+// so it is not transactional, see tools.LoadCsv
+func db_LoadCsv(th *Thread, args []Value) Value {
+	if dbms, ok := th.Dbms().(*dbms.DbmsLocal); ok {
+		return IntVal(dbms.LoadCsv(ToStr(args[0]), ToStr(args[1]),
+			ToStr(args[2]), ToStr(args[3])))
+	}
+	return th.Dbms().Exec(th,
+		SuObjectOf(SuStr("Database.LoadCsv"), args[0], args[1], args[2], args[3]))
+}
+
 var _ = staticMethod(db_Nonce, "()")
 
 func db_Nonce(th *Thread, args []Value) Value {
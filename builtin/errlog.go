@@ -5,13 +5,67 @@ package builtin
 
 import (
 	"log"
+	"strings"
 
 	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/jsonlog"
 )
 
 var _ = builtin(ErrorLog, "(string)")
 
-func ErrorLog(arg Value) Value {
-	log.Println(ToStrOrString(arg))
+func ErrorLog(th *Thread, args []Value) Value {
+	th.Log(ToStrOrString(args[0]))
 	return nil
 }
+
+var _ = builtin(LogEvent, "(level, msg, fields = #())")
+
+// LogEvent writes a log entry with a level (debug, info, warn, or error)
+// and named fields. With structured logging (-jsonlog) the fields are
+// included in the JSON along with the session and thread name.
+// Otherwise it is logged like ErrorLog with the fields as name=value.
+func LogEvent(th *Thread, args []Value) Value {
+	level, ok := jsonlog.ParseLevel(ToStr(args[0]))
+	if !ok {
+		panic("LogEvent: level must be debug, info, warn, or error")
+	}
+	msg := ToStrOrString(args[1])
+	if jsonlog.On() {
+		attrs := []any{"source", jsonlog.Source(), "session", th.Session(),
+			"thread", th.Name}
+		iter := ToContainer(args[2]).Iter2(false, true)
+		for k, v := iter(); k != nil; k, v = iter() {
+			attrs = append(attrs, ToStr(k), logValue(th, v))
+		}
+		jsonlog.Log(level, msg, attrs...)
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.ToUpper(level.String()))
+	sb.WriteString(": ")
+	sb.WriteString(msg)
+	iter := ToContainer(args[2]).Iter2(false, true)
+	for k, v := iter(); k != nil; k, v = iter() {
+		sb.WriteString(" ")
+		sb.WriteString(ToStr(k))
+		sb.WriteString("=")
+		sb.WriteString(ToStrOrString(v))
+	}
+	log.Println(sb.String())
+	return nil
+}
+
+// logValue converts to a Go value so numbers and booleans
+// are not quoted in the JSON
+func logValue(th *Thread, v Value) any {
+	if n, ok := v.IfInt(); ok {
+		return n
+	}
+	if b, ok := v.(SuBool); ok {
+		return b == True
+	}
+	if s, ok := v.ToStr(); ok {
+		return s
+	}
+	return Display(th, v)
+}
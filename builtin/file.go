@@ -194,6 +194,10 @@ func (sf *suFile) writer() io.Writer {
 	return sfOpenWrite(sf).w
 }
 
+func (sf *suFile) reader() *bufio.Reader {
+	return sfOpenRead(sf).r
+}
+
 var _ = method(file_Readline, "()")
 
 func file_Readline(this Value) Value {
@@ -0,0 +1,203 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+// httpTransports are shared so connections are reused.
+// There is one per timeout since the timeouts are set on the transport.
+// They use the proxy from the environment (HTTPS_PROXY etc.)
+var httpTransports = struct {
+	lock sync.Mutex
+	m    map[time.Duration]*http.Transport
+}{m: make(map[time.Duration]*http.Transport)}
+
+// httpTransport returns the transport for a timeout.
+// The timeout applies to connecting and waiting for the response headers,
+// not to reading the body, so large responses can be streamed.
+func httpTransport(timeout time.Duration) *http.Transport {
+	httpTransports.lock.Lock()
+	defer httpTransports.lock.Unlock()
+	if t, ok := httpTransports.m[timeout]; ok {
+		return t
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: timeout,
+		KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = timeout
+	t.ResponseHeaderTimeout = timeout
+	httpTransports.m[timeout] = t
+	return t
+}
+
+type suHttpResponse struct {
+	ValueBase[*suHttpResponse]
+	resp   *http.Response
+	rdr    *bufio.Reader
+	cancel context.CancelFunc
+}
+
+var nHttpResponse atomic.Int32
+var _ = AddInfo("builtin.nHttpResponse", &nHttpResponse)
+
+var _ = builtin(HttpRequest,
+	"(method, url, headers = false, body = '', timeout = 60, block = false)")
+
+// HttpRequest sends a request and returns the response
+// with the body available via Read, Readline, and CopyTo.
+// The body argument may be a string or a File opened for reading.
+// Redirects are followed.
+// Cancelling the thread (e.g. Task.Cancel) aborts the request,
+// including reading the body.
+func HttpRequest(th *Thread, args []Value) Value {
+	var body io.Reader
+	if sf, ok := args[3].(*suFile); ok {
+		body = sfOpenRead(sf).r
+	} else if s := ToStr(args[3]); s != "" {
+		body = strings.NewReader(s)
+	}
+	ctx, cancel := threadContext(th)
+	req, err := http.NewRequestWithContext(ctx,
+		ToStr(args[0]), ToStr(args[1]), body)
+	if err != nil {
+		cancel()
+		panic("HttpRequest: " + err.Error())
+	}
+	if args[2] != False {
+		iter := ToContainer(args[2]).Iter2(false, true)
+		for k, v := iter(); k != nil; k, v = iter() {
+			req.Header.Add(ToStr(k), ToStr(v))
+		}
+	}
+	client := http.Client{
+		Transport: httpTransport(time.Duration(ToInt(args[4])) * time.Second)}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		th.CheckCancel()
+		panic("HttpRequest: " + err.Error())
+	}
+	hr := &suHttpResponse{resp: resp, rdr: bufio.NewReader(resp.Body),
+		cancel: cancel}
+	nHttpResponse.Add(1)
+	if args[5] == False {
+		return hr
+	}
+	// block form
+	defer hr.close()
+	return th.Call(args[5], hr)
+}
+
+func (hr *suHttpResponse) close() {
+	if hr.resp == nil {
+		return
+	}
+	nHttpResponse.Add(-1)
+	hr.resp.Body.Close()
+	hr.cancel()
+	hr.resp = nil
+}
+
+// threadContext returns a context that is cancelled when the thread is.
+// The cancel function must be called to release the goroutine.
+func threadContext(th *Thread) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if done := th.Cancelled(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+func hrOpen(this Value) *suHttpResponse {
+	hr := this.(*suHttpResponse)
+	if hr.resp == nil {
+		panic("can't use a closed HttpResponse")
+	}
+	return hr
+}
+
+func (hr *suHttpResponse) reader() *bufio.Reader {
+	return hrOpen(hr).rdr
+}
+
+var _ Value = (*suHttpResponse)(nil)
+
+func (hr *suHttpResponse) Equal(other any) bool {
+	return hr == other
+}
+
+func (*suHttpResponse) Lookup(_ *Thread, method string) Value {
+	return suHttpResponseMethods[method]
+}
+
+var suHttpResponseMethods = methods("httpresp")
+
+var _ = method(httpresp_Close, "()")
+
+func httpresp_Close(this Value) Value {
+	this.(*suHttpResponse).close()
+	return nil
+}
+
+var _ = method(httpresp_Status, "()")
+
+func httpresp_Status(this Value) Value {
+	return IntVal(hrOpen(this).resp.StatusCode)
+}
+
+var _ = method(httpresp_Header, "(name)")
+
+// httpresp_Header returns the first value of a response header
+// or "" if there isn't one
+func httpresp_Header(this, arg Value) Value {
+	return SuStr(hrOpen(this).resp.Header.Get(ToStr(arg)))
+}
+
+var _ = method(httpresp_Headers, "()")
+
+// httpresp_Headers returns an object of the response headers.
+// Multiple values are joined with ", "
+func httpresp_Headers(this Value) Value {
+	ob := &SuObject{}
+	for k, v := range hrOpen(this).resp.Header {
+		ob.Set(SuStr(k), SuStr(strings.Join(v, ", ")))
+	}
+	return ob
+}
+
+var _ = method(httpresp_Read, "(nbytes=false)")
+
+func httpresp_Read(this, arg Value) Value {
+	return limitedRead("httpResponse.Read", hrOpen(this).rdr, arg)
+}
+
+var _ = method(httpresp_Readline, "()")
+
+func httpresp_Readline(this Value) Value {
+	return Readline(hrOpen(this).rdr, "httpResponse.Readline: ")
+}
+
+var _ = method(httpresp_CopyTo, "(dest, nbytes = false)")
+
+func httpresp_CopyTo(th *Thread, this Value, args []Value) Value {
+	return CopyTo(th, hrOpen(this).rdr, args[0], args[1])
+}
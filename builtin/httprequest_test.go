@@ -0,0 +1,79 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestHttpRequest(t *testing.T) {
+	assert := assert.T(t)
+	stall := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/stall" {
+				io.WriteString(w, "a")
+				w.(http.Flusher).Flush()
+				<-stall
+				return
+			}
+			if r.URL.Path == "/slow" {
+				io.WriteString(w, "a")
+				w.(http.Flusher).Flush()
+				time.Sleep(1200 * time.Millisecond)
+				io.WriteString(w, "b")
+				return
+			}
+			if r.URL.Path == "/old" {
+				http.Redirect(w, r, "/new", http.StatusFound)
+				return
+			}
+			b, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Test", r.Header.Get("X-Test"))
+			io.WriteString(w, r.Method+" "+r.URL.Path+"\n"+string(b))
+		}))
+	defer srv.Close()
+	defer close(stall)
+	var th Thread
+	hdrs := &SuObject{}
+	hdrs.Set(SuStr("X-Test"), SuStr("hello"))
+	hr := HttpRequest(&th, []Value{SuStr("POST"), SuStr(srv.URL + "/x"),
+		hdrs, SuStr("data"), IntVal(10), False})
+	assert.This(httpresp_Status(hr)).Is(IntVal(200))
+	assert.This(httpresp_Header(hr, SuStr("x-test"))).Is(SuStr("hello"))
+	assert.This(httpresp_Readline(hr)).Is(SuStr("POST /x"))
+	assert.This(httpresp_Read(hr, False)).Is(SuStr("data"))
+	assert.This(httpresp_Read(hr, False)).Is(False)
+	httpresp_Close(hr)
+
+	hr = HttpRequest(&th, []Value{SuStr("GET"), SuStr(srv.URL + "/old"),
+		False, EmptyStr, IntVal(10), False})
+	assert.This(httpresp_Read(hr, False)).Is(SuStr("GET /new\n"))
+	httpresp_Close(hr)
+
+	// cancelling the thread interrupts a stalled body
+	th2 := NewThread(nil)
+	hr = HttpRequest(th2, []Value{SuStr("GET"), SuStr(srv.URL + "/stall"),
+		False, EmptyStr, IntVal(10), False})
+	time.AfterFunc(50*time.Millisecond, th2.Cancel)
+	assert.This(func() { httpresp_Read(hr, False) }).
+		Panics("context canceled")
+	httpresp_Close(hr)
+
+	if testing.Short() {
+		return
+	}
+	// the timeout does not apply to reading the body
+	hr = HttpRequest(&th, []Value{SuStr("GET"), SuStr(srv.URL + "/slow"),
+		False, EmptyStr, IntVal(1), False})
+	assert.This(httpresp_Read(hr, False)).Is(SuStr("ab"))
+	httpresp_Close(hr)
+}
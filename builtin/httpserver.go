@@ -0,0 +1,376 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/str"
+)
+
+// suHttpServer is returned by HttpServer.
+// The server runs in the background until Shutdown.
+type suHttpServer struct {
+	ValueBase[*suHttpServer]
+	srv  *http.Server
+	ln   net.Listener
+	sem  chan struct{} // limits the number of concurrent handlers
+	done chan struct{} // closed when Serve returns
+}
+
+var nHttpRequest atomic.Int32
+var _ = AddInfo("server.nHttpRequest", &nHttpRequest)
+
+var _ = builtin(HttpServer,
+	"(port, handler, workers = 100, certFile = false, keyFile = false, "+
+		"clientCAFile = false)")
+
+// HttpServer starts serving requests on port by calling
+// handler(request, response) on a new Thread for each request.
+// If the handler returns a string and has not written anything,
+// the string is written as the response.
+func HttpServer(th *Thread, args []Value) Value {
+	if OnUIThread() {
+		panic("HttpServer not allowed on UI thread")
+	}
+	handler := args[1]
+	handler.SetConcurrent()
+	workers := ToInt(args[2])
+	if workers <= 0 {
+		panic("HttpServer: workers must be > 0")
+	}
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(ToInt(args[0])))
+	if err != nil {
+		panic("HttpServer: " + err.Error())
+	}
+	if args[3] != False {
+		var clientCA string
+		if args[5] != False {
+			clientCA = ToStr(args[5])
+		}
+		cfg := tlsServerConfig("HttpServer", ToStr(args[3]), ToStr(args[4]),
+			clientCA)
+		ln = tls.NewListener(ln, cfg)
+	}
+	hs := &suHttpServer{ln: ln, sem: make(chan struct{}, workers),
+		done: make(chan struct{})}
+	suneido := th.Suneido.Load()
+	if suneido != nil {
+		suneido.SetConcurrent()
+	}
+	hs.srv = &http.Server{
+		ReadHeaderTimeout: 30 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hs.serve(suneido, handler, w, r)
+		})}
+	go func() {
+		defer close(hs.done)
+		err := hs.srv.Serve(ln)
+		if err != http.ErrServerClosed {
+			log.Println("ERROR: HttpServer:", err)
+		}
+	}()
+	return hs
+}
+
+func (hs *suHttpServer) serve(suneido *SuneidoObject, handler Value,
+	w http.ResponseWriter, r *http.Request) {
+	select {
+	case hs.sem <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	}
+	nHttpRequest.Add(1)
+	defer nHttpRequest.Add(-1)
+	th := NewThread(nil)
+	th.Name = str.BeforeFirst(th.Name, " ") + " HttpServer"
+	if suneido != nil {
+		th.Suneido.Store(suneido)
+	}
+	threads.add(th)
+	req := &suHttpServerRequest{r: r, rdr: bufio.NewReader(r.Body)}
+	resp := &suHttpResponseWriter{w: w, r: r, release: func() { <-hs.sem }}
+	defer func() {
+		resp.releaseWorker()
+		resp.w = nil // invalidate
+		th.Close()
+		threads.remove(th.Num)
+		if e := recover(); e != nil {
+			LogUncaught(th, "HttpServer", e)
+			if !resp.written {
+				http.Error(w, "internal server error",
+					http.StatusInternalServerError)
+			}
+		}
+	}()
+	result := th.Call(handler, req, resp)
+	if result != nil && !resp.written {
+		if s, ok := result.ToStr(); ok {
+			resp.write(s)
+		}
+	}
+}
+
+var _ Value = (*suHttpServer)(nil)
+
+func (hs *suHttpServer) Equal(other any) bool {
+	return hs == other
+}
+
+func (*suHttpServer) SetConcurrent() {
+	// ok for concurrent use
+}
+
+func (*suHttpServer) Lookup(_ *Thread, method string) Value {
+	return suHttpServerMethods[method]
+}
+
+var suHttpServerMethods = methods("httpserver")
+
+var _ = method(httpserver_Shutdown, "(secs = 10)")
+
+// httpserver_Shutdown stops accepting new requests and waits (up to secs)
+// for active requests to finish
+func httpserver_Shutdown(this, secs Value) Value {
+	hs := this.(*suHttpServer)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(ToInt(secs))*time.Second)
+	defer cancel()
+	err := hs.srv.Shutdown(ctx)
+	<-hs.done
+	if err != nil {
+		panic("HttpServer: Shutdown: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(httpserver_Port, "()")
+
+func httpserver_Port(this Value) Value {
+	return IntVal(this.(*suHttpServer).ln.Addr().(*net.TCPAddr).Port)
+}
+
+//-------------------------------------------------------------------
+
+type suHttpServerRequest struct {
+	ValueBase[*suHttpServerRequest]
+	r   *http.Request
+	rdr *bufio.Reader
+}
+
+var _ Value = (*suHttpServerRequest)(nil)
+
+func (hr *suHttpServerRequest) reader() *bufio.Reader {
+	return hr.rdr
+}
+
+func (hr *suHttpServerRequest) Equal(other any) bool {
+	return hr == other
+}
+
+func (*suHttpServerRequest) Lookup(_ *Thread, method string) Value {
+	return suHttpServerRequestMethods[method]
+}
+
+var suHttpServerRequestMethods = methods("httpreq")
+
+var _ = method(httpreq_Method, "()")
+
+func httpreq_Method(this Value) Value {
+	return SuStr(this.(*suHttpServerRequest).r.Method)
+}
+
+var _ = method(httpreq_Path, "()")
+
+func httpreq_Path(this Value) Value {
+	return SuStr(this.(*suHttpServerRequest).r.URL.Path)
+}
+
+var _ = method(httpreq_Query, "()")
+
+// httpreq_Query returns an object of the query parameters.
+// Multiple values are joined with ","
+func httpreq_Query(this Value) Value {
+	ob := &SuObject{}
+	for k, v := range this.(*suHttpServerRequest).r.URL.Query() {
+		ob.Set(SuStr(k), SuStr(strings.Join(v, ",")))
+	}
+	return ob
+}
+
+var _ = method(httpreq_Header, "(name)")
+
+func httpreq_Header(this, name Value) Value {
+	return SuStr(this.(*suHttpServerRequest).r.Header.Get(ToStr(name)))
+}
+
+var _ = method(httpreq_Headers, "()")
+
+func httpreq_Headers(this Value) Value {
+	ob := &SuObject{}
+	for k, v := range this.(*suHttpServerRequest).r.Header {
+		ob.Set(SuStr(k), SuStr(strings.Join(v, ", ")))
+	}
+	return ob
+}
+
+var _ = method(httpreq_RemoteUser, "()")
+
+// httpreq_RemoteUser returns the common name from the client certificate
+// if there is one, otherwise the remote address (like SocketServer)
+func httpreq_RemoteUser(this Value) Value {
+	r := this.(*suHttpServerRequest).r
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return SuStr(r.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+	return SuStr(str.BeforeLast(r.RemoteAddr, ":"))
+}
+
+var _ = method(httpreq_Read, "(nbytes=false)")
+
+func httpreq_Read(this, arg Value) Value {
+	return limitedRead("httpRequest.Read", this.(*suHttpServerRequest).rdr, arg)
+}
+
+var _ = method(httpreq_Readline, "()")
+
+func httpreq_Readline(this Value) Value {
+	return Readline(this.(*suHttpServerRequest).rdr, "httpRequest.Readline: ")
+}
+
+var _ = method(httpreq_CopyTo, "(dest, nbytes = false)")
+
+func httpreq_CopyTo(th *Thread, this Value, args []Value) Value {
+	return CopyTo(th, this.(*suHttpServerRequest).rdr, args[0], args[1])
+}
+
+//-------------------------------------------------------------------
+
+type suHttpResponseWriter struct {
+	ValueBase[*suHttpResponseWriter]
+	w       http.ResponseWriter
+	r       *http.Request
+	release func() // frees the worker slot, set to nil once called
+	written bool
+}
+
+// releaseWorker frees the HttpServer worker slot.
+// It is called when the handler finishes,
+// or earlier if the connection is upgraded to a WebSocket.
+func (hr *suHttpResponseWriter) releaseWorker() {
+	if hr.release != nil {
+		hr.release()
+		hr.release = nil
+	}
+}
+
+var _ Value = (*suHttpResponseWriter)(nil)
+
+func (hr *suHttpResponseWriter) Equal(other any) bool {
+	return hr == other
+}
+
+func (*suHttpResponseWriter) Lookup(_ *Thread, method string) Value {
+	return suHttpResponseWriterMethods[method]
+}
+
+func rwOpen(this Value) *suHttpResponseWriter {
+	hr := this.(*suHttpResponseWriter)
+	if hr.w == nil {
+		panic("can't use an HttpServer response after the handler returns")
+	}
+	return hr
+}
+
+func (hr *suHttpResponseWriter) write(s string) {
+	hr.written = true
+	if _, err := io.WriteString(hr.w, s); err != nil {
+		panic("httpResponse.Write: " + err.Error())
+	}
+}
+
+func (hr *suHttpResponseWriter) writer() io.Writer {
+	hr = rwOpen(hr)
+	hr.written = true
+	return readerFrom{hr.w}
+}
+
+// readerFrom is required by CopyTo
+type readerFrom struct {
+	io.Writer
+}
+
+func (rf readerFrom) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(rf.Writer, r)
+}
+
+var suHttpResponseWriterMethods = methods("respwriter")
+
+var _ = method(respwriter_SetStatus, "(code)")
+
+// respwriter_SetStatus must be called before any Write
+func respwriter_SetStatus(this, code Value) Value {
+	hr := rwOpen(this)
+	if hr.written {
+		panic("httpResponse.SetStatus: must be called before Write")
+	}
+	hr.written = true
+	hr.w.WriteHeader(ToInt(code))
+	return nil
+}
+
+var _ = method(respwriter_SetHeader, "(name, value)")
+
+func respwriter_SetHeader(this, name, value Value) Value {
+	rwOpen(this).w.Header().Set(ToStr(name), ToStr(value))
+	return nil
+}
+
+var _ = method(respwriter_Write, "(string)")
+
+func respwriter_Write(this, arg Value) Value {
+	rwOpen(this).write(AsStr(arg))
+	return nil
+}
+
+var _ = method(respwriter_Flush, "()")
+
+// respwriter_Flush sends what has been written so far.
+// Without a Content-Length header the response will be chunked.
+func respwriter_Flush(this Value) Value {
+	hr := rwOpen(this)
+	hr.written = true
+	if err := http.NewResponseController(hr.w).Flush(); err != nil {
+		panic("httpResponse.Flush: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(respwriter_WebSocket, "()")
+
+// respwriter_WebSocket upgrades the request to a WebSocket connection.
+// The connection stays open after the handler returns.
+// The worker is released so long lived connections
+// do not prevent handling other requests.
+func respwriter_WebSocket(this Value) Value {
+	hr := rwOpen(this)
+	if hr.written {
+		panic("httpResponse.WebSocket: must be called before Write")
+	}
+	hr.written = true
+	ws := wsUpgrade(hr.w, hr.r)
+	hr.releaseWorker()
+	return ws
+}
@@ -0,0 +1,54 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/compile"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestHttpServer(t *testing.T) {
+	assert := assert.T(t)
+	handler := compile.Constant(`function (req, resp) {
+		if req.Path() is "/stream"
+			{
+			resp.SetHeader("X-Test", "yes")
+			resp.Write("one ")
+			resp.Flush()
+			resp.Write("two")
+			return
+			}
+		if req.Path() is "/fail"
+			throw "oops"
+		return req.Method() $ " " $ req.Query().x $ " " $ req.Read()
+	}`)
+	var th Thread
+	hs := HttpServer(&th, []Value{Zero, handler, IntVal(2), False, False, False})
+	defer httpserver_Shutdown(hs, One)
+	url := "http://localhost:" + strconv.Itoa(ToInt(httpserver_Port(hs)))
+
+	resp, err := http.Post(url+"/?x=123", "text/plain", strings.NewReader("body"))
+	assert.This(err).Is(nil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.This(string(b)).Is("POST 123 body")
+
+	resp, _ = http.Get(url + "/stream")
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.This(string(b)).Is("one two")
+	assert.This(resp.Header.Get("X-Test")).Is("yes")
+	assert.This(resp.TransferEncoding).Is([]string{"chunked"})
+
+	resp, _ = http.Get(url + "/fail")
+	resp.Body.Close()
+	assert.This(resp.StatusCode).Is(500)
+}
@@ -0,0 +1,475 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/core/types"
+)
+
+// JsonEncode and JsonDecode match the stdlib Json class
+// except that dates are encoded as ISO strings.
+// Strings are treated as bytes, only \uXXXX escapes are converted to UTF-8.
+
+var _ = builtin(JsonEncode, "(value, pretty = false, sortKeys = true)")
+
+func JsonEncode(value, pretty, sortKeys Value) Value {
+	je := jsonEncoder{pretty: ToBool(pretty), sortKeys: ToBool(sortKeys)}
+	je.encode(value, 0)
+	return SuStr(je.sb.String())
+}
+
+type jsonEncoder struct {
+	sb       strings.Builder
+	pretty   bool
+	sortKeys bool
+}
+
+func (je *jsonEncoder) encode(x Value, depth int) {
+	if depth > 100 {
+		panic("JsonEncode: nesting too deep")
+	}
+	if ob, ok := x.ToContainer(); ok {
+		if ob.NamedSize() > 0 {
+			je.object(ob, depth)
+		} else {
+			je.array(ob, depth)
+		}
+		return
+	}
+	switch x.Type() {
+	case types.Number:
+		s := x.String()
+		if strings.HasPrefix(s, ".") {
+			s = "0" + s
+		} else if strings.HasPrefix(s, "-.") {
+			s = "-0" + s[1:]
+		}
+		je.sb.WriteString(s)
+	case types.Boolean:
+		je.sb.WriteString(x.String())
+	case types.Date:
+		d := x.(SuDate)
+		je.str(fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d.%03d",
+			d.Year(), d.Month(), d.Day(),
+			d.Hour(), d.Minute(), d.Second(), d.Millisecond()))
+	default:
+		je.str(ToStrOrString(x))
+	}
+}
+
+func (je *jsonEncoder) array(ob Container, depth int) {
+	je.sb.WriteByte('[')
+	n := ob.ListSize()
+	for i := range n {
+		je.sep(i, depth+1)
+		je.encode(ob.ListGet(i), depth+1)
+	}
+	je.end(n, depth, ']')
+}
+
+func (je *jsonEncoder) object(ob Container, depth int) {
+	var keys, vals []Value
+	iter := ob.Iter2(true, true)
+	for k, v := iter(); k != nil; k, v = iter() {
+		keys = append(keys, k)
+		vals = append(vals, v)
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	if je.sortKeys {
+		slices.SortStableFunc(order, func(i, j int) int {
+			return keys[i].Compare(keys[j])
+		})
+	}
+	je.sb.WriteByte('{')
+	for i, k := range order {
+		je.sep(i, depth+1)
+		je.str(ToStrOrString(keys[k]))
+		je.sb.WriteByte(':')
+		if je.pretty {
+			je.sb.WriteByte(' ')
+		}
+		je.encode(vals[k], depth+1)
+	}
+	je.end(len(keys), depth, '}')
+}
+
+func (je *jsonEncoder) sep(i int, depth int) {
+	if i > 0 {
+		je.sb.WriteByte(',')
+	}
+	je.indent(depth)
+}
+
+func (je *jsonEncoder) end(n int, depth int, c byte) {
+	if n > 0 {
+		je.indent(depth)
+	}
+	je.sb.WriteByte(c)
+}
+
+func (je *jsonEncoder) indent(depth int) {
+	if je.pretty {
+		je.sb.WriteByte('\n')
+		for range depth {
+			je.sb.WriteString("  ")
+		}
+	}
+}
+
+// str escapes double quote, backslash, and control characters
+func (je *jsonEncoder) str(s string) {
+	const hex = "0123456789abcdef"
+	je.sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			je.sb.WriteByte('\\')
+			je.sb.WriteByte(c)
+		case '\t':
+			je.sb.WriteString(`\t`)
+		case '\n':
+			je.sb.WriteString(`\n`)
+		case '\r':
+			je.sb.WriteString(`\r`)
+		default:
+			if c < ' ' {
+				je.sb.WriteString(`\u00`)
+				je.sb.WriteByte(hex[c>>4])
+				je.sb.WriteByte(hex[c&0xf])
+			} else {
+				je.sb.WriteByte(c)
+			}
+		}
+	}
+	je.sb.WriteByte('"')
+}
+
+//-------------------------------------------------------------------
+
+var _ = builtin(JsonDecode, "(string, handleNull = 'throw')")
+
+// JsonDecode handleNull should be 'throw', 'empty' (treat as ""),
+// or 'skip' (omit the member)
+func JsonDecode(arg, handleNull Value) (result Value) {
+	defer jsonError()
+	s, ok := arg.ToStr()
+	if !ok {
+		panic("string required")
+	}
+	jd := newJsonDecoder(strings.NewReader(s), handleNull)
+	result = jd.value()
+	if result == nil {
+		panic(jsonNull)
+	}
+	if jd.next() != 0 {
+		panic("extra text at end")
+	}
+	return result
+}
+
+const jsonNull = "data should not contain null"
+
+func jsonError() {
+	if e := recover(); e != nil {
+		panic("Invalid Json format: " + fmt.Sprint(e))
+	}
+}
+
+type jsonDecoder struct {
+	r      io.ByteScanner
+	ifNull string
+}
+
+func newJsonDecoder(r io.ByteScanner, handleNull Value) *jsonDecoder {
+	ifNull := ToStr(handleNull)
+	if ifNull != "throw" && ifNull != "empty" && ifNull != "skip" {
+		panic("handleNull must be 'throw', 'empty', or 'skip'")
+	}
+	return &jsonDecoder{r: r, ifNull: ifNull}
+}
+
+// next skips whitespace and returns the next byte, or 0 at the end
+func (jd *jsonDecoder) next() byte {
+	for {
+		c, err := jd.r.ReadByte()
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c
+		}
+	}
+}
+
+func (jd *jsonDecoder) must() byte {
+	c := jd.next()
+	if c == 0 {
+		panic("unexpected end of string")
+	}
+	return c
+}
+
+// value returns the next value, or nil for a skipped null
+func (jd *jsonDecoder) value() Value {
+	c := jd.must()
+	switch {
+	case c == '{':
+		return jd.object()
+	case c == '[':
+		ob := &SuObject{}
+		if jd.peek() == ']' {
+			jd.next()
+			return ob
+		}
+		for {
+			if v := jd.value(); v != nil {
+				ob.Add(v)
+			}
+			if !jd.comma(']') {
+				return ob
+			}
+		}
+	case c == '"':
+		return SuStr(jd.str())
+	case c == '-' || ('0' <= c && c <= '9'):
+		return jd.number(c)
+	case 'a' <= c && c <= 'z':
+		return jd.ident(c)
+	}
+	panic("unexpected: " + string(c))
+}
+
+func (jd *jsonDecoder) object() Value {
+	ob := &SuObject{}
+	if jd.peek() == '}' {
+		jd.next()
+		return ob
+	}
+	for {
+		if jd.must() != '"' {
+			panic("member name must be a string")
+		}
+		name := jd.str()
+		if jd.must() != ':' {
+			panic("missing ':'")
+		}
+		if v := jd.value(); v != nil {
+			ob.Set(SuStr(name), v)
+		}
+		if !jd.comma('}') {
+			return ob
+		}
+	}
+}
+
+// comma returns true for a comma, false for the end delimiter
+func (jd *jsonDecoder) comma(end byte) bool {
+	switch jd.must() {
+	case ',':
+		return true
+	case end:
+		return false
+	}
+	panic("missing comma")
+}
+
+func (jd *jsonDecoder) peek() byte {
+	c := jd.next()
+	if c != 0 {
+		jd.r.UnreadByte()
+	}
+	return c
+}
+
+func (jd *jsonDecoder) read() byte {
+	c, err := jd.r.ReadByte()
+	if err != nil {
+		panic("unexpected end of string")
+	}
+	return c
+}
+
+func (jd *jsonDecoder) str() string {
+	var sb strings.Builder
+	for {
+		c := jd.read()
+		switch c {
+		case '"':
+			return sb.String()
+		case '\\':
+			switch c = jd.read(); c {
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				r := jd.hex4()
+				if utf16.IsSurrogate(r) {
+					if jd.read() != '\\' || jd.read() != 'u' {
+						panic("invalid surrogate pair")
+					}
+					r = utf16.DecodeRune(r, jd.hex4())
+				}
+				sb.WriteString(string(utf8.AppendRune(nil, r)))
+			default: // includes " \ /
+				sb.WriteByte(c)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+func (jd *jsonDecoder) hex4() rune {
+	var buf [4]byte
+	for i := range buf {
+		buf[i] = jd.read()
+	}
+	n, err := strconv.ParseUint(string(buf[:]), 16, 16)
+	if err != nil {
+		panic("invalid \\u escape")
+	}
+	return rune(n)
+}
+
+func (jd *jsonDecoder) number(c byte) Value {
+	buf := []byte{c}
+	for {
+		c, err := jd.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if !strings.ContainsRune("0123456789.eE+-", rune(c)) {
+			jd.r.UnreadByte()
+			break
+		}
+		buf = append(buf, c)
+	}
+	s := string(buf)
+	if s == "-" || strings.HasPrefix(s, "--") {
+		panic("unexpected: " + s)
+	}
+	defer func() {
+		if recover() != nil {
+			panic("invalid number: " + s)
+		}
+	}()
+	return NumFromString(s)
+}
+
+func (jd *jsonDecoder) ident(c byte) Value {
+	buf := []byte{c}
+	for {
+		c, err := jd.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if c < 'a' || 'z' < c {
+			jd.r.UnreadByte()
+			break
+		}
+		buf = append(buf, c)
+	}
+	switch s := string(buf); s {
+	case "true":
+		return True
+	case "false":
+		return False
+	case "null":
+		switch jd.ifNull {
+		case "empty":
+			return EmptyStr
+		case "skip":
+			return nil
+		}
+		panic(jsonNull)
+	default:
+		panic("unexpected: " + s)
+	}
+}
+
+//-------------------------------------------------------------------
+
+// suJsonReader iterates through the elements of a top level JSON array
+// from a File, SocketClient, RunPiped, or HTTP request or response
+// without reading the entire array into memory.
+type suJsonReader struct {
+	ValueBase[*suJsonReader]
+	jd    *jsonDecoder
+	state byte // 0 = before [, 1 = in array, 2 = done
+}
+
+var _ = builtin(JsonReader, "(source, handleNull = 'throw')")
+
+func JsonReader(source, handleNull Value) Value {
+	return &suJsonReader{jd: newJsonDecoder(sourceReader("JsonReader", source),
+		handleNull)}
+}
+
+var _ Value = (*suJsonReader)(nil)
+
+func (jr *suJsonReader) Equal(other any) bool {
+	return jr == other
+}
+
+func (*suJsonReader) Lookup(_ *Thread, method string) Value {
+	return suJsonReaderMethods[method]
+}
+
+var suJsonReaderMethods = methods("jsonreader")
+
+var _ = method(jsonreader_Next, "()")
+
+// jsonreader_Next returns the next element of the array,
+// or the JsonReader itself when there are no more
+func jsonreader_Next(this Value) (result Value) {
+	defer jsonError()
+	jr := this.(*suJsonReader)
+	jd := jr.jd
+	for {
+		switch jr.state {
+		case 0:
+			if jd.must() != '[' {
+				panic("JsonReader requires an array")
+			}
+			jr.state = 1
+			if jd.peek() == ']' {
+				jd.next()
+				jr.state = 2
+			}
+			continue
+		case 1:
+			v := jd.value()
+			if !jd.comma(']') {
+				jr.state = 2
+			}
+			if v == nil { // skipped null
+				continue
+			}
+			return v
+		}
+		return this
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/compile"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestJson(t *testing.T) {
+	test := func(val, json string) {
+		t.Helper()
+		v := compile.Constant(val)
+		assert.T(t).This(JsonEncode(v, False, True)).Is(SuStr(json))
+		assert.T(t).This(JsonDecode(SuStr(json), SuStr("throw"))).Is(v)
+	}
+	test(`true`, `true`)
+	test(`123`, `123`)
+	test(`-1e99`, `-1e99`)
+	test(`.5`, `0.5`)
+	test(`-.5`, `-0.5`)
+	test(`1.234567890123456`, `1.234567890123456`)
+	test(`"foo bar"`, `"foo bar"`)
+	test(`#()`, `[]`)
+	test(`#(1, 2, (3, 4))`, `[1,2,[3,4]]`)
+	test(`#(b: "\\", a: '"')`, `{"a":"\"","b":"\\"}`)
+	test(`#(a: "x\r\n\ty\x01")`, `{"a":"x\r\n\ty\u0001"}`)
+	test(`#(a: 1, b: #(c: 33, d: 'dddd'))`, `{"a":1,"b":{"c":33,"d":"dddd"}}`)
+
+	assert.T(t).This(JsonEncode(DateFromLiteral("#20240102.030405006"),
+		False, True)).Is(SuStr(`"2024-01-02T03:04:05.006"`))
+	assert.T(t).This(JsonEncode(compile.Constant(`#(1, a: (2))`), True, True)).
+		Is(SuStr("{\n  \"0\": 1,\n  \"a\": [\n    2\n  ]\n}"))
+	assert.T(t).This(JsonDecode(SuStr(`"A\u0026B \u2013"`), SuStr("throw"))).
+		Is(SuStr("A&B \xE2\x80\x93"))
+	assert.T(t).This(JsonDecode(SuStr(`{"a": null, "b": [1, null]}`),
+		SuStr("skip"))).Is(compile.Constant(`#(b: (1))`))
+	assert.T(t).This(func() { JsonDecode(SuStr(`[1, null]`), SuStr("throw")) }).
+		Panics("Invalid Json format: data should not contain null")
+	assert.T(t).This(func() { JsonDecode(SuStr(`{"a": 1} extra`), SuStr("throw")) }).
+		Panics("Invalid Json format: extra text at end")
+
+	jr := &suJsonReader{jd: newJsonDecoder(
+		bufio.NewReader(strings.NewReader(`[1, {"a": 2}, null]`)), SuStr("skip"))}
+	assert.T(t).This(jsonreader_Next(jr)).Is(One)
+	assert.T(t).This(jsonreader_Next(jr)).Is(compile.Constant(`#(a: 2)`))
+	assert.T(t).This(jsonreader_Next(jr)).Is(jr)
+	assert.T(t).This(jsonreader_Next(jr)).Is(jr)
+}
@@ -8,9 +8,21 @@ import (
 	"github.com/apmckinlay/gsuneido/util/str"
 )
 
-var _ = builtin(Name, "(value)")
+var _ = builtin(Name, "(value, version = false)")
 
-func Name(arg Value) Value {
+// Name returns the name of a function or class,
+// or with version: true, the version (record offset) of the library record
+// it was compiled from (or "" if it did not come from a library)
+func Name(arg, version Value) Value {
+	if version == True {
+		switch x := arg.(type) {
+		case *SuFunc:
+			return SuStr(x.Version)
+		case *SuClass:
+			return SuStr(x.Version)
+		}
+		return EmptyStr
+	}
 	if named, ok := arg.(Named); ok {
 		return SuStr(str.AfterFirst(named.GetName(), ":"))
 	}
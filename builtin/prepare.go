@@ -0,0 +1,59 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+// suPrepared is the result of Prepare(query).
+// It is used like transaction.Query(prepared, name: value ...)
+type suPrepared struct {
+	ValueBase[suPrepared]
+	query  string
+	handle int
+}
+
+var _ = builtin(Prepare, "(query)")
+
+func Prepare(th *Thread, args []Value) Value {
+	query := ToStr(args[0])
+	return suPrepared{query: query, handle: th.Dbms().Prepare(query)}
+}
+
+var _ Value = suPrepared{}
+
+func (p suPrepared) Equal(other any) bool {
+	return p == other
+}
+
+func (p suPrepared) String() string {
+	return "Prepare(" + SuStr(p.query).String() + ")"
+}
+
+// preparedArgs handles Query(prepared, [block], name: value ...)
+// The named arguments (except a non-string block) are the parameters.
+func preparedArgs(as *ArgSpec, args []Value) (
+	p suPrepared, params map[string]Value, block Value, ok bool) {
+	iter := NewArgsIter(as, args)
+	k, v := iter()
+	if p, ok = v.(suPrepared); !ok || k != nil {
+		return
+	}
+	block = False
+	params = make(map[string]Value)
+	for k, v := iter(); v != nil; k, v = iter() {
+		if k == nil {
+			block = v
+			continue
+		}
+		field := ToStr(k)
+		if field == "block" && !stringable(v) {
+			block = v
+			continue
+		}
+		params[field] = v
+	}
+	return
+}
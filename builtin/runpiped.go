@@ -4,6 +4,7 @@
 package builtin
 
 import (
+	"bufio"
 	"errors"
 	"io"
 	"os/exec"
@@ -18,6 +19,7 @@ type suRunPiped struct {
 	ValueBase[*suRunPiped]
 	w       io.WriteCloser
 	r       io.ReadCloser
+	rdr     *bufio.Reader // buffers r, used for all reads
 	cmd     *exec.Cmd
 	command string
 }
@@ -53,7 +55,8 @@ func RunPiped(th *Thread, args []Value) Value {
 	if err != nil {
 		panic("Runpiped: failed to start: " + err.Error())
 	}
-	rp := &suRunPiped{command: command, cmd: cmd, w: w, r: r}
+	rp := &suRunPiped{command: command, cmd: cmd, w: w, r: r,
+		rdr: bufio.NewReader(r)}
 	nRunPiped.Add(1)
 	if args[1] == False {
 		return rp
@@ -162,7 +165,7 @@ func runpiped_Flush(this Value) Value {
 var _ = method(runpiped_Read, "(nbytes=false)")
 
 func runpiped_Read(this, arg Value) Value {
-	return limitedRead("runpiped.Read", rpOpen(this).r, arg)
+	return limitedRead("runpiped.Read", rpOpen(this).rdr, arg)
 }
 
 func limitedRead(which string, r io.Reader, arg Value) Value {
@@ -189,7 +192,7 @@ func limitedRead(which string, r io.Reader, arg Value) Value {
 var _ = method(runpiped_Readline, "()")
 
 func runpiped_Readline(this Value) Value {
-	return Readline(rpOpen(this).r, "runPiped.Readline: ")
+	return Readline(rpOpen(this).rdr, "runPiped.Readline: ")
 }
 
 var _ = method(runpiped_Write, "(string)")
@@ -216,13 +219,17 @@ func runpiped_Writeline(this, arg Value) Value {
 var _ = method(runpiped_CopyTo, "(dest, nbytes = false)")
 
 func runpiped_CopyTo(th *Thread, this Value, args []Value) Value {
-	return CopyTo(th, rpOpen(this).r, args[0], args[1])
+	return CopyTo(th, rpOpen(this).rdr, args[0], args[1])
 }
 
 func (rp *suRunPiped) writer() io.Writer {
 	return rpWrite(rp).w
 }
 
+func (rp *suRunPiped) reader() *bufio.Reader {
+	return rpOpen(rp).rdr
+}
+
 var newline = func() string {
 	if runtime.GOOS == "windows" {
 		return "\r\n"
@@ -4,8 +4,10 @@
 package builtin
 
 import (
+	"runtime"
 	"testing"
 
+	. "github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/util/assert"
 )
 
@@ -36,3 +38,19 @@ func TestSplitCommand(t *testing.T) {
 	test(`cmd "last arg"`, []string{"cmd", "last arg"})
 	test(`"only quoted"`, []string{"only quoted"})
 }
+
+func TestRunPipedSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	assert := assert.T(t)
+	var th Thread
+	rp := RunPiped(&th, []Value{SuStr(`printf "[1, 2]rest\n"`), False})
+	defer runpiped_Close(rp)
+	jr := JsonReader(rp, SuStr("throw"))
+	assert.This(jsonreader_Next(jr)).Is(One)
+	assert.This(jsonreader_Next(jr)).Is(IntVal(2))
+	assert.This(jsonreader_Next(jr)).Is(jr)
+	// the data buffered by JsonReader is not lost
+	assert.This(runpiped_Readline(rp)).Is(SuStr("rest"))
+}
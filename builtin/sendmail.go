@@ -0,0 +1,198 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+var _ = builtin(SendMail, "(server, from, to, message, port = 587, "+
+	"auth = false, tls = 'auto', timeout = 60, serverName = false, "+
+	"caFile = false)")
+
+// SendMail sends a message (e.g. from Email_CreateMIME) to one or more
+// recipients (a string or a list).
+//
+// tls is 'auto' (implicit TLS on port 465, otherwise STARTTLS if supported),
+// 'starttls' (required), 'implicit', or false.
+//
+// auth is an object with user and either password (for 'plain' or 'login')
+// or token (for 'xoauth2'), and optionally method (default 'plain').
+// Credentials are only sent over TLS unless tls is false.
+func SendMail(th *Thread, args []Value) Value {
+	host := ToStr(args[0])
+	from := ToStr(args[1])
+	var to []string
+	if s, ok := args[2].ToStr(); ok {
+		to = []string{s}
+	} else {
+		ob := ToContainer(args[2])
+		for i := range ob.ListSize() {
+			to = append(to, ToStr(ob.ListGet(i)))
+		}
+	}
+	if len(to) == 0 {
+		panic("SendMail: no recipients")
+	}
+	msg := ToStr(args[3])
+	port := ToInt(args[4])
+	mode := "none"
+	if args[6] != False {
+		mode = ToStr(args[6])
+		switch mode {
+		case "auto", "starttls", "implicit":
+		default:
+			panic("SendMail: tls must be 'auto', 'starttls', 'implicit', or false")
+		}
+		if mode == "auto" && port == 465 {
+			mode = "implicit"
+		}
+	}
+	var auth smtp.Auth
+	if args[5] != False {
+		auth = smtpAuth(th, args[5], host)
+		if mode == "none" {
+			auth = unencryptedAuth{auth}
+		}
+	}
+	timeout := time.Duration(ToInt(args[7])) * time.Second
+	tlsConfig := func() *tls.Config {
+		return tlsClientConfig("SendMail", host, args[8], args[9])
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		panic("SendMail: " + err.Error())
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if mode == "implicit" {
+		conn = tls.Client(conn, tlsConfig())
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		panic("SendMail: " + err.Error())
+	}
+	defer c.Close()
+	ck := func(err error) {
+		if err != nil {
+			panic("SendMail: " + err.Error())
+		}
+	}
+	if mode == "auto" || mode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			ck(c.StartTLS(tlsConfig()))
+		} else if mode == "starttls" || auth != nil {
+			panic("SendMail: server does not support STARTTLS")
+		}
+	}
+	if auth != nil {
+		ck(c.Auth(auth))
+	}
+	ck(c.Mail(from))
+	for _, rcpt := range to {
+		ck(c.Rcpt(rcpt))
+	}
+	w, err := c.Data()
+	ck(err)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\r\n"
+	}
+	_, err = w.Write([]byte(msg))
+	ck(err)
+	ck(w.Close())
+	ck(c.Quit())
+	return nil
+}
+
+func smtpAuth(th *Thread, auth Value, host string) smtp.Auth {
+	ob := ToContainer(auth)
+	get := func(key string) string {
+		if v := ob.GetIfPresent(th, SuStr(key)); v != nil {
+			return ToStr(v)
+		}
+		return ""
+	}
+	user := get("user")
+	switch strings.ToLower(get("method")) {
+	case "", "plain":
+		return smtp.PlainAuth("", user, get("password"), host)
+	case "login":
+		return &loginAuth{user: user, password: get("password")}
+	case "xoauth2":
+		return &xoauth2Auth{user: user, token: get("token")}
+	}
+	panic("SendMail: auth method must be 'plain', 'login', or 'xoauth2'")
+}
+
+// unencryptedAuth allows authenticating without TLS.
+// It is only used when the caller specifies tls: false.
+type unencryptedAuth struct {
+	smtp.Auth
+}
+
+func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	si := *server
+	si.TLS = true
+	return a.Auth.Start(&si)
+}
+
+// loginAuth implements the (non-standard but common) LOGIN mechanism.
+// Like smtp.PlainAuth it requires TLS except to localhost.
+type loginAuth struct {
+	user, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:", "user name", "username":
+		return []byte(a.user), nil
+	case "password:", "password":
+		return []byte(a.password), nil
+	}
+	return nil, errors.New("unexpected server challenge: " + string(fromServer))
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism (e.g. Gmail, Office 365)
+type xoauth2Auth struct {
+	user, token string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	resp := "user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// error details, respond with empty to get the final error
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
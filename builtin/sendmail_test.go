@@ -0,0 +1,121 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+// smtpServer is a minimal stand-in SMTP server that handles one session
+// and records the commands and message it receives
+func smtpServer(t *testing.T) (port int, done chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t).This(err).Is(nil)
+	done = make(chan []string, 1)
+	go func() {
+		defer ln.Close()
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var log []string
+		r := bufio.NewReader(c)
+		reply := func(s string) { c.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+			switch cmd {
+			case "EHLO":
+				reply("250-localhost\r\n250-AUTH PLAIN LOGIN XOAUTH2\r\n250 8BITMIME")
+			case "AUTH":
+				b, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+				log = append(log, "AUTH "+strings.ReplaceAll(string(b), "\x00", "|"))
+				reply("235 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var msg strings.Builder
+				for {
+					s, _ := r.ReadString('\n')
+					if s == ".\r\n" {
+						break
+					}
+					msg.WriteString(s)
+				}
+				log = append(log, "DATA "+msg.String())
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				done <- log
+				return
+			default:
+				log = append(log, line)
+				reply("250 ok")
+			}
+		}
+		done <- log
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, done
+}
+
+func TestSendMail(t *testing.T) {
+	port, done := smtpServer(t)
+	auth := &SuObject{}
+	auth.Set(SuStr("user"), SuStr("joe"))
+	auth.Set(SuStr("password"), SuStr("secret"))
+	var th Thread
+	SendMail(&th, []Value{SuStr("127.0.0.1"), SuStr("joe@example.com"),
+		SuObjectOf(SuStr("a@example.com"), SuStr("b@example.com")),
+		SuStr("Subject: test\r\n\r\nhello"), IntVal(port), auth,
+		False, IntVal(10), False, False})
+	assert.T(t).This(<-done).Is([]string{
+		"AUTH |joe|secret",
+		"MAIL FROM:<joe@example.com> BODY=8BITMIME",
+		"RCPT TO:<a@example.com>",
+		"RCPT TO:<b@example.com>",
+		"DATA Subject: test\r\n\r\nhello\r\n",
+	})
+
+	port, _ = smtpServer(t)
+	assert.T(t).This(func() {
+		SendMail(&th, []Value{SuStr("127.0.0.1"), SuStr("joe@example.com"),
+			SuStr("a@example.com"), SuStr("hello"), IntVal(port), False,
+			SuStr("starttls"), IntVal(10), False, False})
+	}).Panics("SendMail: server does not support STARTTLS")
+
+	// auto does not fall back to sending credentials unencrypted
+	port, done = smtpServer(t)
+	assert.T(t).This(func() {
+		SendMail(&th, []Value{SuStr("127.0.0.1"), SuStr("joe@example.com"),
+			SuStr("a@example.com"), SuStr("hello"), IntVal(port), auth,
+			SuStr("auto"), IntVal(10), False, False})
+	}).Panics("SendMail: server does not support STARTTLS")
+	assert.T(t).This(<-done).Is([]string(nil))
+
+	// arguments are checked before connecting
+	assert.T(t).This(func() {
+		SendMail(&th, []Value{SuStr("127.0.0.1"), SuStr("joe@example.com"),
+			SuStr("a@example.com"), SuStr("hello"), One, False,
+			SuStr("yes"), IntVal(10), False, False})
+	}).Panics("SendMail: tls must be")
+	badAuth := &SuObject{}
+	badAuth.Set(SuStr("method"), SuStr("digest"))
+	assert.T(t).This(func() {
+		SendMail(&th, []Value{SuStr("127.0.0.1"), SuStr("joe@example.com"),
+			SuStr("a@example.com"), SuStr("hello"), One, badAuth,
+			False, IntVal(10), False, False})
+	}).Panics("SendMail: auth method must be")
+}
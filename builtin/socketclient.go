@@ -5,6 +5,7 @@ package builtin
 
 import (
 	"bufio"
+	"crypto/tls"
 	"io"
 	"net"
 	"strconv"
@@ -16,8 +17,9 @@ import (
 
 type suSocketClient struct {
 	ValueBase[*suSocketClient]
-	conn    *net.TCPConn
+	conn    net.Conn // *net.TCPConn or *tls.Conn
 	rdr     *bufio.Reader
+	host    string // used for tls server name verification
 	timeout time.Duration
 }
 
@@ -25,12 +27,15 @@ var nSocketClient atomic.Int32
 var _ = AddInfo("builtin.nSocketClient", &nSocketClient)
 
 var _ = builtin(SocketClient,
-	"(ipaddress, port, timeout=60, timeoutConnect=0, block=false)")
+	"(ipaddress, port, timeout=60, timeoutConnect=0, block=false, "+
+		"tls=false, serverName=false, caFile=false)")
 
+// SocketClient with tls: true uses implicit TLS.
+// StartTLS can be used to upgrade an existing connection.
 func SocketClient(th *Thread, args []Value) Value {
-	ipaddr := ToStr(args[0])
+	host := ToStr(args[0])
 	port := ToInt(args[1])
-	ipaddr += ":" + strconv.Itoa(port)
+	ipaddr := host + ":" + strconv.Itoa(port)
 	var c net.Conn
 	var e error
 	toc := time.Duration(ToInt(OpMul(args[3], SuInt(1000)))) * time.Millisecond
@@ -42,9 +47,12 @@ func SocketClient(th *Thread, args []Value) Value {
 	if e != nil {
 		panic("SocketClient: " + e.Error())
 	}
-	sc := &suSocketClient{conn: c.(*net.TCPConn), rdr: bufio.NewReader(c),
+	sc := &suSocketClient{conn: c, rdr: bufio.NewReader(c), host: host,
 		timeout: time.Duration(ToInt(args[2])) * time.Second}
 	nSocketClient.Add(1)
+	if args[5] == True {
+		sc.startTLS(args[6], args[7])
+	}
 	if args[4] == False {
 		return sc
 	}
@@ -104,6 +112,32 @@ func sock_Readline(this Value) Value {
 	return Readline(sc.rdr, "socket.Readline: ")
 }
 
+var _ = method(sock_StartTLS, "(serverName=false, caFile=false)")
+
+// sock_StartTLS switches the connection to TLS e.g. after STARTTLS
+func sock_StartTLS(this, serverName, caFile Value) Value {
+	scOpen(this).startTLS(serverName, caFile)
+	return nil
+}
+
+func (sc *suSocketClient) startTLS(serverName, caFile Value) {
+	if sc.rdr.Buffered() > 0 {
+		panic("socketClient.StartTLS: unread data")
+	}
+	conn := tls.Client(sc.conn,
+		tlsClientConfig("socketClient.StartTLS", sc.host, serverName, caFile))
+	if sc.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(sc.timeout))
+		defer conn.SetDeadline(noDeadline)
+	}
+	if err := conn.Handshake(); err != nil {
+		sc.Close()
+		panic("socketClient.StartTLS: " + err.Error())
+	}
+	sc.conn = conn
+	sc.rdr.Reset(conn)
+}
+
 var _ = method(sock_SetTimeout, "(seconds)")
 
 func sock_SetTimeout(this, arg Value) Value {
@@ -158,6 +192,10 @@ func (sc *suSocketClient) writer() io.Writer {
 	return scOpen(sc).conn
 }
 
+func (sc *suSocketClient) reader() *bufio.Reader {
+	return scOpen(sc).rdr
+}
+
 func (sc *suSocketClient) Close() {
 	if sc.conn == nil {
 		return
@@ -6,12 +6,14 @@ package builtin
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"io"
 	"log"
 	"net"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	. "github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/util/str"
@@ -33,10 +35,28 @@ func ssCallClass(th *Thread, as *ArgSpec, this Value, args []Value) Value {
 	name, port, as2 := ssArgs(th, as, this, args)
 	class := this.(*SuClass)
 	sm := suServerMaster{SuInstance: class.New(th, as2)}
+	sm.tlsConfig(th)
 	sm.listen(th, ToStr(name), ToInt(port))
 	return nil
 }
 
+// tlsConfig sets up TLS if the class defines CertFile and KeyFile
+// (and optionally ClientCAFile to require client certificates)
+func (sm *suServerMaster) tlsConfig(th *Thread) {
+	get := func(mem string) string {
+		if x := sm.Get(th, SuStr(mem)); x != nil {
+			return ToStr(x)
+		}
+		return ""
+	}
+	certFile, keyFile := get("CertFile"), get("KeyFile")
+	if certFile == "" && keyFile == "" {
+		return
+	}
+	sm.tls = tlsServerConfig("SocketServer", certFile, keyFile,
+		get("ClientCAFile"))
+}
+
 func ssArgs(th *Thread, as *ArgSpec, this Value, args []Value) (
 	name, port Value, as2 *ArgSpec) {
 	name = this.Get(th, SuStr("Name"))
@@ -93,6 +113,7 @@ func ssArgs(th *Thread, as *ArgSpec, this Value, args []Value) (
 
 type suServerMaster struct {
 	*SuInstance
+	tls *tls.Config // nil if not using TLS
 }
 
 func (sm *suServerMaster) String() string {
@@ -108,6 +129,8 @@ var _ = AddInfo("server.nSocketServerConn", &nSocketServerConn)
 var socketServerLimiter = rate.NewLimiter(rate.Limit(64), 8) // ???
 var socketServerContext = context.Background()
 
+const handshakeTimeout = 10 * time.Second
+
 // ssmax is the maximum number of connections allowed for all SocketServer's
 const ssmax = 500 // ???
 
@@ -118,6 +141,9 @@ func (sm *suServerMaster) listen(th *Thread, name string, port int) {
 	if err != nil {
 		panic(err)
 	}
+	if sm.tls != nil {
+		ln = tls.NewListener(ln, sm.tls)
+	}
 	if fn := sm.Lookup(th, "Killer"); fn != nil {
 		th.CallThis(fn, sm, &killer{kill: func() { ln.Close() }})
 	}
@@ -143,7 +169,7 @@ func (sm *suServerMaster) listen(th *Thread, name string, port int) {
 func (sm *suServerMaster) connect(name string, conn net.Conn) {
 	nSocketServerConn.Add(1)
 	client := suSocketClient{
-		conn: conn.(*net.TCPConn), rdr: bufio.NewReader(conn),
+		conn: conn, rdr: bufio.NewReader(conn),
 		// no timeout to match jSuneido
 	}
 	sc := &suServerConnect{
@@ -151,6 +177,15 @@ func (sm *suServerMaster) connect(name string, conn net.Conn) {
 		client:     client,
 	}
 	defer sc.close()
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tc.Handshake()
+		tc.SetDeadline(noDeadline)
+		if err != nil {
+			log.Println("SocketServer:", name, err)
+			return
+		}
+	}
 	t := NewThread(nil)
 	t.Name = str.BeforeFirst(t.Name, " ") + " " + name
 	if f := sc.Lookup(t, "Run"); f != nil {
@@ -186,6 +221,10 @@ func (sc *suServerConnect) writer() io.Writer {
 	return sc.client.writer()
 }
 
+func (sc *suServerConnect) reader() *bufio.Reader {
+	return sc.client.reader()
+}
+
 func (sc *suServerConnect) close() {
 	if !sc.manualClose {
 		sc.Close()
@@ -204,8 +243,15 @@ var socketServerMethods = methods("sockserv")
 
 var _ = method(sockserv_RemoteUser, "()")
 
+// sockserv_RemoteUser returns the common name from the client certificate
+// if there is one, otherwise the remote address
 func sockserv_RemoteUser(this Value) Value {
 	sc := this.(*suServerConnect)
+	if tc, ok := sc.client.conn.(*tls.Conn); ok {
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			return SuStr(certs[0].Subject.CommonName)
+		}
+	}
 	addr := sc.client.conn.RemoteAddr().String()
 	return SuStr(str.BeforeLast(addr, ":"))
 }
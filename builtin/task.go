@@ -0,0 +1,168 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"reflect"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+// suTask is the future returned by Async.
+// The block runs in its own Thread (like Thread(block))
+// and the result or exception is returned by Wait.
+// Exceptions are also logged (unless the task was cancelled)
+// since otherwise they would be lost if nothing waits for the task.
+// Cancel is delivered at loop iterations and calls,
+// and interrupts Sleep, Wait, Select, and Channel Send and Recv.
+type suTask struct {
+	ValueBase[suTask]
+	th     *Thread
+	done   chan struct{} // closed when the block finishes
+	result Value         // set before done is closed
+	err    any           // the exception (if any), set before done is closed
+}
+
+var _ = builtin(Async, "(block, name = false)")
+
+func Async(th *Thread, args []Value) Value {
+	fn := args[0]
+	fn.SetConcurrent()
+	t2 := NewThread(th)
+	thread_Name(t2, args[1:])
+	threads.add(t2)
+	task := &suTask{th: t2, done: make(chan struct{})}
+	go func() {
+		defer func() {
+			t2.Close()
+			threads.remove(t2.Num)
+			task.err = recover()
+			if task.err != nil && !t2.IsCancelled() {
+				LogUncaught(t2, "Async", task.err)
+			}
+			close(task.done)
+		}()
+		task.result = t2.Call(fn)
+		if task.result != nil {
+			task.result.SetConcurrent()
+		}
+	}()
+	return task
+}
+
+// get returns the result or rethrows the exception.
+// It must only be called after done is closed.
+func (task *suTask) get() Value {
+	if task.err != nil {
+		panic(task.err)
+	}
+	return task.result
+}
+
+var suTaskMethods = methods("task")
+
+var _ = method(task_Wait, "(secs = 10)")
+
+func task_Wait(th *Thread, this Value, args []Value) Value {
+	task := this.(*suTask)
+	select {
+	case <-task.done:
+		return task.get()
+	case <-th.Cancelled():
+		panic("cancelled")
+	case <-time.After(time.Duration(ToInt(args[0])) * time.Second):
+		panic("Task: Wait timeout")
+	}
+}
+
+var _ = method(task_Cancel, "()")
+
+func task_Cancel(this Value) Value {
+	this.(*suTask).th.Cancel()
+	return nil
+}
+
+var _ = method(task_DoneQ, "()")
+
+func task_DoneQ(this Value) Value {
+	select {
+	case <-this.(*suTask).done:
+		return True
+	default:
+		return False
+	}
+}
+
+// Value implementation
+
+var _ Value = (*suTask)(nil)
+
+func (task *suTask) Equal(other any) bool {
+	return task == other
+}
+
+func (*suTask) Lookup(_ *Thread, method string) Value {
+	return suTaskMethods[method]
+}
+
+func (*suTask) SetConcurrent() {
+	// ok for concurrent use
+}
+
+//-------------------------------------------------------------------
+
+var _ = builtin(Select, "(list, secs = 10)")
+
+// Select waits for the first of a list of channels and tasks.
+// It returns [i, value] where i is the index in the list,
+// or just [i] for a closed channel.
+// Exceptions from tasks are rethrown.
+func Select(th *Thread, args []Value) Value {
+	list := ToContainer(args[0])
+	n := list.ListSize()
+	cases := make([]reflect.SelectCase, 0, n+2)
+	for i := range n {
+		var ch reflect.Value
+		switch x := list.ListGet(i).(type) {
+		case *suChannel:
+			ch = reflect.ValueOf(x.ch)
+		case *suTask:
+			ch = reflect.ValueOf(x.done)
+		default:
+			panic("Select: list must contain channels or tasks")
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: ch})
+	}
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv,
+			Chan: reflect.ValueOf(th.Cancelled())},
+		reflect.SelectCase{Dir: reflect.SelectRecv,
+			Chan: reflect.ValueOf(time.After(
+				time.Duration(ToInt(args[1])) * time.Second))})
+	i, val, ok := reflect.Select(cases)
+	switch i {
+	case n:
+		panic("cancelled")
+	case n + 1:
+		panic("Select: timeout")
+	}
+	ob := &SuObject{}
+	ob.Add(IntVal(i))
+	switch x := list.ListGet(i).(type) {
+	case *suChannel:
+		if ok {
+			v := val.Interface().(Value)
+			if x.concurrent {
+				v.SetConcurrent()
+			}
+			ob.Add(v)
+		}
+	case *suTask:
+		if v := x.get(); v != nil {
+			ob.Add(v)
+		}
+	}
+	return ob
+}
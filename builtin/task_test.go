@@ -0,0 +1,27 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apmckinlay/gsuneido/compile"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestTaskCancel(t *testing.T) {
+	var th Thread
+	for _, src := range []string{
+		"function () { Thread.Sleep(60000) }",
+		"function () { Channel().Recv() }",
+	} {
+		task := Async(&th, []Value{compile.Constant(src), False})
+		time.Sleep(20 * time.Millisecond) // let it start
+		task_Cancel(task)
+		assert.T(t).This(func() { task_Wait(&th, task, []Value{IntVal(5)}) }).
+			Panics("cancelled")
+	}
+}
@@ -109,8 +109,12 @@ func thread_List() Value {
 
 var _ = staticMethod(thread_Sleep, "(ms)")
 
-func thread_Sleep(ms Value) Value {
-	time.Sleep(time.Duration(ToInt(ms)) * time.Millisecond)
+func thread_Sleep(th *Thread, args []Value) Value {
+	select {
+	case <-time.After(time.Duration(ToInt(args[0])) * time.Millisecond):
+	case <-th.Cancelled():
+		panic("cancelled")
+	}
 	return nil
 }
 
@@ -0,0 +1,57 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+// TLS configuration used by SocketClient and SocketServer
+
+// tlsClientConfig returns the configuration for a client connection.
+// The server certificate is verified against serverName
+// (default is the host being connected to)
+// using the system roots or the CA bundle in caFile.
+func tlsClientConfig(which, host string, serverName, caFile Value) *tls.Config {
+	cfg := &tls.Config{ServerName: host}
+	if serverName != False {
+		cfg.ServerName = ToStr(serverName)
+	}
+	if caFile != False {
+		cfg.RootCAs = certPool(which, ToStr(caFile))
+	}
+	return cfg
+}
+
+// tlsServerConfig returns the configuration for a server.
+// If clientCAFile is given, clients must provide a certificate
+// signed by one of those CA's.
+func tlsServerConfig(which, certFile, keyFile, clientCAFile string) *tls.Config {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAFile != "" {
+		cfg.ClientCAs = certPool(which, clientCAFile)
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+func certPool(which, file string) *x509.CertPool {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		panic(which + ": " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic(which + ": no certificates found in " + file)
+	}
+	return pool
+}
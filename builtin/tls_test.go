@@ -0,0 +1,44 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestSocketClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		}))
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: srv.Certificate().Raw}), 0644)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	var th Thread
+	connect := func(caFile Value) Value {
+		return SocketClient(&th, []Value{SuStr(host), IntVal(p), IntVal(10),
+			Zero, False, True, False, caFile})
+	}
+	sc := connect(SuStr(caFile))
+	sock_Write(sc, SuStr("GET / HTTP/1.0\r\n\r\n"))
+	assert.T(t).This(sock_Readline(sc)).Is(SuStr("HTTP/1.0 200 OK"))
+	sock_Close(sc)
+
+	// not trusted without the CA
+	assert.T(t).This(func() { connect(False) }).Panics("certificate")
+}
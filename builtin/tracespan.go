@@ -0,0 +1,38 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"fmt"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/otel"
+)
+
+var _ = AddInfo("otel.dropped", &otel.Dropped)
+
+var _ = builtin(TraceSpan, "(name, block, attributes = #())")
+
+// TraceSpan calls block within a trace span (when tracing is enabled).
+// Database requests from within the block, including on the server,
+// are recorded as child spans.
+func TraceSpan(th *Thread, args []Value) Value {
+	span := th.StartSpan(ToStr(args[0]), otel.Internal)
+	defer th.EndSpan(span)
+	if span != nil {
+		iter := ToContainer(args[2]).Iter2(false, true)
+		for k, v := iter(); k != nil; k, v = iter() {
+			span.SetAttr(ToStr(k), logValue(th, v))
+		}
+		defer func() {
+			if e := recover(); e != nil {
+				if e != BlockReturn {
+					span.SetError(fmt.Sprint(e))
+				}
+				panic(e)
+			}
+		}()
+	}
+	return th.Call(args[1])
+}
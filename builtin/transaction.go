@@ -4,7 +4,10 @@
 package builtin
 
 import (
+	"fmt"
+
 	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/regex"
 )
 
@@ -32,7 +35,7 @@ func Transaction(th *Thread, args []Value) Value {
 			if e != nil && e != BlockReturn {
 				st.Rollback()
 			} else {
-				st.Complete()
+				completeTran(th, st)
 			}
 			if e != nil {
 				panic(e)
@@ -48,11 +51,23 @@ var _ = exportMethods(&TranMethods, "tran")
 
 var _ = method(tran_Complete, "()")
 
-func tran_Complete(this Value) Value {
-	this.(*SuTran).Complete()
+func tran_Complete(th *Thread, this Value, _ []Value) Value {
+	completeTran(th, this.(*SuTran))
 	return nil
 }
 
+func completeTran(th *Thread, st *SuTran) {
+	span := th.StartSpan("commit", otel.Internal)
+	defer th.EndSpan(span)
+	defer func() {
+		if e := recover(); e != nil {
+			span.SetError(fmt.Sprint(e))
+			panic(e)
+		}
+	}()
+	st.Complete()
+}
+
 var _ = method(tran_Data, "()")
 
 func tran_Data(this Value) Value {
@@ -68,10 +83,18 @@ func tran_EndedQ(this Value) Value {
 var _ = method(tran_Query, "(@args)")
 
 func tran_Query(th *Thread, as *ArgSpec, this Value, args []Value) Value {
-	query, args := extractQuery(th, &queryBlockParams, as, args)
-	mustNotBeAction(query)
-	q := this.(*SuTran).Query(th, query)
-	if args[1] == False {
+	var q *SuQuery
+	var block Value
+	if p, params, blk, ok := preparedArgs(as, args); ok {
+		q = this.(*SuTran).QueryPrepared(th, p.handle, p.query, params)
+		block = blk
+	} else {
+		query, args := extractQuery(th, &queryBlockParams, as, args)
+		mustNotBeAction(query)
+		q = this.(*SuTran).Query(th, query)
+		block = args[1]
+	}
+	if block == False {
 		return q
 	}
 	// block form
@@ -80,7 +103,7 @@ func tran_Query(th *Thread, as *ArgSpec, this Value, args []Value) Value {
 			q.Close()
 		}
 	}()
-	return th.Call(args[1], q)
+	return th.Call(block, q)
 }
 
 var _ = method(tran_QueryDo, "(@args)")
@@ -92,6 +115,30 @@ func tran_QueryDo(th *Thread, as *ArgSpec, this Value, args []Value) Value {
 	return IntVal(this.(*SuTran).Action(th, query))
 }
 
+var _ = method(tran_OutputMany, "(table, records)")
+
+// tran_OutputMany returns an object with the errors for the records
+// that were not output, indexed by their position in records.
+// Other errors, including exceptions from triggers, are thrown
+// after the preceding records have been output,
+// so the transaction should be rolled back.
+func tran_OutputMany(th *Thread, this Value, args []Value) Value {
+	table := ToStr(args[0])
+	list := ToContainer(args[1])
+	obs := make([]Container, list.ListSize())
+	for i := range obs {
+		obs[i] = ToContainer(list.ListGet(i))
+	}
+	errs := this.(*SuTran).OutputMany(th, table, obs)
+	ob := &SuObject{}
+	for i, e := range errs {
+		if e != "" {
+			ob.Set(IntVal(i), SuStr(e))
+		}
+	}
+	return ob
+}
+
 var _ = method(tran_Query1, "(@args)")
 
 func tran_Query1(th *Thread, this Value, args []Value) Value {
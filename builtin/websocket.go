@@ -0,0 +1,283 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/hacks"
+	"github.com/apmckinlay/gsuneido/util/websocket"
+)
+
+// suWebSocket is returned by WebSocket (client)
+// and by the WebSocket method of an HttpServer response (server).
+// Received messages are returned by Recv as objects
+// with data (a string) and binary (true for binary messages, false for text).
+// They are also available from Channel (e.g. for Select)
+// but Channel Recv times out so it is not suitable for idle connections.
+// The Channel is closed when the connection closes.
+type suWebSocket struct {
+	ValueBase[*suWebSocket]
+	conn      *websocket.Conn
+	ch        *suChannel
+	closing   chan struct{} // closed by Close to unblock the reader
+	closeOnce sync.Once
+}
+
+var nWebSocket atomic.Int32
+var _ = AddInfo("builtin.nWebSocket", &nWebSocket)
+
+// wsChannelSize allows some buffering without unbounded memory.
+// When the channel is full, reading from the connection stops
+// and the peer is throttled by TCP flow control.
+const wsChannelSize = 100
+
+// wsCloseWait is how long Close waits for the peer's close frame
+var wsCloseWait = 5 * time.Second
+
+var _ = builtin(WebSocket,
+	"(url, headers = false, timeout = 60, serverName = false, caFile = false)")
+
+// WebSocket connects to a ws:// or wss:// url
+func WebSocket(th *Thread, args []Value) Value {
+	u, err := url.Parse(ToStr(args[0]))
+	if err != nil {
+		panic("WebSocket: " + err.Error())
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+		secure = true
+	default:
+		panic("WebSocket: url must start with ws:// or wss://")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+	timeout := time.Duration(ToInt(args[2])) * time.Second
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		panic("WebSocket: " + err.Error())
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	if secure {
+		tc := tls.Client(c,
+			tlsClientConfig("WebSocket", u.Hostname(), args[3], args[4]))
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			panic("WebSocket: " + err.Error())
+		}
+		c = tc
+	}
+	rdr, err := wsHandshake(c, u, args[1])
+	if err != nil {
+		c.Close()
+		panic("WebSocket: " + err.Error())
+	}
+	c.SetDeadline(noDeadline)
+	return newWebSocket(websocket.NewConn(c, rdr, true))
+}
+
+func wsHandshake(c net.Conn, u *url.URL, headers Value) (*bufio.Reader, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if headers != False {
+		iter := ToContainer(headers).Iter2(false, true)
+		for k, v := iter(); k != nil; k, v = iter() {
+			req.Header.Add(ToStr(k), ToStr(v))
+		}
+	}
+	key := websocket.NewKey()
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+	rdr := bufio.NewReader(c)
+	resp, err := http.ReadResponse(rdr, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.New("handshake failed: " + resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocket.AcceptKey(key) {
+		return nil, errors.New("handshake failed: invalid Sec-WebSocket-Accept")
+	}
+	return rdr, nil
+}
+
+// wsUpgrade is used by the HttpServer response WebSocket method
+func wsUpgrade(w http.ResponseWriter, r *http.Request) *suWebSocket {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		panic("httpResponse.WebSocket: not a WebSocket request")
+	}
+	c, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic("httpResponse.WebSocket: " + err.Error())
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " +
+		websocket.AcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		c.Close()
+		panic("httpResponse.WebSocket: " + err.Error())
+	}
+	c.SetDeadline(noDeadline)
+	return newWebSocket(websocket.NewConn(c, rw.Reader, false))
+}
+
+func newWebSocket(conn *websocket.Conn) *suWebSocket {
+	ws := &suWebSocket{conn: conn,
+		ch:      &suChannel{ch: make(chan Value, wsChannelSize)},
+		closing: make(chan struct{})}
+	nWebSocket.Add(1)
+	go ws.reader()
+	return ws
+}
+
+func (ws *suWebSocket) reader() {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Println("ERROR: WebSocket:", e)
+		}
+		ws.conn.Shutdown()
+		close(ws.ch.ch)
+		nWebSocket.Add(-1)
+	}()
+	for {
+		op, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			if err != io.EOF && err != websocket.ErrClosed {
+				select {
+				case <-ws.closing:
+				default:
+					ws.conn.CloseWith(1002, "")
+				}
+			}
+			return
+		}
+		msg := &SuObject{}
+		msg.Set(SuStr("data"), SuStr(hacks.BStoS(data)))
+		msg.Set(SuStr("binary"), SuBool(op == websocket.Binary))
+		select {
+		case ws.ch.ch <- msg:
+		case <-ws.closing:
+			// discard until the peer's close frame
+		}
+	}
+}
+
+var _ Value = (*suWebSocket)(nil)
+
+func (ws *suWebSocket) Equal(other any) bool {
+	return ws == other
+}
+
+func (*suWebSocket) SetConcurrent() {
+	// ok for concurrent use
+}
+
+func (*suWebSocket) Lookup(_ *Thread, method string) Value {
+	return suWebSocketMethods[method]
+}
+
+var suWebSocketMethods = methods("websocket")
+
+var _ = method(websocket_Channel, "()")
+
+// websocket_Channel returns the Channel that received messages are sent to
+func websocket_Channel(this Value) Value {
+	return this.(*suWebSocket).ch
+}
+
+var _ = method(websocket_Recv, "(timeout = false)")
+
+// websocket_Recv waits for the next message, by default with no timeout.
+// It returns the WebSocket itself when the connection has closed.
+func websocket_Recv(th *Thread, this Value, args []Value) Value {
+	ws := this.(*suWebSocket)
+	var timeout <-chan time.Time
+	if args[0] != False {
+		timeout = time.After(time.Duration(ToInt(args[0])) * time.Second)
+	}
+	select {
+	case val, ok := <-ws.ch.ch:
+		if !ok {
+			return this // closed
+		}
+		return val
+	case <-th.Cancelled():
+		panic("cancelled")
+	case <-timeout:
+		panic("WebSocket: Recv timeout")
+	}
+}
+
+var _ = method(websocket_Send, "(string, binary = false)")
+
+func websocket_Send(this, arg, binary Value) Value {
+	op := websocket.Text
+	if binary == True {
+		op = websocket.Binary
+	}
+	err := this.(*suWebSocket).conn.WriteMessage(op, toBytes(arg))
+	if err != nil {
+		panic("WebSocket: Send: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(websocket_Ping, "(data = '')")
+
+// websocket_Ping sends a ping, pongs are handled automatically
+func websocket_Ping(this, data Value) Value {
+	err := this.(*suWebSocket).conn.WriteMessage(websocket.Ping, toBytes(data))
+	if err != nil {
+		panic("WebSocket: Ping: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(websocket_Close, "(code = 1000, reason = '')")
+
+// websocket_Close starts the closing handshake.
+// Messages received after this are discarded.
+// The connection is closed when the peer replies,
+// or after a few seconds if it does not.
+func websocket_Close(this, code, reason Value) Value {
+	ws := this.(*suWebSocket)
+	ws.closeOnce.Do(func() {
+		close(ws.closing)
+		ws.conn.CloseWith(ToInt(code), ToStr(reason))
+		time.AfterFunc(wsCloseWait, func() { ws.conn.Shutdown() })
+	})
+	return nil
+}
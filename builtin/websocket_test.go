@@ -0,0 +1,52 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/compile"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestWebSocket(t *testing.T) {
+	assert := assert.T(t)
+	handler := compile.Constant(`function (req, resp) {
+		if req.Path() isnt "/ws"
+			return "ok"
+		ws = resp.WebSocket()
+		while ((msg = ws.Recv()) isnt ws)
+			ws.Send("echo " $ msg.data, msg.binary)
+	}`)
+	var th Thread
+	// one worker, so the WebSocket must not keep it
+	hs := HttpServer(&th, []Value{Zero, handler, One, False, False, False})
+	defer httpserver_Shutdown(hs, One)
+	url := "localhost:" + strconv.Itoa(ToInt(httpserver_Port(hs)))
+
+	ws := WebSocket(&th, []Value{SuStr("ws://" + url + "/ws"), False,
+		IntVal(5), False, False})
+	recv := func() Value { return websocket_Recv(&th, ws, []Value{IntVal(5)}) }
+	websocket_Ping(ws, SuStr("hi"))
+	websocket_Send(ws, SuStr("hello"), False)
+	msg := func(data string, binary bool) Value {
+		ob := &SuObject{}
+		ob.Set(SuStr("data"), SuStr(data))
+		ob.Set(SuStr("binary"), SuBool(binary))
+		return ob
+	}
+	assert.This(recv()).Is(msg("echo hello", false))
+	websocket_Send(ws, SuStr("\x00\xff"), True)
+	assert.This(recv()).Is(msg("echo \x00\xff", true))
+	hr := HttpRequest(&th, []Value{SuStr("GET"), SuStr("http://" + url + "/"),
+		False, EmptyStr, IntVal(5), False})
+	assert.This(httpresp_Read(hr, False)).Is(SuStr("ok"))
+	httpresp_Close(hr)
+	websocket_Send(ws, SuStr("world"), False)
+	assert.This(recv()).Is(msg("echo world", false))
+	websocket_Close(ws, IntVal(1000), EmptyStr)
+	assert.This(recv()).Is(ws)
+}
@@ -0,0 +1,190 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// If namespaces is true, names in a namespace are given as "{uri}local"
+// and xmlns attributes are omitted.
+// Otherwise names are as written e.g. "soap:Envelope"
+
+var _ = builtin(XmlParse, "(source, namespaces = false)")
+
+// XmlParse returns the root element as an object with name and attributes
+// members and the child elements and text as list members.
+// Text that is only whitespace is omitted.
+func XmlParse(th *Thread, args []Value) Value {
+	var stack []*SuObject
+	var root *SuObject
+	xmlScan("XmlParse", args[0], args[1] == True, xmlHandler{
+		start: func(name string, atts *SuObject) {
+			ob := &SuObject{}
+			ob.Set(SuStr("name"), SuStr(name))
+			ob.Set(SuStr("attributes"), atts)
+			if len(stack) > 0 {
+				stack[len(stack)-1].Add(ob)
+			} else {
+				root = ob
+			}
+			stack = append(stack, ob)
+		},
+		end: func(string) {
+			stack = stack[:len(stack)-1]
+		},
+		text: func(s string) {
+			stack[len(stack)-1].Add(SuStr(s))
+		},
+	})
+	if root == nil {
+		panic("XmlParse: no root element")
+	}
+	return root
+}
+
+var _ = builtin(XmlSax, "(source, handler, namespaces = false)")
+
+// XmlSax calls the handler's StartElement(qname, atts), EndElement(qname),
+// Characters(string), and IgnorableWhitespace(string) methods
+// (see XmlContentHandler). Missing methods are skipped.
+func XmlSax(th *Thread, args []Value) Value {
+	handler := args[1]
+	call := func(method string) func(args ...Value) {
+		fn := handler.Lookup(th, method)
+		if fn == nil {
+			return nil
+		}
+		return func(args ...Value) {
+			th.CallThis(fn, handler, args...)
+		}
+	}
+	startElement := call("StartElement")
+	endElement := call("EndElement")
+	characters := call("Characters")
+	whitespace := call("IgnorableWhitespace")
+	h := xmlHandler{}
+	if startElement != nil {
+		h.start = func(name string, atts *SuObject) {
+			startElement(SuStr(name), atts)
+		}
+	}
+	if endElement != nil {
+		h.end = func(name string) { endElement(SuStr(name)) }
+	}
+	if characters != nil {
+		h.text = func(s string) { characters(SuStr(s)) }
+	}
+	if whitespace != nil {
+		h.space = func(s string) { whitespace(SuStr(s)) }
+	}
+	xmlScan("XmlSax", args[0], args[2] == True, h)
+	return nil
+}
+
+type xmlHandler struct {
+	start func(name string, atts *SuObject)
+	end   func(name string)
+	text  func(s string)
+	space func(s string)
+}
+
+// xmlScan reads the source (a string or a stream e.g. File)
+// and calls the handler functions (that are not nil).
+// Adjacent character data is combined.
+func xmlScan(which string, source Value, namespaces bool, h xmlHandler) {
+	var r io.Reader
+	if s, ok := source.ToStr(); ok {
+		r = strings.NewReader(s)
+	} else {
+		r = sourceReader(which, source)
+	}
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := ianaindex.IANA.Encoding(charset)
+		if err != nil || enc == nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	token := d.RawToken
+	if namespaces {
+		token = d.Token
+	}
+	var names []string // RawToken does not check nesting
+	var text strings.Builder
+	flush := func() {
+		if text.Len() == 0 {
+			return
+		}
+		s := text.String()
+		text.Reset()
+		if strings.TrimSpace(s) == "" {
+			if h.space != nil {
+				h.space(s)
+			}
+		} else if h.text != nil {
+			h.text(s)
+		}
+	}
+	for {
+		tok, err := token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(which + ": " + err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			if len(names) > 0 {
+				text.Write(t)
+			}
+		case xml.StartElement:
+			flush()
+			name := xmlName(t.Name, namespaces)
+			names = append(names, name)
+			if h.start != nil {
+				atts := &SuObject{}
+				for _, a := range t.Attr {
+					if namespaces &&
+						(a.Name.Space == "xmlns" || a.Name.Local == "xmlns") {
+						continue
+					}
+					atts.Set(SuStr(xmlName(a.Name, namespaces)), SuStr(a.Value))
+				}
+				h.start(name, atts)
+			}
+		case xml.EndElement:
+			flush()
+			name := xmlName(t.Name, namespaces)
+			if len(names) == 0 || names[len(names)-1] != name {
+				panic(which + ": unmatched end tag: " + name)
+			}
+			names = names[:len(names)-1]
+			if h.end != nil {
+				h.end(name)
+			}
+		}
+	}
+	if len(names) > 0 {
+		panic(which + ": missing end tag: " + names[len(names)-1])
+	}
+}
+
+func xmlName(name xml.Name, namespaces bool) string {
+	switch {
+	case name.Space == "":
+		return name.Local
+	case namespaces:
+		return "{" + name.Space + "}" + name.Local
+	default:
+		return name.Space + ":" + name.Local
+	}
+}
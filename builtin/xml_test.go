@@ -0,0 +1,50 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/compile"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+const testXml = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+	<soap:Body id="1">caf` + "\xe9" + ` &amp; <b>bar</b></soap:Body>
+</soap:Envelope>`
+
+func TestXmlParse(t *testing.T) {
+	assert := assert.T(t)
+	var th Thread
+	x := XmlParse(&th, []Value{SuStr(testXml), False})
+	assert.This(Display(&th, x)).Is(`#(#("caf\xc3\xa9 & ", ` +
+		`#("bar", name: 'b', attributes: #()), ` +
+		`name: "soap:Body", attributes: #(id: '1')), ` +
+		`name: "soap:Envelope", ` +
+		`attributes: #("xmlns:soap": "http://schemas.xmlsoap.org/soap/envelope/"))`)
+	x = XmlParse(&th, []Value{SuStr(testXml), True})
+	assert.This(x.Get(&th, SuStr("name"))).
+		Is(SuStr("{http://schemas.xmlsoap.org/soap/envelope/}Envelope"))
+	assert.This(x.Get(&th, SuStr("attributes")).(*SuObject).Size()).Is(0)
+	assert.This(func() { XmlParse(&th, []Value{SuStr("<a></b>"), False}) }).
+		Panics("XmlParse: unmatched end tag: b")
+}
+
+func TestXmlSax(t *testing.T) {
+	handler := compile.Constant(`class
+		{
+		New() { .log = Object() }
+		StartElement(qname, atts) { .log.Add("<" $ qname $ Display(atts)) }
+		EndElement(qname) { .log.Add(">" $ qname) }
+		Characters(s) { .log.Add(s) }
+		Log() { return .log }
+		}`)
+	var th Thread
+	h := th.CallLookup(handler, "*new*")
+	XmlSax(&th, []Value{SuStr(`<a x="1">hello<b/></a>`), h, False})
+	assert.T(t).This(Display(&th, th.CallLookup(h, "Log"))).
+		Is(`#("<a#(x: '1')", "hello", "<b#()", ">b", ">a")`)
+}
@@ -0,0 +1,274 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package builtin
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/hacks"
+)
+
+type suZipWriter struct {
+	ValueBase[*suZipWriter]
+	zw *zip.Writer
+	f  *os.File // if we created the file
+}
+
+var _ = builtin(ZipWriter, "(dest, block = false)")
+
+// ZipWriter creates a zip archive.
+// dest is either a file name or a stream e.g. File or SocketClient.
+// Close must be called to finish the archive.
+func ZipWriter(th *Thread, args []Value) Value {
+	zw := &suZipWriter{}
+	if s, ok := args[0].ToStr(); ok {
+		f, err := os.Create(s)
+		if err != nil {
+			panic("ZipWriter: " + err.Error())
+		}
+		zw.f = f
+		zw.zw = zip.NewWriter(f)
+	} else if tow, ok := args[0].(writer); ok {
+		zw.zw = zip.NewWriter(tow.writer())
+	} else {
+		panic("ZipWriter: dest must be a file name, file, pipe, or socket")
+	}
+	if args[1] == False {
+		return zw
+	}
+	// block form
+	defer zw.close()
+	return th.Call(args[1], zw)
+}
+
+func (zw *suZipWriter) close() {
+	if zw.zw == nil {
+		return
+	}
+	err := zw.zw.Close()
+	zw.zw = nil
+	if zw.f != nil {
+		if err2 := zw.f.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		panic("ZipWriter: " + err.Error())
+	}
+}
+
+func zwOpen(this Value) *suZipWriter {
+	zw := this.(*suZipWriter)
+	if zw.zw == nil {
+		panic("can't use a closed ZipWriter")
+	}
+	return zw
+}
+
+var _ Value = (*suZipWriter)(nil)
+
+func (zw *suZipWriter) Equal(other any) bool {
+	return zw == other
+}
+
+func (*suZipWriter) Lookup(_ *Thread, method string) Value {
+	return suZipWriterMethods[method]
+}
+
+var suZipWriterMethods = methods("zipw")
+
+var _ = method(zipw_AddString, "(name, string)")
+
+func zipw_AddString(this, name, s Value) Value {
+	w, err := zwOpen(this).zw.Create(ToStr(name))
+	if err == nil {
+		_, err = io.WriteString(w, ToStr(s))
+	}
+	if err != nil {
+		panic("ZipWriter: AddString: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(zipw_AddFile, "(filename, name = false)")
+
+// zipw_AddFile adds a file from disk.
+// The name in the archive defaults to the base file name.
+func zipw_AddFile(this, filename, name Value) Value {
+	zw := zwOpen(this)
+	path := ToStr(filename)
+	f, err := os.Open(path)
+	if err != nil {
+		panic("ZipWriter: AddFile: " + err.Error())
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		panic("ZipWriter: AddFile: " + err.Error())
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		panic("ZipWriter: AddFile: " + err.Error())
+	}
+	hdr.Name = filepath.Base(path)
+	if name != False {
+		hdr.Name = ToStr(name)
+	}
+	hdr.Method = zip.Deflate
+	w, err := zw.zw.CreateHeader(hdr)
+	if err == nil {
+		_, err = io.Copy(w, f)
+	}
+	if err != nil {
+		panic("ZipWriter: AddFile: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(zipw_Close, "()")
+
+func zipw_Close(this Value) Value {
+	this.(*suZipWriter).close()
+	return nil
+}
+
+//-------------------------------------------------------------------
+
+type suZipReader struct {
+	ValueBase[*suZipReader]
+	zr *zip.Reader
+	f  io.Closer // if we opened the file
+}
+
+var _ = builtin(ZipReader, "(source, block = false)")
+
+// ZipReader opens a zip archive for reading.
+// source is either a file name or a stream e.g. File or SocketClient.
+// Since a zip archive requires random access,
+// a stream is read into memory, up to the string size limit.
+func ZipReader(th *Thread, args []Value) Value {
+	zr := &suZipReader{}
+	if s, ok := args[0].ToStr(); ok {
+		r, err := zip.OpenReader(s)
+		if err != nil {
+			panic("ZipReader: " + err.Error())
+		}
+		zr.zr, zr.f = &r.Reader, r
+	} else {
+		src := readAllLimited("ZipReader",
+			sourceReader("ZipReader", args[0]))
+		r, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+		if err != nil {
+			panic("ZipReader: " + err.Error())
+		}
+		zr.zr = r
+	}
+	if args[1] == False {
+		return zr
+	}
+	// block form
+	defer zr.close()
+	return th.Call(args[1], zr)
+}
+
+func (zr *suZipReader) close() {
+	if zr.f != nil {
+		zr.f.Close()
+		zr.f = nil
+	}
+	zr.zr = nil
+}
+
+func zrOpen(this Value) *suZipReader {
+	zr := this.(*suZipReader)
+	if zr.zr == nil {
+		panic("can't use a closed ZipReader")
+	}
+	return zr
+}
+
+func (zr *suZipReader) open(which string, name Value) io.ReadCloser {
+	r, err := zrOpen(zr).zr.Open(ToStr(name))
+	if err != nil {
+		panic("ZipReader: " + which + ": " + err.Error())
+	}
+	return r
+}
+
+var _ Value = (*suZipReader)(nil)
+
+func (zr *suZipReader) Equal(other any) bool {
+	return zr == other
+}
+
+func (*suZipReader) Lookup(_ *Thread, method string) Value {
+	return suZipReaderMethods[method]
+}
+
+var suZipReaderMethods = methods("zipr")
+
+var _ = method(zipr_List, "()")
+
+// zipr_List returns a list of objects with name, size, compressed, and date
+func zipr_List(this Value) Value {
+	list := &SuObject{}
+	for _, f := range zrOpen(this).zr.File {
+		ob := &SuObject{}
+		ob.Set(SuStr("name"), SuStr(f.Name))
+		ob.Set(SuStr("size"), Int64Val(int64(f.UncompressedSize64)))
+		ob.Set(SuStr("compressed"), Int64Val(int64(f.CompressedSize64)))
+		ob.Set(SuStr("date"), FromGoTime(f.Modified))
+		list.Add(ob)
+	}
+	return list
+}
+
+var _ = method(zipr_Read, "(name)")
+
+// zipr_Read returns the contents of a file in the archive
+func zipr_Read(this, name Value) Value {
+	r := this.(*suZipReader).open("Read", name)
+	defer r.Close()
+	return SuStr(hacks.BStoS(readAllLimited("ZipReader: Read", r)))
+}
+
+var _ = method(zipr_CopyTo, "(name, dest)")
+
+// zipr_CopyTo copies a file in the archive to a stream e.g. File
+func zipr_CopyTo(th *Thread, this Value, args []Value) Value {
+	r := this.(*suZipReader).open("CopyTo", args[0])
+	defer r.Close()
+	return CopyTo(th, r, args[1], False)
+}
+
+var _ = method(zipr_Extract, "(name, filename)")
+
+// zipr_Extract writes a file in the archive to a file on disk
+func zipr_Extract(this, name, filename Value) Value {
+	r := this.(*suZipReader).open("Extract", name)
+	defer r.Close()
+	f, err := os.Create(ToStr(filename))
+	if err == nil {
+		_, err = io.Copy(f, r)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		panic("ZipReader: Extract: " + err.Error())
+	}
+	return nil
+}
+
+var _ = method(zipr_Close, "()")
+
+func zipr_Close(this Value) Value {
+	this.(*suZipReader).close()
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+//go:build gogen
+
+// gogen transpiles the functions and class methods of library tables
+// to Go code in the compiled package, so they are built into the exe.
+//
+// Usage (from the repository root):
+//
+//	go run -tags gogen ./cmd/gogen stdlib.su [mylib.su ...]
+//
+// The input is a single table dump (e.g. from gsuneido -dump stdlib)
+// or a directory of .ss source files (the directory name is the table).
+// At runtime libload uses the compiled version
+// if the source is unchanged, otherwise it falls back to byte code.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"go/format"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/apmckinlay/gsuneido/compile"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/dbms/query"
+)
+
+const outdir = "compiled"
+
+type record struct {
+	name string
+	text string
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalln("usage: gogen table.su|directory ...")
+	}
+	for _, arg := range os.Args[1:] {
+		table, recs, err := read(arg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := gen(table, recs); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+func read(path string) (string, []record, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if fi.IsDir() {
+		return readDir(path)
+	}
+	return readDump(path)
+}
+
+func readDir(dir string) (string, []record, error) {
+	var recs []record
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".ss" {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(d.Name(), ".ss")
+		recs = append(recs, record{name: name, text: string(b)})
+		return nil
+	})
+	return filepath.Base(filepath.Clean(dir)), recs, err
+}
+
+// readDump reads a single table dump file (see db19/tools/dump.go)
+func readDump(filename string) (table string, recs []record, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("gogen: %s: %v", filename, e)
+		}
+	}()
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if s, _ := r.ReadString('\n'); !strings.HasPrefix(s, "Suneido dump") {
+		return "", nil, fmt.Errorf("gogen: %s: not a valid dump file", filename)
+	}
+	s, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(s, "====== ") {
+		return "", nil, fmt.Errorf("gogen: %s: not a valid dump file", filename)
+	}
+	table = strings.TrimSuffix(filepath.Base(filename), ".su")
+	schema := query.NewAdminParser(table + " " + s[7:]).Schema()
+	iname := slices.Index(schema.Columns, "name")
+	itext := slices.Index(schema.Columns, "text")
+	igroup := slices.Index(schema.Columns, "group")
+	if iname < 0 || itext < 0 || igroup < 0 {
+		return "", nil, fmt.Errorf("gogen: %s: not a library", filename)
+	}
+	intbuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, intbuf); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+		n := int(binary.BigEndian.Uint32(intbuf))
+		if n == 0 {
+			break
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", nil, err
+		}
+		rec := Record(string(buf))
+		if ToInt(rec.GetVal(igroup)) != -1 {
+			continue // folder
+		}
+		recs = append(recs,
+			record{name: rec.GetStr(iname), text: rec.GetStr(itext)})
+	}
+	return table, recs, nil
+}
+
+func gen(table string, recs []record) error {
+	slices.SortFunc(recs, func(x, y record) int {
+		return strings.Compare(x.name, y.name)
+	})
+	var decls, inits strings.Builder
+	nfns, nerrs := 0, 0
+	for i, rec := range recs {
+		lib, name := table, rec.name
+		if j := strings.Index(name, "__"); j > 0 {
+			lib, name = table+name[j:], name[:j] // library tag
+		}
+		prefix := table + "_" + strconv.Itoa(i) + "_"
+		def, err := compile.GoGenLib(prefix, lib, name, rec.text)
+		if err != nil {
+			log.Println("ERROR:", rec.name, err)
+			nerrs++
+			continue
+		}
+		for _, e := range def.Errs {
+			log.Println(rec.name, e)
+		}
+		if len(def.Fns) == 0 {
+			continue
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "func init() {\nRegisterCompiled(%q, %q, %q, "+
+			"map[string]CompiledFn{\n", lib, name, SourceHash(rec.text))
+		methods := make([]string, 0, len(def.Fns))
+		for m := range def.Fns {
+			methods = append(methods, m)
+		}
+		slices.Sort(methods)
+		for _, m := range methods {
+			fmt.Fprintf(&sb, "%q: %s,\n", m, def.Fns[m])
+		}
+		sb.WriteString("})\n}\n\n")
+		// check each record separately so one problem doesn't stop all
+		if _, err := format.Source([]byte("package x\n" + def.Init +
+			sb.String())); err != nil {
+			log.Println("ERROR:", rec.name, err)
+			nerrs++
+			continue
+		}
+		decls.WriteString(def.Init)
+		inits.WriteString(sb.String())
+		nfns += len(def.Fns)
+	}
+	src := "// Code generated by gogen from " + table + ". DO NOT EDIT.\n\n" +
+		"package compiled\n\n" +
+		"import (\n" +
+		"tok \"github.com/apmckinlay/gsuneido/compile/tokens\"\n" +
+		". \"github.com/apmckinlay/gsuneido/core\"\n" +
+		")\n\n" +
+		"var _ = tok.Eof\n\n" +
+		decls.String() + "\n" + inits.String()
+	b, err := format.Source([]byte(src))
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(outdir, table+".go")
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		return err
+	}
+	fmt.Println(file, len(recs), "records", nfns, "functions",
+		nerrs, "errors")
+	return nil
+}
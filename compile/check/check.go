@@ -382,7 +382,7 @@ func (ck *Check) expr(expr ast.Expr, init set) (initOut set, effects bool) {
 		if ascii.IsLower(expr.Name[0]) {
 			init = ck.usedVar(init, expr.Name, int(expr.Pos))
 		}
-		if ascii.IsUpper(expr.Name[0]) {
+		if ascii.IsUpper(expr.Name[0]) && !expr.Implicit {
 			ck.CheckGlobal(expr.Name, int(expr.Pos))
 		}
 	case *ast.Trinary:
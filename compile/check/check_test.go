@@ -110,6 +110,15 @@ func TestCheckResults(t *testing.T) {
 	test("function (f) { switch (f()) { case 1: a=f; case 2: b=2;b() }; a }",
 		"WARNING: used but possibly not initialized: a @62")
 
+	// destructuring and match
+	test("function (f) { [a, b] = f(); a }",
+		"WARNING: initialized but not used: b @19")
+	test("function (f) { [a, name:] = f(); a + name }")
+	test("function (x) { match x { case [a]: return a; case 1: } a }",
+		"WARNING: used but possibly not initialized: a @55")
+	test("function (x) { match x { case [a, _]: return a; default: return b() } }",
+		"ERROR: used but not initialized: b @64")
+
 	// while
 	test("function () { while (false isnt x = 1) { }; x }")
 
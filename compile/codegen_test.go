@@ -64,6 +64,9 @@ func TestCodegen(t *testing.T) {
 	test("'hello' $ ' ' $ 'world'", "Value 'hello world'") // constant folding
 	test("a $ 'sep' $ b $ 'sep' $ c", "LoadValue a 'sep', LoadValue b 'sep', Load c, CatN 5")
 	test("x $ y $ z $ w $ v $ u", "LoadLoad x y, LoadLoad z w, LoadLoad v u, CatN 6")
+	test("i'{a}'", "EmptyStr, Load a, Cat")
+	test("i'a {b} c {d.F()}'",
+		"Value 'a ', LoadValue b ' c ', LoadValue d 'F', CallMethNoNil (), CatN 4")
 
 	test("a is b", "LoadLoad a b, Is")
 	test("a = b", "Load b, Store a")
@@ -211,6 +211,12 @@ func (p *Parser) statement() (result ast.Statement) {
 		p.Next()
 		return p.semi(&ast.Continue{})
 	default:
+		if token == tok.LBracket && p.isDestructure() {
+			return p.destructure()
+		}
+		if p.isMatch() {
+			return p.matchStmt()
+		}
 		exprs := p.exprList()
 		if len(exprs) == 1 {
 			return &ast.ExprStmt{E: exprs[0]}
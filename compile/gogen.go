@@ -8,642 +8,1017 @@ package compile
 import (
 	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/apmckinlay/gsuneido/compile/ast"
+	. "github.com/apmckinlay/gsuneido/compile/lexer"
 	tok "github.com/apmckinlay/gsuneido/compile/tokens"
 	. "github.com/apmckinlay/gsuneido/core"
-	"github.com/apmckinlay/gsuneido/util/ascii"
 	"github.com/apmckinlay/gsuneido/util/str"
 )
 
+// gogen transpiles Suneido functions and methods to Go source code.
+// The generated functions have the CompiledFn signature
+// and are attached to the byte code by core.Compiled.
+// Anything that is not handled (e.g. blocks, super, dynamic variables)
+// panics with unsupported, and the function is left as byte code.
+
 func GogenParser(src string) *Parser {
-	return newParser(NewLexer(src), &gogenAspects{})
+	return newParser(NewCodeLexer(src), &gogenAspects{})
 }
 
 // gogenAspects is used when transpiling to Go ----------------------
 type gogenAspects struct {
 	cgAspectsBase
 	nilChecker
+	// prefix is used to make package level names unique
+	prefix string
+	// init accumulates package level declarations
+	init strings.Builder
+	next int
+	// errs records why functions could not be transpiled
+	errs []string
 }
 
+// GoGen returns the Go code for a single function.
+// It panics if the function can not be transpiled.
+// It is primarily for tests.
 func GoGen(src string) string {
 	p := GogenParser(src)
+	a := p.Aspects.(*gogenAspects)
 	f := p.constant().(*SuFunc)
 	if p.Token != tok.Eof {
 		p.Error("did not consume all input")
 	}
-	return f.Code
+	if len(a.errs) > 0 {
+		panic(a.errs[0])
+	}
+	return a.init.String() + f.Code
+}
+
+// GoDef is the result of GoGenLib
+type GoDef struct {
+	// Init is package level declarations used by the code
+	Init string
+	// Fns is keyed by method name, or "" for a function
+	Fns map[string]string
+	// Errs lists the reasons for functions that were not transpiled
+	Errs []string
 }
 
-// gogen compiles an ast.Function to Go source code placed in SuFunc.Code.
+// GoGenLib transpiles a library record.
+// The name is required so private members are named the same as libload.
+// prefix should be unique within the Go package.
+func GoGenLib(prefix, lib, name, src string) (def GoDef, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	a := &gogenAspects{prefix: prefix}
+	p := newParser(NewCodeLexer(src), a)
+	p.lib = lib
+	p.name = name
+	v := p.constant()
+	if p.Token != tok.Eof {
+		p.Error("did not consume all input")
+	}
+	fns := map[string]string{}
+	switch v := v.(type) {
+	case *SuFunc:
+		if v.Code != "" {
+			fns[""] = v.Code
+		}
+	case *SuClass:
+		for m, x := range v.Data {
+			if f, ok := x.(*SuFunc); ok && f.Code != "" {
+				fns[m] = f.Code
+			}
+		}
+	default:
+		return def, nil // not code
+	}
+	return GoDef{Init: a.init.String(), Fns: fns, Errs: a.errs}, nil
+}
+
+type unsupported string
+
+// codegen compiles an ast.Function to Go source code placed in SuFunc.Code.
 // Using SuFunc for output is for compatibility with byte code codegen.
-func (*gogenAspects) codegen(_, _ string, f *ast.Function, _ Value) Value {
-	if len(f.Final) > 0 {
-		ast.PropFold(f)
+// If the function can not be transpiled, Code is empty.
+func (a *gogenAspects) codegen(_, _ string, fn *ast.Function, _ Value) Value {
+	code, err := a.gen(fn)
+	if err != "" {
+		a.errs = append(a.errs, err)
 	}
-	var g ggen
-	g.locals = make(map[string]struct{})
-	g.function(f)
-	init := ""
-	if g.init.Len() > 0 {
-		init = g.init.String()
+	return &SuFunc{Code: code}
+}
+
+func (a *gogenAspects) gen(fn *ast.Function) (code string, err string) {
+	defer func() {
+		if e := recover(); e != nil {
+			if u, ok := e.(unsupported); ok {
+				code, err = "", "unsupported: "+string(u)
+				return
+			}
+			panic(e)
+		}
+	}()
+	if len(fn.Final) > 0 {
+		ast.PropFold(fn)
 	}
-	return &SuFunc{Code: init + g.String()}
+	g := &ggen{a: a, next: a.next, params: map[string]bool{},
+		locals: map[string]bool{}, reads: map[string]bool{},
+		globals: map[string]string{},
+		breaks:  map[ast.Statement]bool{}}
+	g.function(fn)
+	a.init.WriteString(g.init.String())
+	a.next = g.next
+	return g.code(), ""
 }
 
 type ggen struct {
-	locals map[string]struct{}
-	init   strings.Builder
 	str.Builder
-	next int
+	a      *gogenAspects
+	init   strings.Builder
+	next   int
+	params map[string]bool
+	pnames []string
+	locals map[string]bool
+	lnames []string
+	reads  map[string]bool
+	// globals maps global names to package level Gnum variables
+	globals map[string]string
+	loops   []*gloop
+	// breaks records which loops have breaks (for terminates)
+	breaks map[ast.Statement]bool
+	inTry  bool
+}
+
+type gloop struct {
+	stmt  ast.Statement
+	label string
+	used  bool
+}
+
+func (g *ggen) code() string {
+	var sb strings.Builder
+	sb.WriteString("func(t *Thread, this Value, args []Value) Value {\n")
+	for i, p := range g.pnames {
+		if g.reads[p] || g.locals[p] {
+			fmt.Fprintf(&sb, "%s := args[%d]\n", goName(p), i)
+		}
+	}
+	for _, v := range g.lnames {
+		if !g.params[v] {
+			fmt.Fprintf(&sb, "var %s Value\n", goName(v))
+		}
+	}
+	for _, v := range g.lnames {
+		if !g.reads[v] {
+			fmt.Fprintf(&sb, "_ = %s\n", goName(v))
+		}
+	}
+	sb.WriteString(g.String())
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func unsupp(what string) {
+	panic(unsupported(what))
 }
 
 func (g *ggen) function(fn *ast.Function) {
-	g.params(fn.Params)
-	g.Add("{\n")
+	if fn.HasBlocks {
+		unsupp("blocks")
+	}
+	if fn.IsNewMethod {
+		unsupp("New method")
+	}
+	for _, p := range fn.Params {
+		name, flags := param(p.Name.Name)
+		if flags&DynParam != 0 {
+			unsupp("dynamic parameter")
+		}
+		g.params[name] = true
+		g.pnames = append(g.pnames, name)
+	}
 	stmts := fn.Body
 	for si, stmt := range stmts {
-		g.statement(stmt, si == len(stmts)-1)
-	}
-	if len(stmts) == 0 {
-		g.Add("return nil\n")
+		lastStmt := si == len(stmts)-1
+		g.statement(stmt, lastStmt)
+		if _, ok := stmt.(*ast.ExprStmt); ok && lastStmt {
+			return // statement generated a return
+		}
+		if g.terminates(stmt) {
+			return // avoid unreachable code
+		}
 	}
-	g.Add("}")
+	g.Add("return nil\n")
 }
 
-func (g *ggen) params(ps []ast.Param) {
-	g.Add("func(")
-	sep := ""
-	for _, p := range ps {
-		g.Adds(sep, p.Name.Name)
-		g.locals[p.Name.Name] = struct{}{}
-		sep = ", "
-	}
-	if len(ps) > 0 {
-		g.Add(" Value")
+// terminates mirrors the Go rules for terminating statements
+// to avoid generating unreachable code
+func (g *ggen) terminates(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.Return, *ast.Throw:
+		return true
+	case *ast.Compound:
+		return len(stmt.Body) > 0 && g.terminates(stmt.Body[len(stmt.Body)-1])
+	case *ast.If:
+		return stmt.Else != nil &&
+			g.terminates(stmt.Then) && g.terminates(stmt.Else)
+	case *ast.Forever:
+		return !g.breaks[stmt]
+	case *ast.For:
+		return stmt.Cond == nil && !g.breaks[stmt]
+	case *ast.Switch:
+		if stmt.Default != nil && !g.terminates(&ast.Compound{Body: stmt.Default}) {
+			return false
+		}
+		for _, c := range stmt.Cases {
+			if !g.terminates(&ast.Compound{Body: c.Body}) {
+				return false
+			}
+		}
+		return true
 	}
-	g.Add(") Value ")
+	return false
 }
 
+// statements ------------------------------------------------------------------
+
 func (g *ggen) statement(node ast.Statement, lastStmt bool) {
-	if node == nil {
-		return
-	}
 	switch node := node.(type) {
+	case nil:
 	case *ast.Compound:
 		g.statements(node.Body)
-		if lastStmt {
-			g.Add("return nil\n")
-		}
-		return
 	case *ast.Return:
-		g.returnStmt(node.E)
-		g.Add("\n")
-		return
+		g.returnStmt(node)
 	case *ast.If:
 		g.ifStmt(node)
+	case *ast.Switch:
+		g.switchStmt(node)
 	case *ast.Forever:
-		g.foreverStmt(node)
+		g.loop(node, func() {
+			g.Add("for {\n")
+			g.loopBody(node.Body)
+			g.Add("}\n")
+		})
 	case *ast.While:
-		g.whileStmt(node)
+		g.loop(node, func() {
+			g.Adds("for ", g.cond(node.Cond), " {\n")
+			g.loopBody(node.Body)
+			g.Add("}\n")
+		})
 	case *ast.DoWhile:
-		g.dowhileStmt(node)
+		g.loop(node, func() {
+			g.Adds("for _first_ := true; _first_ || ", g.cond(node.Cond),
+				"; _first_ = false {\n")
+			g.loopBody(node.Body)
+			g.Add("}\n")
+		})
 	case *ast.For:
 		g.forStmt(node)
 	case *ast.ForIn:
-		g.forinStmt(node)
+		g.forInStmt(node)
 	case *ast.Break:
-		g.Add("break")
+		g.Adds("break ", g.label(true), "\n")
 	case *ast.Continue:
-		g.Add("continue")
+		g.Adds("continue ", g.label(false), "\n")
 	case *ast.Throw:
-		g.throwStmt(node)
-		g.Add("\n")
-		return
+		g.Adds("panic(", g.expr(node.E), ")\n")
 	case *ast.TryCatch:
-		g.trycatchStmt(node)
+		g.tryCatchStmt(node)
 	case *ast.ExprStmt:
-		g.exprStmt(node.E, lastStmt)
-		g.Add("\n")
-		return
+		if lastStmt {
+			g.Adds("return ", g.exprNilOk(node.E), "\n")
+		} else {
+			g.void(node.E)
+		}
+	case *ast.MultiAssign:
+		unsupp("multiple assignment")
 	default:
-		panic("unhandled statement " + fmt.Sprintf("%T", node))
-	}
-	g.Add("\n")
-	if lastStmt {
-		g.Add("return nil\n")
+		panic("gogen: unexpected statement type " + fmt.Sprintf("%T", node))
 	}
 }
 
 func (g *ggen) statements(stmts []ast.Statement) {
 	for _, stmt := range stmts {
 		g.statement(stmt, false)
+		if g.terminates(stmt) {
+			break // avoid unreachable code
+		}
 	}
 }
 
-func (g *ggen) returnStmt(expr ast.Expr) {
-	g.Add("return ")
-	if expr == nil {
-		g.Add("nil")
+func (g *ggen) returnStmt(node *ast.Return) {
+	if g.inTry {
+		unsupp("return within try")
+	}
+	if len(node.Exprs) > 1 {
+		unsupp("multiple return values")
+	}
+	if node.ReturnThrow {
+		g.Add("t.ReturnThrow = true\n")
+	}
+	if len(node.Exprs) == 0 {
+		g.Add("return nil\n")
 	} else {
-		g.expr(expr, suvalue)
+		g.Adds("return ", g.exprNilOk(node.Exprs[0]), "\n")
 	}
 }
 
 func (g *ggen) ifStmt(node *ast.If) {
-	g.Add("if ")
-	g.expr(node.Cond, gobool)
-	g.Add(" {\n")
+	g.Adds("if ", g.cond(node.Cond), " {\n")
 	g.statement(node.Then, false)
-	if node.Else == nil {
-		g.Add("}")
-	} else {
+	if node.Else != nil {
 		g.Add("} else {\n")
 		g.statement(node.Else, false)
-		g.Add("}")
 	}
+	g.Add("}\n")
 }
 
-func (g *ggen) foreverStmt(node *ast.Forever) {
-	g.Add("for {\n")
-	g.statement(node.Body, false)
-	g.Add("}")
+func (g *ggen) switchStmt(node *ast.Switch) {
+	sw := g.temp("sw")
+	g.Adds("switch ", sw, " := ", g.expr(node.E), "; {\n")
+	for _, c := range node.Cases {
+		g.Add("case ")
+		sep := ""
+		for _, e := range c.Exprs {
+			g.Adds(sep, sw, ".Equal(", g.expr(e), ")")
+			sep = ", "
+		}
+		g.Add(":\n")
+		g.statements(c.Body)
+	}
+	g.Add("default:\n")
+	if node.Default == nil {
+		g.Add("panic(SuStr(\"unhandled switch value\"))\n")
+	} else {
+		g.statements(node.Default)
+	}
+	g.Add("}\n")
 }
 
-func (g *ggen) whileStmt(node *ast.While) {
-	g.Add("for ")
-	g.expr(node.Cond, gobool)
-	g.Add(" {\n")
-	g.statement(node.Body, false)
-	g.Add("}")
+// loop handles labels so break and continue work from within switch.
+// The label is only added if it is used.
+func (g *ggen) loop(node ast.Statement, gen func()) {
+	lp := &gloop{stmt: node, label: g.temp("L")}
+	g.loops = append(g.loops, lp)
+	pos := g.Len()
+	gen()
+	g.loops = g.loops[:len(g.loops)-1]
+	if lp.used {
+		g.Insert(pos, lp.label+":\n")
+	}
 }
 
-func (g *ggen) dowhileStmt(node *ast.DoWhile) {
-	g.Add("for {\n")
-	g.statement(node.Body, false)
-	g.Add("if !")
-	g.expr(node.Cond, gobool)
-	g.Add(" { break }\n}")
+// loopBody checks for cancel on each iteration, like the interpreter does
+// on backward jumps, so Cancel can stop loops in compiled code
+func (g *ggen) loopBody(body ast.Statement) {
+	g.Add("t.CheckCancel()\n")
+	g.statement(body, false)
 }
 
-func (g *ggen) forStmt(node *ast.For) {
-	// init can be outside the for statement
-	for _, e := range node.Init {
-		g.expr(e, void)
-		g.Add("\n")
-	}
-	g.Add("for ; ")
-	if node.Cond != nil {
-		g.expr(node.Cond, gobool)
-	}
-	g.Add("; ")
-	// put increment in for statement so continue works
-	if len(node.Inc) == 1 {
-		g.expr(node.Inc[0], void)
-	} else if len(node.Inc) > 1 {
-		g.Add("func(){ ")
-		sep := ""
-		for _, e := range node.Inc {
-			g.Add(sep)
-			sep = "; "
-			g.expr(e, void)
-		}
-		g.Add(" }()")
+func (g *ggen) label(brk bool) string {
+	if len(g.loops) == 0 {
+		unsupp("break or continue outside loop")
+	}
+	lp := g.loops[len(g.loops)-1]
+	lp.used = true
+	if brk {
+		g.breaks[lp.stmt] = true
 	}
-	g.Add(" {\n")
-	g.statement(node.Body, false)
-	g.Add("}")
+	return lp.label
 }
 
-func (g *ggen) forinStmt(node *ast.ForIn) {
-	v := node.Var.Name
-	if _, ok := g.locals[v]; !ok {
-		g.Adds("var ", v, " Value\n")
+func (g *ggen) forStmt(node *ast.For) {
+	for _, e := range node.Init {
+		g.void(e)
 	}
-	g.Add("for _it_ := OpIter(")
-	g.expr(node.E, suvalue)
-	g.Adds("); ; {\n",
-		v, " = _it_.Next()\n"+
-			"if ", v, " == nil { break }\n")
-	g.statement(node.Body, false)
-	g.Add("}")
+	g.loop(node, func() {
+		g.Add("for ; ")
+		if node.Cond != nil {
+			g.Add(g.cond(node.Cond))
+		}
+		g.Add("; ")
+		if len(node.Inc) > 0 {
+			// a closure so the increment can be any statement
+			g.Add("func() {\n")
+			for _, e := range node.Inc {
+				g.void(e)
+			}
+			g.Add("}()")
+		}
+		g.Add(" {\n")
+		g.loopBody(node.Body)
+		g.Add("}\n")
+	})
 }
 
-func (g *ggen) throwStmt(node *ast.Throw) {
-	g.Add("panic(")
-	g.expr(node.E, suvalue)
-	g.Add(")")
+func (g *ggen) forInStmt(node *ast.ForIn) {
+	if node.Var2.Name != "" {
+		unsupp("for m, v in")
+	}
+	v := ""
+	if node.Var.Name != "" {
+		v = g.store(node.Var.Name)
+	}
+	if node.E2 != nil {
+		i, end := g.temp("i"), g.temp("end")
+		g.loop(node, func() {
+			g.Adds("for ", i, ", ", end, " := OpAdd(", g.expr(node.E),
+				", Zero), ", g.expr(node.E2), "; OpLt(", i, ", ", end,
+				") == True; ", i, " = OpAdd1(", i, ") {\n")
+			if v != "" {
+				g.Adds(v, " = ", i, "\n")
+			}
+			g.loopBody(node.Body)
+			g.Add("}\n")
+		})
+		return
+	}
+	it := g.temp("it")
+	g.loop(node, func() {
+		g.Adds("for ", it, " := OpIter(", g.expr(node.E), "); ; {\n",
+			v, " = ", it, ".Next()\n",
+			"if ", v, " == nil {\nbreak\n}\n")
+		g.loopBody(node.Body)
+		g.Add("}\n")
+	})
 }
 
-func (g *ggen) trycatchStmt(node *ast.TryCatch) {
-	g.Add("func() {\n" +
-		"defer func() {\n" +
-		"if _e_ := recover(); _e_ != nil {\n")
+func (g *ggen) tryCatchStmt(node *ast.TryCatch) {
+	loops, inTry := g.loops, g.inTry
+	g.loops, g.inTry = nil, true
+	g.Add("OpTryCatch(t, func() {\n")
+	g.statement(node.Try, false)
+	g.Adds("}, ", strconv.Quote(node.CatchFilter), ", func(_e_ *SuExcept) {\n")
 	if node.CatchVar.Name != "" {
-		g.Adds(node.CatchVar.Name, " = ") //TODO := declare var ?
+		g.Adds(g.store(node.CatchVar.Name), " = _e_\n")
 	}
-	g.Add(fmt.Sprintf("OpCatch(t, _e_, %q)\n", node.CatchFilter))
 	g.statement(node.Catch, false)
-	g.Add("}\n}()\n")
-	g.statement(node.Try, false)
-	g.Add("}()") // end outer func
+	g.Add("})\n")
+	g.loops, g.inTry = loops, inTry
 }
 
-func (g *ggen) exprStmt(expr ast.Expr, lastStmt bool) {
-	if lastStmt {
-		g.Add("return ")
-		g.expr(expr, suvalue)
-	} else {
-		if _, ok := expr.(*ast.Constant); ok {
+// void generates an expression as a statement
+func (g *ggen) void(node ast.Expr) {
+	switch node := node.(type) {
+	case *ast.Constant:
+		return
+	case *ast.Unary:
+		switch node.Tok {
+		case tok.LParen:
+			g.void(node.E)
+			return
+		case tok.Inc, tok.PostInc:
+			g.opeqStmt(node.E, cOne, tok.AddEq)
+			return
+		case tok.Dec, tok.PostDec:
+			g.opeqStmt(node.E, cOne, tok.SubEq)
 			return
 		}
-		g.expr(expr, void)
+	case *ast.Binary:
+		switch node.Tok {
+		case tok.Eq:
+			g.assignStmt(node.Lhs, g.expr(node.Rhs))
+			return
+		case tok.AddEq, tok.SubEq, tok.CatEq, tok.MulEq, tok.DivEq, tok.ModEq,
+			tok.LShiftEq, tok.RShiftEq, tok.BitOrEq, tok.BitAndEq, tok.BitXorEq:
+			g.opeqStmt(node.Lhs, node.Rhs, node.Tok)
+			return
+		}
+	case *ast.Trinary:
+		g.Adds("if ", g.cond(node.Cond), " {\n")
+		g.void(node.T)
+		g.Add("} else {\n")
+		g.void(node.F)
+		g.Add("}\n")
+		return
+	case *ast.Call:
+		g.Adds("OpDiscard(t, ", g.call(node), ")\n")
+		return
 	}
+	g.Adds("_ = ", g.exprOrCond(node), "\n")
 }
 
-// expressions -----------------------------------------------------------------
+func (g *ggen) assignStmt(lhs ast.Expr, rhs string) {
+	switch lhs := lhs.(type) {
+	case *ast.Ident:
+		g.Adds(g.store(lhs.Name), " = ", rhs, "\n")
+		return
+	case *ast.Mem:
+		ob, m := g.mem(lhs)
+		g.Adds(ob, ".Put(t, ", m, ", ", rhs, ")\n")
+		return
+	}
+	panic("gogen: unexpected lvalue " + lhs.String())
+}
 
-type resultType int
+func (g *ggen) opeqStmt(lhs, rhs ast.Expr, op tok.Token) {
+	switch lhs := lhs.(type) {
+	case *ast.Ident:
+		v := g.store(lhs.Name)
+		g.Adds(v, " = ", opeqFn[op], "(", g.load(lhs.Name), ", ",
+			g.expr(rhs), ")\n")
+		return
+	case *ast.Mem:
+		ob, m := g.mem(lhs)
+		g.Adds(ob, ".GetPut(t, ", m, ", ", g.expr(rhs), ", ",
+			opeqFn[op], ", false)\n")
+		return
+	}
+	panic("gogen: unexpected lvalue " + lhs.String())
+}
 
-const (
-	void resultType = iota
-	suvalue
-	gobool
-)
+var cOne = &ast.Constant{Val: One}
 
-func (g *ggen) expr(node ast.Expr, want resultType) {
-	result := suvalue
-	pos := g.Len()
+var opeqFn = map[tok.Token]string{
+	tok.AddEq:    "OpAdd",
+	tok.SubEq:    "OpSub",
+	tok.CatEq:    "t.Cat",
+	tok.MulEq:    "OpMul",
+	tok.DivEq:    "OpDiv",
+	tok.ModEq:    "OpMod",
+	tok.LShiftEq: "OpLeftShift",
+	tok.RShiftEq: "OpRightShift",
+	tok.BitOrEq:  "OpBitOr",
+	tok.BitAndEq: "OpBitAnd",
+	tok.BitXorEq: "OpBitXor",
+}
+
+// variables -------------------------------------------------------------------
+
+func goName(name string) string {
+	name = strings.ReplaceAll(name, "?", "_Q_")
+	name = strings.ReplaceAll(name, "!", "_X_")
+	name = strings.ReplaceAll(name, "$", "_S_") // temporaries
+	return "v_" + name
+}
+
+// store returns the Go name for a local variable that is assigned
+func (g *ggen) store(name string) string {
+	checkLocal(name)
+	if !g.locals[name] {
+		g.locals[name] = true
+		g.lnames = append(g.lnames, name)
+	}
+	return goName(name)
+}
+
+// load returns the Go code for reading a local variable
+func (g *ggen) load(name string) string {
+	checkLocal(name)
+	g.reads[name] = true
+	if g.params[name] {
+		return goName(name) // params are always initialized
+	}
+	if !g.locals[name] {
+		g.locals[name] = true
+		g.lnames = append(g.lnames, name)
+	}
+	return "OpLoad(" + goName(name) + ", " + strconv.Quote(name) + ")"
+}
+
+func checkLocal(name string) {
+	if name[0] == '_' {
+		unsupp("dynamic variable")
+	}
+}
+
+func (g *ggen) temp(s string) string {
+	g.next++
+	return "_" + s + strconv.Itoa(g.next) + "_"
+}
+
+// pkgName returns a unique package level name
+func (g *ggen) pkgName(s string) string {
+	g.next++
+	return "_" + g.a.prefix + s + strconv.Itoa(g.next) + "_"
+}
+
+// expressions -----------------------------------------------------------------
+
+// expr returns Go code for an expression that evaluates to a Value
+func (g *ggen) expr(node ast.Expr) string {
 	switch node := node.(type) {
 	case *ast.Constant:
-		g.value(node.Val)
+		return g.constant(node.Val)
 	case *ast.Ident:
-		g.ident(node)
+		return g.ident(node)
 	case *ast.Unary:
-		result = g.unary(node, want)
+		return g.unary(node)
 	case *ast.Binary:
-		result = g.binary(node, want)
+		return g.binary(node)
 	case *ast.Nary:
-		result = g.nary(node, want)
+		return g.nary(node)
 	case *ast.Trinary:
-		g.trinary(node, want)
-	default:
-		panic("unhandled expression: " + node.String())
+		return "func() Value {\nif " + g.cond(node.Cond) + " {\nreturn " +
+			g.expr(node.T) + "\n}\nreturn " + g.expr(node.F) + "\n}()"
+	case *ast.Mem:
+		ob, m := g.mem(node)
+		return "OpGet(t, " + ob + ", " + m + ")"
+	case *ast.RangeTo:
+		return g.expr(node.E) + ".RangeTo(" + g.index(node.From) + ", " +
+			g.count(node.To) + ")"
+	case *ast.RangeLen:
+		return g.expr(node.E) + ".RangeLen(" + g.index(node.From) + ", " +
+			g.count(node.Len) + ")"
+	case *ast.In, *ast.InRange:
+		return "SuBool(" + g.cond(node) + ")"
+	case *ast.Call:
+		return "OpNoNil(t, " + g.call(node) + ")"
+	case *ast.Block:
+		unsupp("blocks")
+	}
+	panic("gogen: unexpected expression type " + fmt.Sprintf("%T", node))
+}
+
+// exprNilOk is used for return values, so call results can be nil
+func (g *ggen) exprNilOk(node ast.Expr) string {
+	if call, ok := node.(*ast.Call); ok {
+		return g.call(call)
 	}
-	if result == suvalue && want == gobool {
-		g.Insert(pos, "OpBool(")
-		g.Add(")")
-	} else if result == gobool && want == suvalue {
-		g.Insert(pos, "SuBool(")
-		g.Add(")")
-	} else if want != void && result != want {
-		panic("didn't get desired expression type")
+	if u, ok := node.(*ast.Unary); ok && u.Tok == tok.LParen {
+		return g.exprNilOk(u.E)
 	}
+	return g.expr(node)
 }
 
-func (g *ggen) ident(node *ast.Ident) {
-	c := node.Name[0]
-	if ascii.IsLower(c) {
-		g.Add(node.Name) // local
-	} else {
-		panic("unhandled identifier " + node.Name)
-	}
-}
-
-func (g *ggen) value(val Value) {
-	if val == True {
-		g.Add("True")
-	} else if val == False {
-		g.Add("False")
-	} else if val == Zero {
-		g.Add("Zero")
-	} else if val == One {
-		g.Add("One")
-	} else if val == MinusOne {
-		g.Add("MinusOne")
-	} else if val == EmptyStr {
-		g.Add("EmptyStr")
-	} else if f, ok := val.(*SuFunc); ok {
-		g.Add(f.Code) //TODO needs to be SuBuiltin with ParamSpec
-	} else {
-		g.Add(g.pack64(val))
+// exprOrCond is used where the result is discarded
+func (g *ggen) exprOrCond(node ast.Expr) string {
+	if isCond(node) {
+		return g.cond(node)
 	}
+	return g.expr(node)
 }
 
-func (g *ggen) pack64(v Value) string {
-	name := fmt.Sprintf("_c%d_", g.next)
-	g.next++
-	data := Pack2(v.(Packable)).Buffer()
+func isCond(node ast.Expr) bool {
+	switch node := node.(type) {
+	case *ast.In, *ast.InRange:
+		return true
+	case *ast.Nary:
+		return node.Tok == tok.And || node.Tok == tok.Or
+	}
+	return false
+}
+
+func (g *ggen) index(node ast.Expr) string {
+	if node == nil {
+		return "0"
+	}
+	return "ToIndex(" + g.expr(node) + ")"
+}
+
+func (g *ggen) count(node ast.Expr) string {
+	if node == nil {
+		return "ToInt(MaxInt)"
+	}
+	return "ToInt(" + g.expr(node) + ")"
+}
+
+// cond returns Go code for an expression that evaluates to a Go bool
+func (g *ggen) cond(node ast.Expr) string {
+	switch node := node.(type) {
+	case *ast.Constant:
+		if node.Val == True {
+			return "true"
+		} else if node.Val == False {
+			return "false"
+		}
+	case *ast.Unary:
+		switch node.Tok {
+		case tok.LParen:
+			return "(" + g.cond(node.E) + ")"
+		case tok.Not:
+			return "!" + g.cond(node.E)
+		}
+	case *ast.Binary:
+		switch node.Tok {
+		case tok.Is, tok.Isnt, tok.Lt, tok.Lte, tok.Gt, tok.Gte:
+			return g.binary(node) + " == True"
+		case tok.Match:
+			return "bool(" + g.binary(node) + ")"
+		case tok.MatchNot:
+			return "!bool(" + g.match(node) + ")"
+		}
+	case *ast.Nary:
+		if node.Tok == tok.And || node.Tok == tok.Or {
+			op := " && "
+			if node.Tok == tok.Or {
+				op = " || "
+			}
+			s := "("
+			for i, e := range node.Exprs {
+				if i > 0 {
+					s += op
+				}
+				s += g.cond(e)
+			}
+			return s + ")"
+		}
+	case *ast.In:
+		x := g.temp("x")
+		s := "func() bool {\n" + x + " := " + g.expr(node.E) + "\nreturn "
+		for i, e := range node.Exprs {
+			if i > 0 {
+				s += " || "
+			}
+			s += x + ".Equal(" + g.expr(e) + ")"
+		}
+		if len(node.Exprs) == 0 {
+			s += "false"
+		}
+		return s + "\n}()"
+	case *ast.InRange:
+		return "OpInRange(" + g.expr(node.E) + ", tok." + node.OrgTok.String() +
+			", " + g.expr(node.Org) + ", tok." + node.EndTok.String() + ", " +
+			g.expr(node.End) + ") == True"
+	}
+	return "OpBool(" + g.expr(node) + ")"
+}
+
+func (g *ggen) ident(node *ast.Ident) string {
+	switch {
+	case node.Name == "this":
+		return "this"
+	case node.Name == "super":
+		unsupp("super")
+	case isLocal(node.Name):
+		return g.load(node.Name)
+	case node.Name[0] == '_':
+		unsupp("reference to _" + node.Name[1:])
+	}
+	gn, ok := g.globals[node.Name]
+	if !ok {
+		gn = g.pkgName("g")
+		g.globals[node.Name] = gn
+		g.init.WriteString("var " + gn + " = Global.Num(" +
+			strconv.Quote(node.Name) + ")\n")
+	}
+	return "Global.Get(t, " + gn + ")"
+}
+
+func (g *ggen) constant(val Value) string {
+	switch val {
+	case True:
+		return "True"
+	case False:
+		return "False"
+	case Zero:
+		return "Zero"
+	case One:
+		return "One"
+	case MinusOne:
+		return "MinusOne"
+	case EmptyStr:
+		return "EmptyStr"
+	}
+	if s, ok := val.(SuStr); ok {
+		return "SuStr(" + strconv.Quote(string(s)) + ")"
+	}
+	if n, ok := val.IfInt(); ok {
+		return "IntVal(" + strconv.Itoa(n) + ")"
+	}
+	switch val.(type) {
+	case *SuFunc, *SuClass:
+		unsupp("nested function or class")
+	}
+	p, ok := val.(Packable)
+	if !ok {
+		unsupp("constant " + val.String())
+	}
+	return g.pack64(p)
+}
+
+func (g *ggen) pack64(v Packable) string {
+	defer func() {
+		if e := recover(); e != nil {
+			unsupp(fmt.Sprint("constant ", e)) // e.g. can't pack function
+		}
+	}()
+	data := Pack(v)
+	name := g.pkgName("c")
 	buf := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
-	base64.StdEncoding.Encode(buf, data)
+	base64.StdEncoding.Encode(buf, []byte(data))
 	g.init.WriteString("var " + name + " = Unpack64(`")
-	for ; len(buf) >= 64; buf = buf[64:] {
+	for ; len(buf) > 64; buf = buf[64:] {
 		g.init.Write(buf[:64])
 		g.init.WriteByte('\n')
 	}
-	if len(buf) > 0 {
-		g.init.Write(buf)
-		g.init.WriteString("`)\n")
-	}
+	g.init.Write(buf)
+	g.init.WriteString("`)\n")
 	return name
 }
 
-var cOne = &ast.Constant{Val: One}
-
-func (g *ggen) unary(node *ast.Unary, want resultType) resultType {
+func (g *ggen) unary(node *ast.Unary) string {
 	switch node.Tok {
 	case tok.LParen:
-		g.expr(node.E, want)
-		return want
-	case tok.Not:
-		if want == gobool {
-			g.Add("!")
-			g.expr(node.E, gobool)
-			return gobool
-		}
-		fallthrough
-	case tok.Add, tok.Sub:
-		g.Add(uopfn[node.Tok])
-		g.expr(node.E, want)
-		g.Add(")")
-		return suvalue
+		return g.expr(node.E)
+	case tok.Add, tok.Sub, tok.Not, tok.BitNot:
+		return uopFn[node.Tok] + "(" + g.expr(node.E) + ")"
 	case tok.Div:
-		g.Add("OpDiv(One, ")
-		g.expr(node.E, suvalue)
-		g.Add(")")
-		return suvalue
-	case tok.PostInc:
-		if want == suvalue {
-			return g.postIncDec(node.E, "OpAdd")
-		}
-		fallthrough
+		return "OpDiv(One, " + g.expr(node.E) + ")"
 	case tok.Inc:
-		return g.opeq(node.E, cOne, tok.AddEq, want)
-	case tok.PostDec:
-		if want == suvalue {
-			return g.postIncDec(node.E, "OpSub")
-		}
-		fallthrough
+		return g.opeq(node.E, cOne, tok.AddEq, false)
+	case tok.PostInc:
+		return g.opeq(node.E, cOne, tok.AddEq, true)
 	case tok.Dec:
-		return g.opeq(node.E, cOne, tok.SubEq, want)
+		return g.opeq(node.E, cOne, tok.SubEq, false)
+	case tok.PostDec:
+		return g.opeq(node.E, cOne, tok.SubEq, true)
 	}
-	panic("unhandled unary " + node.String())
+	panic("gogen: unexpected unary " + node.String())
 }
 
-func (g *ggen) postIncDec(x ast.Expr, op string) resultType {
-	if id := g.localVar(x); id != "" {
-		g.Adds("func(){ _r_ := ", id, "; ",
-			id, " = ", op, "(_r_, One); "+
-				"return _r_ }()")
-		return suvalue
-	}
-	// else member
-	lhs := x.(*ast.Mem)
-	g.expr(lhs.E, suvalue)
-	g.Add(".GetPut(t, ")
-	g.expr(lhs.M, suvalue)
-	g.Adds(", One, ", op, ", true)") // true means return original value
-	return suvalue
+var uopFn = map[tok.Token]string{
+	tok.Add:    "OpUnaryPlus",
+	tok.Sub:    "OpUnaryMinus",
+	tok.Not:    "OpNot",
+	tok.BitNot: "OpBitNot",
 }
 
-var uopfn = map[tok.Token]string{
-	tok.Add:    "OpUnaryPlus(",
-	tok.Sub:    "OpUnaryMinus(",
-	tok.Not:    "OpNot(",
-	tok.BitNot: "OpBitNot(",
+// opeq handles op= and ++/-- where the result is used
+func (g *ggen) opeq(lhs, rhs ast.Expr, op tok.Token, retOrig bool) string {
+	switch lhs := lhs.(type) {
+	case *ast.Ident:
+		v := g.store(lhs.Name)
+		s := "func() Value {\n_r_ := " + g.load(lhs.Name) + "\n" +
+			v + " = " + opeqFn[op] + "(_r_, " + g.expr(rhs) + ")\nreturn "
+		if retOrig {
+			return s + "_r_\n}()"
+		}
+		return s + v + "\n}()"
+	case *ast.Mem:
+		ob, m := g.mem(lhs)
+		return ob + ".GetPut(t, " + m + ", " + g.expr(rhs) + ", " +
+			opeqFn[op] + ", " + strconv.FormatBool(retOrig) + ")"
+	}
+	panic("gogen: unexpected lvalue " + lhs.String())
 }
 
-func (g *ggen) binary(node *ast.Binary, want resultType) resultType {
+func (g *ggen) binary(node *ast.Binary) string {
 	switch node.Tok {
 	case tok.Eq:
-		if want == void {
-			g.lvalue(node.Lhs)
-			g.expr(node.Rhs, suvalue)
-			g.Add(")")
-			return void
-		}
-		g.Add("func(){ _r_ := ")
-		g.expr(node.Rhs, suvalue)
-		g.Add("; ")
-		g.lvalue(node.Lhs)
-		g.Add("_r_); return _r_ }()")
-		return suvalue
+		switch lhs := node.Lhs.(type) {
+		case *ast.Ident:
+			v := g.store(lhs.Name)
+			return "func() Value {\n" + v + " = " + g.expr(node.Rhs) +
+				"\nreturn " + v + "\n}()"
+		case *ast.Mem:
+			ob, m := g.mem(lhs)
+			return "func() Value {\n_ob_, _m_ := " + ob + ", " + m +
+				"\n_r_ := " + g.expr(node.Rhs) +
+				"\n_ob_.Put(t, _m_, _r_)\nreturn _r_\n}()"
+		}
+		panic("gogen: unexpected lvalue " + node.Lhs.String())
 	case tok.AddEq, tok.SubEq, tok.CatEq, tok.MulEq, tok.DivEq, tok.ModEq,
 		tok.LShiftEq, tok.RShiftEq, tok.BitOrEq, tok.BitAndEq, tok.BitXorEq:
-		return g.opeq(node.Lhs, node.Rhs, node.Tok, want)
-	case tok.Is, tok.Isnt, tok.Lt, tok.Lte, tok.Gt, tok.Gte:
-		s2 := cmpfn[node.Tok]
-		g.Add("(")
-		g.expr(node.Lhs, suvalue)
-		g.Add(s2.s)
-		g.expr(node.Rhs, suvalue)
-		g.Adds(s2.t, ")")
-		return gobool
-	case tok.Match, tok.MatchNot, tok.Mod, tok.LShift, tok.RShift:
-		g.Add(opfn[node.Tok])
-		g.expr(node.Lhs, suvalue)
-		g.Add(", ")
-		g.expr(node.Rhs, suvalue)
-		g.Add(")")
-		return suvalue
-	}
-	panic("unhandled binary " + node.Tok.String())
-}
-
-func (g *ggen) opeq(x, y ast.Expr, op tok.Token, want resultType) resultType {
-	if id := g.localVar(x); id != "" {
-		if want == void {
-			g.Adds(id, " = ", opfn[op], "(", id, ", ")
-			g.expr(y, suvalue)
-			g.Add(")")
-			return void
-		}
-		g.Adds("func(){ _r_ := ", opfn[op], "(")
-		g.expr(x, suvalue)
-		g.Add(", ")
-		g.expr(y, suvalue)
-		g.Adds("); ", id, " = _r_; return _r_ }()")
-		return suvalue
-	}
-	// else member
-	lhs := x.(*ast.Mem)
-	g.expr(lhs.E, suvalue)
-	g.Add(".GetPut(t, ")
-	g.expr(lhs.M, suvalue)
-	g.Add(", ")
-	g.expr(y, suvalue)
-	g.Adds(", ", opfn[op], ", false)") // false means return new result
-	return suvalue
-}
-
-func (g *ggen) localVar(node ast.Expr) string {
-	if ident, ok := node.(*ast.Ident); ok && isLocal(ident.Name) {
-		return ident.Name
-	}
-	return ""
-}
-
-var opfn = map[tok.Token]string{
-	tok.Add:      "OpAdd(",
-	tok.Sub:      "OpSub(",
-	tok.Cat:      "OpCat(",
-	tok.BitAnd:   "OpBitAnd(",
-	tok.BitOr:    "OpBitOr(",
-	tok.BitXor:   "OpBitXor(",
-	tok.And:      " && ",
-	tok.Or:       " || ",
-	tok.Match:    "OpMatch(t, ",
-	tok.MatchNot: "!OpMatch(t, ",
-	tok.Mod:      "OpMod(",
-	tok.LShift:   "OpLShift(",
-	tok.RShift:   "OpRShift(",
-	tok.AddEq:    "OpAdd",
-	tok.SubEq:    "OpSub",
-	tok.CatEq:    "t.Cat",
-	tok.MulEq:    "OpMul",
-	tok.DivEq:    "OpDiv",
-	tok.ModEq:    "OpMod",
-	tok.LShiftEq: "OpLShift",
-	tok.RShiftEq: "OpRShift",
-	tok.BitAndEq: "OpBitAndEq",
-	tok.BitOrEq:  "OpBitOrEq",
-	tok.BitXorEq: "OpBitXorEq",
+		return g.opeq(node.Lhs, node.Rhs, node.Tok, false)
+	case tok.Match:
+		return g.match(node)
+	case tok.MatchNot:
+		return "OpNot(" + g.match(node) + ")"
+	case tok.Is, tok.Isnt, tok.Lt, tok.Lte, tok.Gt, tok.Gte,
+		tok.Mod, tok.LShift, tok.RShift:
+		return binFn[node.Tok] + "(" + g.expr(node.Lhs) + ", " +
+			g.expr(node.Rhs) + ")"
+	}
+	panic("gogen: unexpected binary " + node.Tok.String())
 }
 
-type string2 struct {
-	s, t string
+func (g *ggen) match(node *ast.Binary) string {
+	return "OpMatch(t, " + g.expr(node.Lhs) + ", " + g.expr(node.Rhs) + ")"
 }
 
-var cmpfn = map[tok.Token]string2{
-	tok.Is:   {".Equal(", ")"},
-	tok.Isnt: {".Equal(", ") != true"},
-	tok.Lt:   {".Compare(", ") < 0"},
-	tok.Lte:  {".Compare(", ") <= 0"},
-	tok.Gt:   {".Compare(", ") > 0"},
-	tok.Gte:  {".Compare(", ") >= 0"},
+var binFn = map[tok.Token]string{
+	tok.Is:     "OpIs",
+	tok.Isnt:   "OpIsnt",
+	tok.Lt:     "OpLt",
+	tok.Lte:    "OpLte",
+	tok.Gt:     "OpGt",
+	tok.Gte:    "OpGte",
+	tok.Mod:    "OpMod",
+	tok.LShift: "OpLeftShift",
+	tok.RShift: "OpRightShift",
+	tok.Add:    "OpAdd",
+	tok.BitOr:  "OpBitOr",
+	tok.BitAnd: "OpBitAnd",
+	tok.BitXor: "OpBitXor",
 }
 
-// lvalue caller must add closing parenthesis
-func (g *ggen) lvalue(node ast.Expr) {
-	switch node := node.(type) {
-	case *ast.Ident:
-		if isLocal(node.Name) {
-			g.Adds("(", node.Name)
-			if _, ok := g.locals[node.Name]; ok {
-				g.Add(" = ")
+func (g *ggen) nary(node *ast.Nary) string {
+	switch node.Tok {
+	case tok.And, tok.Or:
+		return "SuBool(" + g.cond(node) + ")"
+	case tok.Mul:
+		var top, bot []ast.Expr
+		for _, e := range node.Exprs {
+			if isUnary(e, tok.Div) {
+				bot = append(bot, e.(*ast.Unary).E)
 			} else {
-				g.Add(" := ")
-				g.locals[node.Name] = struct{}{}
+				top = append(top, e)
 			}
-			return
 		}
-	case *ast.Mem:
-		g.expr(node.E, suvalue)
-		g.Add(".Put(")
-		g.expr(node.M, suvalue)
-		g.Add(", ")
-		return
-	}
-	panic("unhandled lvalue: " + node.String())
-}
-
-func (g *ggen) nary(node *ast.Nary, want resultType) resultType {
-	if node.Tok == tok.And || node.Tok == tok.Or {
-		g.andorExpr(node, want)
-		return want
-	}
-	if node.Tok == tok.Mul {
-		g.muldivExpr(node)
-		return suvalue
-	}
-	// else Add, Sub, Cat, BitOr, BitAnd, BitXor
-	// left associative, so work backwards to generate operations
-	for i := len(node.Exprs) - 1; i > 0; i-- {
-		if isUnary(node.Exprs[i], tok.Sub) {
-			g.Add("OpSub(")
-		} else {
-			g.Add("OpAdd(")
+		s := g.chain("OpMul(", top)
+		if len(bot) > 0 {
+			s = "OpDiv(" + s + ", " + g.chain("OpMul(", bot) + ")"
 		}
+		return s
+	case tok.Cat:
+		if len(node.Exprs) >= 3 { // like CatN
+			list := make([]string, len(node.Exprs))
+			for i, e := range node.Exprs {
+				list[i] = g.expr(e)
+			}
+			return "OpCatList(t, " + strings.Join(list, ", ") + ")"
+		}
+		return g.chain("OpCat(t, ", node.Exprs)
 	}
-	// now work forwards to generate operands
-	g.expr(node.Exprs[0], suvalue)
+	// Add, BitOr, BitAnd, BitXor
+	s := g.expr(node.Exprs[0])
 	for _, e := range node.Exprs[1:] {
-		g.Add(", ")
-		if u, ok := e.(*ast.Unary); ok && u.Tok == tok.Sub {
-			e = u.E
+		if node.Tok == tok.Add && isUnary(e, tok.Sub) {
+			s = "OpSub(" + s + ", " + g.expr(e.(*ast.Unary).E) + ")"
+		} else {
+			s = binFn[node.Tok] + "(" + s + ", " + g.expr(e) + ")"
 		}
-		g.expr(e, suvalue)
-		g.Add(")")
 	}
-	return suvalue
+	return s
 }
 
-func (g *ggen) muldivExpr(node *ast.Nary) {
-	var top []ast.Expr
-	var bot []ast.Expr
-	for _, e := range node.Exprs {
-		if isUnary(e, tok.Div) {
-			bot = append(bot, e.(*ast.Unary).E)
-		} else {
-			top = append(top, e)
-		}
-	}
-	if len(bot) > 0 {
-		g.Add("OpDiv(")
+// chain handles left associative operations
+func (g *ggen) chain(fn string, exprs []ast.Expr) string {
+	s := g.expr(exprs[0])
+	for _, e := range exprs[1:] {
+		s = fn + s + ", " + g.expr(e) + ")"
 	}
-	g.mul(top)
-	if len(bot) > 0 {
-		g.Add(", ")
-		g.mul(bot)
-		g.Add(")")
+	return s
+}
+
+func (g *ggen) mem(node *ast.Mem) (ob, m string) {
+	if id, ok := node.E.(*ast.Ident); ok && id.Name == "super" {
+		unsupp("super")
 	}
+	return g.expr(node.E), g.expr(node.M)
 }
 
-func (g *ggen) mul(exprs []ast.Expr) {
-	// left associative, so work backwards to generate operations
-	for i := len(exprs) - 1; i > 0; i-- {
-		g.Add("OpMul(")
+// call returns Go code for a call, without checking the result
+func (g *ggen) call(node *ast.Call) string {
+	if id, ok := node.Fn.(*ast.Ident); ok && id.Name == "super" {
+		unsupp("super")
 	}
-	// now work forwards to generate operands
-	g.expr(exprs[0], suvalue)
-	for _, e := range exprs[1:] {
-		g.Add(", ")
-		g.expr(e, suvalue)
-		g.Add(")")
+	var fn string
+	mem, method := node.Fn.(*ast.Mem)
+	if method {
+		if c, ok := mem.M.(*ast.Constant); ok && c.Val == SuStr("New") {
+			panic("can't explicitly call New method")
+		}
+		ob, m := g.mem(mem)
+		fn = "OpCallMeth(t, " + ob + ", " + m + ", "
+	} else {
+		fn = "t.PushCall(" + g.expr(node.Fn) + ", nil, "
 	}
+	return fn + g.args(node.Args) + ")"
 }
 
-func (g *ggen) andorExpr(node *ast.Nary, want resultType) {
-	if want == suvalue {
-		g.Add("SuBool")
+// args returns the ArgSpec and the arguments
+func (g *ggen) args(args []ast.Arg) string {
+	if len(args) == 1 {
+		if args[0].Name == SuStr("@") {
+			return "&ArgSpecEach0, " + g.expr(args[0].E)
+		} else if args[0].Name == SuStr("@+1") {
+			return "&ArgSpecEach1, " + g.expr(args[0].E)
+		}
 	}
-	g.Add("(")
-	g.expr(node.Exprs[0], gobool)
-	for _, e := range node.Exprs[1:] {
-		g.Add(opfn[node.Tok])
-		g.expr(e, gobool)
-	}
-	g.Add(")")
-}
-
-func (g *ggen) trinary(node *ast.Trinary, want resultType) resultType {
-	if want == void {
-		g.Add("if ")
-		g.expr(node.Cond, gobool)
-		g.Add(" { ")
-		g.expr(node.T, void)
-		g.Add(" } else { ")
-		g.expr(node.F, void)
-		g.Add(" }")
-		return void
-	}
-	g.Add("func() { if ")
-	g.expr(node.Cond, gobool)
-	g.Add(" { return ")
-	g.expr(node.T, void)
-	g.Add(" } else { return ")
-	g.expr(node.F, void)
-	g.Add(" } }()")
-	return suvalue
+	var names []string
+	var sb strings.Builder
+	for _, arg := range args {
+		if arg.Name != nil {
+			names = append(names, g.constant(arg.Name))
+		}
+		sb.WriteString(", ")
+		sb.WriteString(g.expr(arg.E))
+	}
+	if names == nil && len(args) <= 4 {
+		return "&ArgSpec" + strconv.Itoa(len(args)) + sb.String()
+	}
+	as := g.pkgName("a")
+	g.init.WriteString("var " + as + " = &ArgSpec{Nargs: " +
+		strconv.Itoa(len(args)))
+	if names != nil {
+		g.init.WriteString(", Spec: []byte{")
+		for i := range names {
+			if i > 0 {
+				g.init.WriteString(", ")
+			}
+			g.init.WriteString(strconv.Itoa(i))
+		}
+		g.init.WriteString("}, Names: []Value{" + strings.Join(names, ", ") + "}")
+	}
+	g.init.WriteString("}\n")
+	return as + sb.String()
 }
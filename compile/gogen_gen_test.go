@@ -0,0 +1,101 @@
+// Code generated by TestGoGenRun. DO NOT EDIT.
+
+//go:build gogen
+
+package compile
+
+import (
+	tok "github.com/apmckinlay/gsuneido/compile/tokens"
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+var _ = tok.Eof
+
+var gogenRun = map[string]CompiledFn{
+	"Classify": func(t *Thread, this Value, args []Value) Value {
+		v_x := args[0]
+		switch _sw1_ := v_x; {
+		case _sw1_.Equal(Zero), _sw1_.Equal(EmptyStr):
+			return SuStr("empty")
+		case _sw1_.Equal(True), _sw1_.Equal(False):
+			return SuStr("bool")
+		default:
+		}
+		if OpLt(v_x, Zero) == True {
+			return SuStr("negative")
+		}
+		return func() Value {
+			if OpGt(v_x, IntVal(100)) == True {
+				return SuStr("big")
+			}
+			return SuStr("small")
+		}()
+	},
+	"Fib": func(t *Thread, this Value, args []Value) Value {
+		v_n := args[0]
+		var v_a Value
+		var v_b Value
+		var v_t Value
+		v_a = Zero
+		v_b = One
+		for OpGt(func() Value {
+			_r_ := v_n
+			v_n = OpSub(_r_, One)
+			return _r_
+		}(), Zero) == True {
+			t.CheckCancel()
+			v_t = OpAdd(OpLoad(v_a, "a"), OpLoad(v_b, "b"))
+			v_a = OpLoad(v_b, "b")
+			v_b = OpLoad(v_t, "t")
+		}
+		return OpLoad(v_a, "a")
+	},
+	"Join": func(t *Thread, this Value, args []Value) Value {
+		v_ob := args[0]
+		v_sep := args[1]
+		var v_s Value
+		var v_x Value
+		v_s = EmptyStr
+		for _it1_ := OpIter(v_ob); ; {
+			v_x = _it1_.Next()
+			if v_x == nil {
+				break
+			}
+			t.CheckCancel()
+			if OpIsnt(OpLoad(v_s, "s"), EmptyStr) == True {
+				v_s = t.Cat(OpLoad(v_s, "s"), v_sep)
+			}
+			v_s = t.Cat(OpLoad(v_s, "s"), OpLoad(v_x, "x"))
+		}
+		return OpLoad(v_s, "s").RangeTo(0, ToInt(IntVal(20)))
+	},
+	"Safe": func(t *Thread, this Value, args []Value) Value {
+		v_x := args[0]
+		var v_result Value
+		var v_e Value
+		OpTryCatch(t, func() {
+			if OpIs(v_x, Zero) == True {
+				panic(SuStr("zero"))
+			}
+			v_result = OpDiv(IntVal(100), v_x)
+		}, "zero", func(_e_ *SuExcept) {
+			v_e = _e_
+			v_result = OpCat(t, SuStr("caught "), OpLoad(v_e, "e"))
+		})
+		return OpLoad(v_result, "result")
+	},
+	"Sum": func(t *Thread, this Value, args []Value) Value {
+		v_n := args[0]
+		var v_sum Value
+		var v_i Value
+		v_sum = Zero
+		v_i = One
+		for ; OpLte(OpLoad(v_i, "i"), v_n) == True; func() {
+			v_i = OpAdd(OpLoad(v_i, "i"), One)
+		}() {
+			t.CheckCancel()
+			v_sum = OpAdd(OpLoad(v_sum, "sum"), OpLoad(v_i, "i"))
+		}
+		return OpLoad(v_sum, "sum")
+	},
+}
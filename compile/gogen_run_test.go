@@ -0,0 +1,153 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+//go:build gogen
+
+package compile
+
+import (
+	"fmt"
+	"go/format"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+// gogenRunSrcs are run as byte code and as generated Go (gogen_gen_test.go)
+var gogenRunSrcs = map[string]string{
+	"Sum": `function (n)
+		{
+		sum = 0
+		for (i = 1; i <= n; ++i)
+			sum += i
+		return sum
+		}`,
+	"Fib": `function (n)
+		{
+		a = 0
+		b = 1
+		while (n-- > 0)
+			{
+			t = a + b
+			a = b
+			b = t
+			}
+		return a
+		}`,
+	"Classify": `function (x)
+		{
+		switch (x)
+			{
+		case 0, '':
+			return "empty"
+		case true, false:
+			return "bool"
+		default:
+			}
+		if x < 0
+			return "negative"
+		return x > 100 ? "big" : "small"
+		}`,
+	"Join": `function (ob, sep = ', ')
+		{
+		s = ""
+		for x in ob
+			{
+			if s isnt ""
+				s $= sep
+			s $= x
+			}
+		return s[.. 20]
+		}`,
+	"Safe": `function (x)
+		{
+		try
+			{
+			if x is 0
+				throw "zero"
+			result = 100 / x
+			}
+		catch (e, "zero")
+			result = "caught " $ e
+		return result
+		}`,
+}
+
+var gogenRunArgs = map[string][][]Value{
+	"Sum":      {{Zero}, {IntVal(1)}, {IntVal(100)}},
+	"Fib":      {{Zero}, {IntVal(1)}, {IntVal(30)}},
+	"Classify": {{Zero}, {EmptyStr}, {True}, {IntVal(-5)}, {IntVal(5)}, {IntVal(500)}},
+	"Join": {{SuObjectOf(SuStr("a"), SuStr("b"), SuStr("c"))},
+		{SuObjectOf(One, IntVal(2)), SuStr("-")},
+		{SuObjectOf(SuStr("abcdefghij"), SuStr("klmnopqrst"), SuStr("z"))}},
+	"Safe": {{IntVal(4)}, {Zero}, {SuStr("x")}},
+}
+
+const gogenGenFile = "gogen_gen_test.go"
+
+// TestGoGenRun checks that the generated Go code gives the same results
+// as the byte code. If the generated code is out of date it is rewritten
+// and the test fails, so it needs to be run again.
+func TestGoGenRun(t *testing.T) {
+	src := gogenRunFile()
+	if old, _ := os.ReadFile(gogenGenFile); string(old) != src {
+		assert.T(t).This(os.WriteFile(gogenGenFile, []byte(src), 0644)).Is(nil)
+		t.Fatal(gogenGenFile + " was out of date, run the test again")
+	}
+	th := &Thread{}
+	call := func(fn Value, args []Value) (result Value, err any) {
+		defer func() { err = recover() }()
+		return th.Call(fn, args...), nil
+	}
+	for name, src := range gogenRunSrcs {
+		fn := NamedConstant("gogentest", name, src, nil).(*SuFunc)
+		gofn := NamedConstant("gogentest", name, src, nil).(*SuFunc)
+		RegisterCompiled("gogentest", name, SourceHash(src),
+			map[string]CompiledFn{"": gogenRun[name]})
+		Compiled("gogentest", name, src, "", gofn)
+		assert.T(t).Msg(name).That(gofn.IsCompiled())
+		for _, args := range gogenRunArgs[name] {
+			expected, experr := call(fn, args)
+			actual, err := call(gofn, args)
+			assert.T(t).Msg(name, args).This(actual).Is(expected)
+			assert.T(t).Msg(name, args).This(err).Is(experr)
+		}
+	}
+}
+
+func gogenRunFile() string {
+	names := make([]string, 0, len(gogenRunSrcs))
+	for name := range gogenRunSrcs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var decls, fns strings.Builder
+	for i, name := range names {
+		prefix := fmt.Sprint("gogentest_", i, "_")
+		def, err := GoGenLib(prefix, "gogentest", name, gogenRunSrcs[name])
+		if err != nil || len(def.Errs) > 0 || def.Fns[""] == "" {
+			panic(fmt.Sprint("gogen ", name, ": ", err, def.Errs))
+		}
+		decls.WriteString(def.Init)
+		fmt.Fprintf(&fns, "%q: %s,\n", name, def.Fns[""])
+	}
+	src := "// Code generated by TestGoGenRun. DO NOT EDIT.\n\n" +
+		"//go:build gogen\n\n" +
+		"package compile\n\n" +
+		"import (\n" +
+		"tok \"github.com/apmckinlay/gsuneido/compile/tokens\"\n" +
+		". \"github.com/apmckinlay/gsuneido/core\"\n" +
+		")\n\n" +
+		"var _ = tok.Eof\n\n" +
+		decls.String() + "\n" +
+		"var gogenRun = map[string]CompiledFn{\n" + fns.String() + "}\n"
+	b, err := format.Source([]byte(src))
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -6,14 +6,14 @@
 package compile
 
 import (
-	"encoding/base64"
 	"fmt"
+	"go/parser"
+	"go/token"
 	"strings"
 	"testing"
 
 	. "github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/util/assert"
-	"github.com/apmckinlay/gsuneido/util/hacks"
 	"github.com/apmckinlay/gsuneido/util/str"
 )
 
@@ -21,9 +21,10 @@ func ExampleGoGen_a() {
 	src := GoGen(`function (a, b) { a + b }`)
 	fmt.Println(src)
 	// output:
-	//
-	// func(a, b Value) Value {
-	// return OpAdd(a, b)
+	// func(t *Thread, this Value, args []Value) Value {
+	// v_a := args[0]
+	// v_b := args[1]
+	// return OpAdd(v_a, v_b)
 	// }
 }
 
@@ -40,49 +41,27 @@ func ExampleGoGen_b() {
 		}`)
 	fmt.Println(src)
 	// output:
-	//
-	// func(n Value) Value {
-	// (sum := Zero)
-	// for (n.Compare(Zero) > 0) {
-	// (sum = OpAdd(sum, n))
-	// (n = OpAdd(n, MinusOne))
+	// func(t *Thread, this Value, args []Value) Value {
+	// v_n := args[0]
+	// var v_sum Value
+	// v_sum = Zero
+	// for OpGt(v_n, Zero) == True {
+	// t.CheckCancel()
+	// v_sum = OpAdd(OpLoad(v_sum, "sum"), v_n)
+	// v_n = OpAdd(v_n, MinusOne)
 	// }
-	// return sum
+	// return OpLoad(v_sum, "sum")
 	// }
 }
 
-func ExampleGoGen_c() {
-	src := GoGen(`function ()
-		{
-		"hello"
-		}`)
-	fmt.Println(src)
-	fmt.Println("_c0_", unpack64(`BGhlbGxv`))
-	// output:
-	//
-	// var _c0_ = Unpack64(`BGhlbGxv`)
-	// func() Value {
-	// return _c0_
-	// }
-	// _c0_ "hello"
-}
-
 func TestPack64(t *testing.T) {
 	s := SuStr(strings.Repeat("hello world", 100))
-	var g ggen
+	g := ggen{a: &gogenAspects{}}
 	g.pack64(s)
 	b := g.init.String()
 	b = str.AfterFirst(b, "`")
 	b = str.BeforeLast(b, "`")
-	assert.T(t).This(unpack64(b)).Is(s)
-}
-
-func unpack64(s string) Value {
-	data, err := base64.StdEncoding.DecodeString(s)
-	if err != nil {
-		panic("Unpack64 bad data")
-	}
-	return Unpack(hacks.BStoS(data))
+	assert.T(t).This(Unpack64(b)).Is(s)
 }
 
 func TestGoGen(t *testing.T) {
@@ -91,9 +70,12 @@ func TestGoGen(t *testing.T) {
 		t.Helper()
 		src = "function(a,b,c,d) {\n" + src + "\n}"
 		code := GoGen(src)
-		code = str.AfterFirst(code, "func(a, b, c, d Value) Value {\n")
+		code = str.AfterFirst(code, "args []Value) Value {\n")
 		code = str.BeforeLast(code, "}")
 		code = str.BeforeLast(code, stop)
+		// ignore line breaks
+		code = strings.ReplaceAll(code, "\n", " ")
+		expected = strings.ReplaceAll(expected, "\n", " ")
 		assert.T(t).This(code).Like(expected)
 	}
 	test("123;;", "return nil")
@@ -101,111 +83,201 @@ func TestGoGen(t *testing.T) {
 	test("return", "return nil")
 	test("return false", "return False")
 	stop = "\nreturn nil"
-	test("a", "return a")
-	test("a is b", "return SuBool((a.Equal(b)))")
-	test("a isnt b;;", "(a.Equal(b) != true)")
-	test("a > b", "return SuBool((a.Compare(b) > 0))")
-	test("a <= b;;", "(a.Compare(b) <= 0)")
-	test("+a;;", "OpUnaryPlus(a)")
-	test("-a", "return OpUnaryMinus(a)")
-	test("not a", "return OpNot(a)")
-	test("a + b + c", "return OpAdd(OpAdd(a, b), c)")
-	test("a + b - c", "return OpSub(OpAdd(a, b), c)")
-	test("a - b + c", "return OpAdd(OpSub(a, b), c)")
-	test("a and b and c;;", "(OpBool(a) && OpBool(b) && OpBool(c))")
-	test("a or b or c", "return SuBool(OpBool(a) || OpBool(b) || OpBool(c))")
-	test("a / b * c / d;;", "OpDiv(OpMul(a, c), OpMul(b, d))")
-	test("1 / a", "return OpDiv(One, a)")
-	test("a =~ b", "return OpMatch(t, a, b)")
-	test("a << b;;", "OpLShift(a, b)")
+	test("a", "v_a := args[0] return v_a")
+	test("a is b", "v_a := args[0] v_b := args[1] return OpIs(v_a, v_b)")
+	test("a isnt b;;", "v_a := args[0] v_b := args[1] _ = OpIsnt(v_a, v_b)")
+	test("-a", "v_a := args[0] return OpUnaryMinus(v_a)")
+	test("a + b - c", `v_a := args[0] v_b := args[1] v_c := args[2]
+		return OpSub(OpAdd(v_a, v_b), v_c)`)
+	test("a / b * c / d", `v_a := args[0] v_b := args[1]
+		v_c := args[2] v_d := args[3]
+		return OpDiv(OpMul(v_a, v_c), OpMul(v_b, v_d))`)
+	test("a $ b $ 'x'", `v_a := args[0] v_b := args[1]
+		return OpCatList(t, v_a, v_b, SuStr("x"))`)
+	test("a or b", `v_a := args[0] v_b := args[1]
+		return SuBool((OpBool(v_a) || OpBool(v_b)))`)
+	test("i'a {b} {c}'", `v_b := args[1] v_c := args[2]
+		return OpCatList(t, SuStr("a "), v_b, SuStr(" "), v_c)`)
+	test("a =~ 'x'", `v_a := args[0] return OpMatch(t, v_a, SuStr("x"))`)
+	test("a << 1", `v_a := args[0] return OpLeftShift(v_a, One)`)
+	test("a[1..]", `v_a := args[0]
+		return v_a.RangeTo(ToIndex(One), ToInt(MaxInt))`)
+	test("a.b", `v_a := args[0] return OpGet(t, v_a, SuStr("b"))`)
 
-	test("a = 1;;", "(a = One)")
-	test("a = b", "return func(){ _r_ := b; (a = _r_); return _r_ }()")
-	test("a[b] = 0;;", "a.Put(b, Zero)")
-	test("a[b] = 0",
-		"return func(){ _r_ := Zero; a.Put(b, _r_); return _r_ }()")
-	test("a = a + b;;", "(a = OpAdd(a, b))")
-	test("a += b;;", "a = OpAdd(a, b)")
-	test("a = a + b",
-		"return func(){ _r_ := OpAdd(a, b); (a = _r_); return _r_ }()")
-	test("a += b",
-		"return func(){ _r_ := OpAdd(a, b); a = _r_; return _r_ }()")
-	test("a[b] += 1", "return a.GetPut(t, b, One, OpAdd, false)")
-	test("a[b] $= 'x'", "return a.GetPut(t, b, _c0_, t.Cat, false)")
-	test("++x;;", "x = OpAdd(x, One)")
-	test("--x", "return func(){ _r_ := OpSub(x, One); x = _r_; return _r_ }()")
-	test("x--;;", "x = OpSub(x, One)")
-	test("x++", "return func(){ _r_ := x; x = OpAdd(_r_, One); return _r_ }()")
-	test("a[b]++;;", "a.GetPut(t, b, One, OpAdd, false)")
-	test("a[b]--", "return a.GetPut(t, b, One, OpSub, true)")
-	test("--a[b];;", "a.GetPut(t, b, One, OpSub, false)")
-	test("++a[b]", "return a.GetPut(t, b, One, OpAdd, false)")
+	test("x = b;;", "v_b := args[1] var v_x Value _ = v_x v_x = v_b")
+	test("x = b", `v_b := args[1] var v_x Value _ = v_x
+		return func() Value {
+		v_x = v_b
+		return v_x
+		}()`)
+	test("a[b] = 0;;", `v_a := args[0] v_b := args[1]
+		v_a.Put(t, v_b, Zero)`)
+	test("a += b;;", `v_a := args[0] v_b := args[1]
+		v_a = OpAdd(v_a, v_b)`)
+	test("a[b] += 1", `v_a := args[0] v_b := args[1]
+		return v_a.GetPut(t, v_b, One, OpAdd, false)`)
+	test("a[b] $= 'x';;", `v_a := args[0] v_b := args[1]
+		v_a.GetPut(t, v_b, SuStr("x"), t.Cat, false)`)
+	test("a++", `v_a := args[0]
+		return func() Value {
+		_r_ := v_a
+		v_a = OpAdd(_r_, One)
+		return _r_
+		}()`)
+	test("a[b]--", `v_a := args[0] v_b := args[1]
+		return v_a.GetPut(t, v_b, One, OpSub, true)`)
+	test("a ? b : c;;", `v_a := args[0] v_b := args[1] v_c := args[2]
+		if OpBool(v_a) {
+		_ = v_b
+		} else {
+		_ = v_c
+		}`)
 
-	test("a ? b : c;;", "if OpBool(a) { b } else { c }")
-	test("a ? b : c",
-		"return func() { if OpBool(a) { return b } else { return c } }()")
+	test("F()", `return t.PushCall(Global.Get(t, _g1_), nil, &ArgSpec0)`)
+	test("F();;", `OpDiscard(t, t.PushCall(Global.Get(t, _g1_), nil, &ArgSpec0))`)
+	test("a.F(b)", `v_a := args[0] v_b := args[1]
+		return OpCallMeth(t, v_a, SuStr("F"), &ArgSpec1, v_b)`)
+	test("x = F(@a)", `v_a := args[0] var v_x Value _ = v_x
+		return func() Value {
+		v_x = OpNoNil(t, t.PushCall(Global.Get(t, _g1_), nil, &ArgSpecEach0, v_a))
+		return v_x
+		}()`)
+	test("F(a, b: 1)", `v_a := args[0]
+		return t.PushCall(Global.Get(t, _g1_), nil, _a2_, v_a, One)`)
 
-	test("function(a,b){ a+b }", `return func(a, b Value) Value {
-        return OpAdd(a, b)
+	test("forever { a; break }", `v_a := args[0]
+		_L1_:
+		for {
+		t.CheckCancel()
+		_ = v_a
+		break _L1_
 		}`)
-	test("forever { b; break }", `for {
-		b
-		break
+	test("while (a) { b; continue }", `v_a := args[0] v_b := args[1]
+		_L1_:
+		for OpBool(v_a) {
+		t.CheckCancel()
+		_ = v_b
+		continue _L1_
 		}`)
-	test("while (a) { b; continue }", `for OpBool(a) {
-		b
-		continue
+	test("do { b } while (a)", `v_a := args[0] v_b := args[1]
+		for _first_ := true; _first_ || (OpBool(v_a)); _first_ = false {
+		t.CheckCancel()
+		_ = v_b
 		}`)
-	test("do { b } while (a)", `for {
-		b
-		if !OpBool(a) { break }
+	test("if (not a) b else c", `v_a := args[0] v_b := args[1] v_c := args[2]
+		if !OpBool(v_a) {
+		_ = v_b
+		} else {
+		_ = v_c
 		}`)
-	test("if (a) b", `if OpBool(a) {
-        b
-        }`)
-	test("if (not a) b", `if !OpBool(a) {
-        b
-        }`)
-	test("if (a) b else c", `if OpBool(a) {
-        b
-        } else {
-		c
+	test("for (i = 0; i < a; ++i) b", `v_a := args[0] v_b := args[1]
+		var v_i Value
+		v_i = Zero
+		for ; OpLt(OpLoad(v_i, "i"), v_a) == True; func() {
+		v_i = OpAdd(OpLoad(v_i, "i"), One)
+		}() {
+		t.CheckCancel()
+		_ = v_b
+		}`)
+	stop = "-nothing-"
+	test("for (;;) { a }", `v_a := args[0]
+		for ; ; {
+		t.CheckCancel()
+		_ = v_a
+		}`)
+	stop = "\nreturn nil"
+	test("for x in a { b }", `v_a := args[0] v_b := args[1]
+		var v_x Value _ = v_x
+		for _it1_ := OpIter(v_a); ; {
+		v_x = _it1_.Next()
+		if v_x == nil {
+		break
+		}
+		t.CheckCancel()
+		_ = v_b
 		}`)
-	test("for (;;) a", `for ; ; {
-        a
-        }`)
-	test("for (a,b; c; a) d", `a
-		b
-		for ; OpBool(c); a {
-        d
-        }`)
-	test("for (; a; b,c) d", `for ; OpBool(a); func(){ b; c }() {
-        d
+	test("for i in ..a { b }", `v_a := args[0] v_b := args[1]
+		var v_i Value _ = v_i
+		for _i1_, _end2_ := OpAdd(Zero, Zero), v_a;
+			OpLt(_i1_, _end2_) == True; _i1_ = OpAdd1(_i1_) {
+		v_i = _i1_
+		t.CheckCancel()
+		_ = v_b
 		}`)
-	test("for x in a { b }", `var x Value
-        for _it_ := OpIter(a); ; {
-        x = _it_.Next()
-        if x == nil { break }
-        b
+	test("switch a { case 1: b; case 2, 3: }", `v_a := args[0] v_b := args[1]
+		switch _sw1_ := v_a; {
+		case _sw1_.Equal(One):
+		_ = v_b
+		case _sw1_.Equal(IntVal(2)), _sw1_.Equal(IntVal(3)):
+		default:
+		panic(SuStr("unhandled switch value"))
 		}`)
-	test("throw a", "panic(a)")
-	test("try a catch b", `func() {
-		defer func() {
-			if _e_ := recover(); _e_ != nil {
-				OpCatch(t, _e_, "")
-				b
+	test("throw a", "v_a := args[0] panic(v_a)")
+	test("try a catch (x, 'uninit') b", `v_a := args[0] v_b := args[1]
+		var v_x Value _ = v_x
+		OpTryCatch(t, func() {
+		_ = v_a
+		}, "uninit", func(_e_ *SuExcept) {
+		v_x = _e_
+		_ = v_b
+		})`)
+}
+
+func TestGoGenUnsupported(t *testing.T) {
+	test := func(src, expected string) {
+		t.Helper()
+		assert.T(t).This(func() { GoGen("function(a) {\n" + src + "\n}") }).
+			Panics(expected)
+	}
+	test("a.Each({ it })", "unsupported: blocks")
+	test("_x", "unsupported: dynamic variable")
+	test("super.F()", "unsupported: super")
+	test("x, y = a()", "unsupported: multiple assignment")
+	test("for m, v in a { }", "unsupported: for m, v in")
+	test("try return 1", "unsupported: return within try")
+	test("function () { }", "unsupported: nested function")
+}
+
+// TestGoGenParse checks that the generated code is valid Go syntax
+func TestGoGenParse(t *testing.T) {
+	test := func(src string) {
+		t.Helper()
+		def, err := GoGenLib("p_", "stdlib", "Test", src)
+		assert.T(t).This(err).Is(nil)
+		assert.T(t).This(len(def.Errs)).Is(0)
+		code := "package p\n" + def.Init
+		for _, fn := range def.Fns {
+			code += "var _ CompiledFn = " + fn + "\n"
+		}
+		_, err = parser.ParseFile(token.NewFileSet(), "", code, 0)
+		assert.T(t).Msg(code).This(err).Is(nil)
+	}
+	test(`function (x, y = 0, valid? = false)
+		{
+		n = Object(x, :y)
+		for (i = 0; i < 10; ++i)
+			{
+			if i in (3, 4) or i > y
+				continue
+			n.Add(i * 2 / 3, at: i)
 			}
-		}()
-		a
-		}()`)
-	test("try a catch(x, 'uninit') b", `func() {
-		defer func() {
-			if _e_ := recover(); _e_ != nil {
-				x = OpCatch(t, _e_, "uninit")
-				b
+		s = #20250101
+		return valid? ? n.Join(',') $ s : i
+		}`)
+	test(`function (x)
+		{
+		[a, name:] = x
+		match a
+			{
+		case 0: return name
+		case [b, c: 1]: return b
+		default: return false
 			}
-		}()
-		a
-		}()`)
-	test("'hello'", "return _c0_")
+		}`)
+	test(`class
+		{
+		Size: 3
+		Get(i) { return .list[i % .Size] }
+		getter_list() { return #(1, 2, 3) }
+		}`)
 }
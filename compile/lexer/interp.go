@@ -0,0 +1,101 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package lexer
+
+import (
+	"strings"
+
+	tok "github.com/apmckinlay/gsuneido/compile/tokens"
+)
+
+// Interpolated strings e.g. i"Total: {amount.Format('###.00')} for {name}"
+// are returned as the tokens for ("Total: " $ (amount.Format('###.00'))
+// $ " for " $ (name)) so the parser and code generators handle them
+// like any other concatenation.
+// Escapes are processed in the literal parts, {{ and }} are literal braces.
+// The expressions may contain strings (including the same quote).
+
+func (lxr *Lexer) interpString(start int) Item {
+	quote := lxr.read()
+	items := []Item{{Token: tok.LParen, Pos: int32(start), Text: "("}}
+	add := func(token tok.Token, pos int, text string) {
+		items = append(items, Item{Token: token, Pos: int32(pos), Text: text})
+	}
+	var buf strings.Builder
+	litPos := lxr.si
+	errmsg := ""
+	literal := func() {
+		if buf.Len() > 0 || len(items) == 1 {
+			if len(items) > 1 {
+				add(tok.Cat, litPos, "$")
+			}
+			add(tok.String, litPos, buf.String())
+			buf.Reset()
+		}
+	}
+	for {
+		c := lxr.read()
+		switch {
+		case c == eof:
+			return it(tok.Error, start, "missing closing quote")
+		case c == quote:
+			if errmsg != "" {
+				return it(tok.Error, start, errmsg)
+			}
+			literal()
+			add(tok.RParen, lxr.si-1, ")")
+			lxr.pending = items[1:]
+			return items[0]
+		case c == '{' && lxr.match('{'), c == '}' && lxr.match('}'):
+			buf.WriteByte(c)
+		case c == '{':
+			literal()
+			end := lxr.interpEnd()
+			if end < 0 {
+				lxr.si = len(lxr.src)
+				return it(tok.Error, start, "missing closing brace")
+			}
+			if strings.TrimSpace(lxr.src[lxr.si:end]) == "" {
+				errmsg = "empty interpolation"
+			}
+			add(tok.Cat, lxr.si-1, "$")
+			add(tok.LParen, lxr.si-1, "(")
+			sub := &Lexer{src: lxr.src[:end], si: lxr.si,
+				keyword: lxr.keyword, interp: true}
+			for item := sub.Next(); item.Token != tok.Eof; item = sub.Next() {
+				items = append(items, item)
+			}
+			add(tok.RParen, end, ")")
+			lxr.si = end + 1
+			litPos = lxr.si
+		default:
+			buf.WriteByte(lxr.doesc(c))
+		}
+	}
+}
+
+// interpEnd returns the position of the closing brace of an expression
+// or -1 if it is not found
+func (lxr *Lexer) interpEnd() int {
+	src := lxr.src
+	depth := 0
+	for i := lxr.si; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '"', '\'', '`':
+			for i++; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' && c != '`' {
+					i++
+				}
+			}
+		}
+	}
+	return -1
+}
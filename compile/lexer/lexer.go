@@ -20,8 +20,10 @@ type Lexer struct {
 	keyword func(s string) (tok.Token, string)
 	src     string
 	ahead   []Item
+	pending []Item // from interpolated strings
 	si      int
 	nlwhite bool
+	interp  bool
 }
 
 // NewLexer returns a new Lexer
@@ -29,8 +31,14 @@ func NewLexer(src string) *Lexer {
 	return &Lexer{src: src, keyword: keyword}
 }
 
+// NewCodeLexer returns a Lexer for compiling,
+// it expands interpolated strings (see interp.go)
+func NewCodeLexer(src string) *Lexer {
+	return &Lexer{src: src, keyword: keyword, interp: true}
+}
+
 func (lxr *Lexer) Dup() *Lexer {
-	return &Lexer{src: lxr.src, keyword: lxr.keyword}
+	return &Lexer{src: lxr.src, keyword: lxr.keyword, interp: lxr.interp}
 }
 
 func (lxr *Lexer) Source() string {
@@ -45,6 +53,7 @@ func (lxr *Lexer) Position() int {
 func (lxr *Lexer) SetPos(pos int) {
 	lxr.si = pos
 	lxr.ahead = nil
+	lxr.pending = nil
 }
 
 // Item is the return value from Lexer.Next
@@ -124,6 +133,11 @@ func (lxr *Lexer) Remainder() string {
 }
 
 func (lxr *Lexer) next() Item {
+	if len(lxr.pending) > 0 {
+		item := lxr.pending[0]
+		lxr.pending = lxr.pending[1:]
+		return item
+	}
 	start := lxr.si
 	c := lxr.read()
 	it := func(token tok.Token) Item {
@@ -490,6 +504,9 @@ func (lxr *Lexer) nonWhiteRemaining() bool {
 func (lxr *Lexer) identifier(start int) Item {
 	lxr.matchIdentTail()
 	val := lxr.src[start:lxr.si]
+	if val == "i" && lxr.interp && (lxr.peek() == '"' || lxr.peek() == '\'') {
+		return lxr.interpString(start)
+	}
 	token := tok.Identifier
 	if lxr.peek() != ':' || val == "default" || val == "true" || val == "false" {
 		if t, v := lxr.keyword(val); t != tok.Nil {
@@ -4,6 +4,7 @@
 package lexer
 
 import (
+	"strconv"
 	"testing"
 
 	tok "github.com/apmckinlay/gsuneido/compile/tokens"
@@ -135,6 +136,33 @@ func TestLexer(t *testing.T) {
 		tok.Comment, tok.Comment)
 }
 
+func TestInterp(t *testing.T) {
+	test := func(src string, expected string) {
+		t.Helper()
+		lxr := NewCodeLexer(src)
+		s := ""
+		for it := lxr.NextSkip(); it.Token != tok.Eof; it = lxr.NextSkip() {
+			if it.Token == tok.String {
+				s += strconv.Quote(it.Text) + " "
+			} else {
+				s += it.Text + " "
+			}
+		}
+		assert.T(t).This(s).Is(expected)
+	}
+	test(`i"abc"`, `( "abc" ) `)
+	test(`i'a\tb'`, `( "a\tb" ) `)
+	test(`i"{x}"`, `( "" $ ( x ) ) `)
+	test(`i"a {b.F('}')} c {{d}}"`,
+		`( "a " $ ( b . F ( "}" ) ) $ " c {d}" ) `)
+	test(`i"<{ i"{x}" }>" $ y`,
+		`( "<" $ ( ( "" $ ( x ) ) ) $ ">" ) $ y `)
+	test(`i "x"`, `i "x" `)
+	test(`i"{x"`, `missing closing brace `)
+	test(`i"{}"`, `empty interpolation `)
+	assert.T(t).This(NewLexer(`i"x"`).Next().Text).Is("i")
+}
+
 func TestAhead(t *testing.T) {
 	assert := assert.T(t).This
 	lxr := NewLexer("a \n= /**/ 1 ")
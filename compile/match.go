@@ -0,0 +1,315 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package compile
+
+import (
+	"strconv"
+
+	"github.com/apmckinlay/gsuneido/compile/ast"
+	tok "github.com/apmckinlay/gsuneido/compile/tokens"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/ascii"
+)
+
+// Destructuring assignment and the match statement.
+//
+//	[a, b, name:, other: c] = F()
+//
+//	match x
+//		{
+//	case 0, "": ...
+//	case [x, y]: ...
+//	case [kind: #circle, radius:]: ...
+//	default: ...
+//		}
+//
+// A pattern is a local variable to bind (_ to ignore), a literal value
+// (match only), or [...] with positional and then named members.
+// In a match, [...] requires an object (or record) with exactly that many
+// list members and the named members.
+// Both are lowered by the parser to ordinary statements and expressions
+// (using a temporary local) so codegen, check, and gogen
+// do not need to know about them.
+
+type pattern struct {
+	bind  string   // local variable, "" if none
+	value ast.Expr // literal value, nil if none
+	list  []*pattern
+	named []namedPattern
+	isOb  bool
+	pos   int32
+}
+
+type namedPattern struct {
+	name Value
+	pat  *pattern
+}
+
+// isDestructure checks for [...] =
+func (p *Parser) isDestructure() bool {
+	depth := 1
+	for i := 0; ; i++ {
+		switch p.Lxr.AheadSkip(i).Token {
+		case tok.LBracket, tok.LParen, tok.LCurly:
+			depth++
+		case tok.RBracket, tok.RParen, tok.RCurly:
+			if depth--; depth == 0 {
+				return p.Lxr.AheadSkip(i+1).Token == tok.Eq
+			}
+		case tok.Eof:
+			return false
+		}
+	}
+}
+
+// isMatch checks for match ... { case or default.
+// match is not a keyword so it can still be used as a variable name.
+func (p *Parser) isMatch() bool {
+	if p.Token != tok.Identifier || p.Text != "match" {
+		return false
+	}
+	depth := 0
+	for i := 0; ; i++ {
+		switch p.Lxr.AheadSkip(i).Token {
+		case tok.LCurly:
+			if depth == 0 {
+				next := p.Lxr.AheadSkip(i + 1).Token
+				return next == tok.Case || next == tok.Default
+			}
+			depth++
+		case tok.LBracket, tok.LParen:
+			depth++
+		case tok.RBracket, tok.RParen:
+			depth--
+		case tok.RCurly:
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case tok.Semicolon, tok.Return, tok.If, tok.Else, tok.Switch,
+			tok.Forever, tok.While, tok.Do, tok.For, tok.Throw, tok.Try,
+			tok.Catch, tok.Break, tok.Continue, tok.Case, tok.Default:
+			if depth == 0 {
+				return false
+			}
+		case tok.Eof:
+			return false
+		}
+	}
+}
+
+func (p *Parser) destructure() ast.Statement {
+	pos := p.Pos
+	pat := p.pattern(false)
+	p.Match(tok.Eq)
+	rhs := p.trailingExpr()
+	tmp := p.temp(pos)
+	stmts := []ast.Statement{p.assign(tmp.Name, rhs, pos)}
+	var tests []ast.Expr
+	p.lower(pat, tmp, &tests, &stmts)
+	return &ast.Compound{Body: stmts}
+}
+
+func (p *Parser) matchStmt() ast.Statement {
+	pos := p.Pos
+	p.Next() // match
+	expr := p.exprExpecting(true)
+	tmp := p.temp(pos)
+	p.Match(tok.LCurly)
+	type matchCase struct {
+		cond ast.Expr
+		body []ast.Statement
+		pos  int32
+	}
+	var cases []matchCase
+	for p.Token == tok.Case {
+		casePos := p.Pos
+		p.Next()
+		var alts []ast.Expr
+		var binds []ast.Statement
+		for {
+			pat := p.pattern(true)
+			var tests []ast.Expr
+			p.lower(pat, tmp, &tests, &binds)
+			alts = append(alts, p.and(tests))
+			if !p.MatchIf(tok.Comma) {
+				break
+			}
+		}
+		if len(alts) > 1 && len(binds) > 0 {
+			p.ErrorAt(casePos, "can't bind variables with multiple patterns")
+		}
+		p.Match(tok.Colon)
+		body := append(binds, p.switchBody()...)
+		cond := alts[0]
+		if len(alts) > 1 {
+			cond = p.Nary(tok.Or, alts)
+		}
+		cases = append(cases, matchCase{cond: cond, body: body, pos: casePos})
+	}
+	var def ast.Statement
+	defPos := p.Pos
+	if p.MatchIf(tok.Default) {
+		p.Match(tok.Colon)
+		def = &ast.Compound{Body: p.switchBody()}
+	} else {
+		def = &ast.Throw{E: p.Constant(SuStr("unhandled match value"))}
+	}
+	def.SetPos(defPos, p.EndPos)
+	end := p.EndPos
+	p.Match(tok.RCurly)
+	stmt := def
+	for i := len(cases) - 1; i >= 0; i-- {
+		c := cases[i]
+		then := &ast.Compound{Body: c.body}
+		then.SetPos(c.pos, end)
+		stmt = &ast.If{Cond: c.cond, Then: then, Else: stmt}
+		stmt.SetPos(c.pos, end)
+		end = c.pos
+	}
+	return &ast.Compound{Body: []ast.Statement{p.assign(tmp.Name, expr, pos), stmt}}
+}
+
+func (p *Parser) pattern(literals bool) *pattern {
+	pos := p.Pos
+	if p.MatchIf(tok.LBracket) {
+		pat := &pattern{isOb: true, pos: pos}
+		for p.Token != tok.RBracket {
+			pos := p.Pos
+			if name := p.patternName(); name != nil {
+				for _, np := range pat.named {
+					if name.Equal(np.name) {
+						p.ErrorAt(pos, "duplicate member name: "+ToStrOrString(name))
+					}
+				}
+				var sub *pattern
+				if p.Token == tok.Comma || p.Token == tok.RBracket {
+					s, ok := name.ToStr()
+					if !ok || !ascii.IsLower(s[0]) {
+						p.ErrorAt(pos, "expecting local variable name")
+					}
+					p.final[s] = disqualified
+					sub = &pattern{bind: s, pos: pos}
+				} else {
+					sub = p.pattern(literals)
+				}
+				pat.named = append(pat.named, namedPattern{name: name, pat: sub})
+			} else {
+				if len(pat.named) > 0 {
+					p.Error("un-named members must come before named members")
+				}
+				pat.list = append(pat.list, p.pattern(literals))
+			}
+			if !p.MatchIf(tok.Comma) {
+				break
+			}
+		}
+		p.Match(tok.RBracket)
+		return pat
+	}
+	if p.Token == tok.Identifier && p.Text != "this" &&
+		(p.Text == "unused" || ascii.IsLower(p.Text[0])) {
+		switch p.Lxr.AheadSkip(0).Token {
+		case tok.Comma, tok.RBracket, tok.Colon:
+			name := p.MatchIdent()
+			if name == "unused" {
+				return &pattern{pos: pos}
+			}
+			p.final[name] = disqualified
+			return &pattern{bind: name, pos: pos}
+		}
+	}
+	if !literals {
+		p.Error("expecting local variable name")
+	}
+	return &pattern{value: p.Expression(), pos: pos}
+}
+
+// patternName returns the member name if the next tokens are name:
+func (p *Parser) patternName() Value {
+	if p.Lxr.AheadSkip(0).Token != tok.Colon {
+		return nil
+	}
+	var name Value
+	switch {
+	case p.Token.IsIdent(), p.Token == tok.String, p.Token == tok.Symbol:
+		name = SuStr(p.Text)
+	default:
+		return nil
+	}
+	p.Next()
+	p.Match(tok.Colon)
+	return name
+}
+
+// lower appends the conditions for a pattern to tests
+// and the assignments for its bindings to binds.
+// v is the local variable or member expression being matched.
+func (p *Parser) lower(pat *pattern, v ast.Expr, tests *[]ast.Expr,
+	binds *[]ast.Statement) {
+	v = copyPath(v)
+	switch {
+	case pat.bind != "":
+		*binds = append(*binds, p.assign(pat.bind, v, pat.pos))
+	case pat.value != nil:
+		*tests = append(*tests, p.Binary(v, tok.Is, pat.value))
+	case pat.isOb:
+		*tests = append(*tests,
+			p.Call(&ast.Ident{Name: "Object?", Pos: pat.pos, Implicit: true},
+				[]ast.Arg{{E: v}}, pat.pos),
+			p.Binary(p.Call(p.mem(v, SuStr("Size")),
+				[]ast.Arg{{Name: SuStr("list"), E: p.Constant(True)}}, pat.pos),
+				tok.Is, p.Constant(IntVal(len(pat.list)))))
+		for _, np := range pat.named {
+			*tests = append(*tests, p.Call(p.mem(v, SuStr("Member?")),
+				[]ast.Arg{{E: p.Constant(np.name)}}, pat.pos))
+		}
+		for i, sub := range pat.list {
+			p.lower(sub, p.mem(v, IntVal(i)), tests, binds)
+		}
+		for _, np := range pat.named {
+			p.lower(np.pat, p.mem(v, np.name), tests, binds)
+		}
+	}
+}
+
+func (p *Parser) mem(v ast.Expr, m Value) ast.Expr {
+	return &ast.Mem{E: copyPath(v), M: p.Constant(m)}
+}
+
+// copyPath is used so nodes are not shared
+func copyPath(v ast.Expr) ast.Expr {
+	switch v := v.(type) {
+	case *ast.Ident:
+		return &ast.Ident{Name: v.Name, Pos: v.Pos}
+	case *ast.Mem:
+		return &ast.Mem{E: copyPath(v.E), M: v.M}
+	}
+	return v
+}
+
+func (p *Parser) and(tests []ast.Expr) ast.Expr {
+	switch len(tests) {
+	case 0:
+		return p.Constant(True)
+	case 1:
+		return tests[0]
+	}
+	return p.Nary(tok.And, tests)
+}
+
+// temp returns a new temporary local variable.
+// $ is not valid in identifiers so it can't conflict.
+func (p *Parser) temp(pos int32) *ast.Ident {
+	p.ntemp++
+	name := "tmp$" + strconv.Itoa(p.ntemp)
+	p.final[name] = disqualified
+	return &ast.Ident{Name: name, Pos: pos}
+}
+
+func (p *Parser) assign(id string, e ast.Expr, pos int32) ast.Statement {
+	stmt := &ast.ExprStmt{E: p.Binary(&ast.Ident{Name: id, Pos: pos}, tok.Eq, e)}
+	stmt.SetPos(pos, pos)
+	return stmt
+}
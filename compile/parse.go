@@ -15,17 +15,17 @@ import (
 )
 
 func NewParser(src string) *Parser {
-	return newParser(NewLexer(src), &cgAspects{})
+	return newParser(NewCodeLexer(src), &cgAspects{})
 }
 
 func CheckParser(src string, t *core.Thread) *Parser {
 	a := &cgckAspects{}
 	a.Check = check.New(t)
-	return newParser(NewLexer(src), a)
+	return newParser(NewCodeLexer(src), a)
 }
 
 func AstParser(src string) *Parser {
-	return newParser(NewLexer(src), &astAspects{})
+	return newParser(NewCodeLexer(src), &astAspects{})
 }
 
 func QueryParser(src string) *Parser {
@@ -125,6 +125,9 @@ type funcInfo struct {
 
 	// inTry is used to give an error for nested try
 	inTry bool
+
+	// ntemp is used to name temporaries for match and destructuring
+	ntemp int
 }
 
 // disqualified is a special value for final
@@ -260,6 +260,28 @@ func TestParseStatements(t *testing.T) {
 	}
 	test("x=123;;", "Binary(Eq x 123)\n{}")
 	test("a, b, c = f()", "MultiAssign(a b c Call(f))")
+	test("[a, _, name:] = f()", `{
+		Binary(Eq tmp$1 Call(f))
+		Binary(Eq a Mem(tmp$1 0))
+		Binary(Eq name Mem(tmp$1 'name'))
+		}`)
+
+	// match
+	test("match x { case 1, 2: a case [b]: c }", `{
+		Binary(Eq tmp$1 x)
+		If(In(tmp$1 [1 2]) a
+		else If(Nary(And Call(Object? tmp$1) Binary(Is Call(Mem(tmp$1 'Size') list:true) 1)) {
+		Binary(Eq b Mem(tmp$1 0))
+		c
+		}
+		else Throw('unhandled match value')))
+		}`)
+	test("match (x) { case [k: 0]: a default: b }", `{
+		Binary(Eq tmp$1 Unary(LParen x))
+		If(Nary(And Call(Object? tmp$1) Binary(Is Call(Mem(tmp$1 'Size') list:true) 0) Call(Mem(tmp$1 'Member?') 'k') Binary(Is Mem(tmp$1 'k') 0)) a
+		else b)
+		}`)
+	test("match(x)\nmatch = 1", "Call(match x)\nBinary(Eq match 1)")
 
 	// return
 	test("return", "Return()")
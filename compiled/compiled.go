@@ -0,0 +1,7 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+// Package compiled holds library code that has been compiled to Go
+// by cmd/gogen. The generated files register themselves with
+// core.RegisterCompiled from init functions.
+package compiled
@@ -0,0 +1,185 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/apmckinlay/gsuneido/util/hacks"
+)
+
+// Support for library code that has been compiled ahead of time to Go.
+// The generated code (see compile/gogen.go and cmd/gogen)
+// registers itself from init functions.
+// libload still compiles the source to byte code (for the ParamSpec,
+// and as a fallback) and then calls Compiled to attach the Go version
+// if the source has not changed since it was generated.
+
+// CompiledFn is the signature of generated functions and methods
+type CompiledFn = func(th *Thread, this Value, args []Value) Value
+
+type compiledRec struct {
+	hash string
+	fns  map[string]CompiledFn
+}
+
+// compiled is only written by init functions so it does not need locking
+var compiled = map[string]compiledRec{}
+
+// RegisterCompiled is called by generated code.
+// fns is keyed by method name, or "" for a function.
+func RegisterCompiled(lib, name, hash string, fns map[string]CompiledFn) {
+	compiled[lib+":"+name] = compiledRec{hash: hash, fns: fns}
+}
+
+// NCompiled returns the number of registered records
+func NCompiled() int {
+	return len(compiled)
+}
+
+// SourceHash is used to detect when the source has changed
+// since it was compiled to Go
+func SourceHash(src string) string {
+	hash := sha256.Sum256(hacks.Stobs(src))
+	return hex.EncodeToString(hash[:])
+}
+
+// Compiled records the library record version of a function or class
+// that was compiled to byte code from src
+// and attaches the compiled Go code (if any).
+// If the source hash does not match, the Go code is not used.
+func Compiled(lib, name, src, version string, v Value) Value {
+	hash := SourceHash(src)
+	switch x := v.(type) {
+	case *SuFunc:
+		x.Version = version
+	case *SuClass:
+		x.Version = version
+	}
+	cr, ok := compiled[lib+":"+name]
+	if !ok || cr.hash != hash {
+		return v
+	}
+	switch x := v.(type) {
+	case *SuFunc:
+		if fn := cr.fns[""]; fn != nil {
+			x.compiled = fn
+		}
+	case *SuClass:
+		for m, fn := range cr.fns {
+			if f, ok := x.Data[m].(*SuFunc); ok {
+				f.compiled = fn
+			}
+		}
+	}
+	return v
+}
+
+// IsCompiled returns whether a function is using compiled Go code
+func (f *SuFunc) IsCompiled() bool {
+	return f.compiled != nil
+}
+
+// helpers used by the generated code ------------------------------------------
+
+// Unpack64 is used for constants that don't have a simple Go literal
+func Unpack64(s string) Value {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic("Unpack64 bad data")
+	}
+	v := Unpack(hacks.BStoS(data))
+	if ro, ok := v.(interface{ SetReadOnly() }); ok {
+		ro.SetReadOnly()
+	}
+	return v
+}
+
+// OpLoad corresponds to op.Load
+func OpLoad(x Value, name string) Value {
+	if x == nil {
+		panic("uninitialized variable: " + name)
+	}
+	return x
+}
+
+// OpNoNil is used for call results that are used as values
+func OpNoNil(th *Thread, x Value) Value {
+	th.ReturnThrow = false
+	if x == nil {
+		panic("no return value")
+	}
+	return x
+}
+
+// OpDiscard is used for call results that are not used.
+// It handles return throw.
+func OpDiscard(th *Thread, x Value) {
+	if th.ReturnThrow {
+		th.ReturnThrow = false
+		if x != EmptyStr && x != True {
+			if s, ok := x.ToStr(); ok {
+				panic(s)
+			}
+			panic("return value not checked")
+		}
+	}
+}
+
+// OpGet corresponds to op.Get
+func OpGet(th *Thread, ob Value, m Value) Value {
+	val := ob.Get(th, m)
+	if val == nil {
+		if ss, ok := m.(SuStr); ok {
+			val = ob.Lookup(th, string(ss))
+			if val != nil {
+				val = NewSuMethod(ob, val)
+			}
+		}
+		if val == nil {
+			MemberNotFound(m)
+		}
+	}
+	return val
+}
+
+// OpCatList corresponds to op.CatN
+func OpCatList(th *Thread, values ...Value) Value {
+	return catValues(th, values)
+}
+
+// OpCallMeth corresponds to op.CallMeth (without super)
+func OpCallMeth(th *Thread, this Value, meth Value, as *ArgSpec,
+	args ...Value) Value {
+	if m, ok := meth.ToStr(); ok {
+		if f := this.Lookup(th, m); f != nil {
+			return th.PushCall(f, this, as, args...)
+		}
+	}
+	panic("method not found: " + ErrType(this) + "." + ToStrOrString(meth))
+}
+
+// OpTryCatch corresponds to op.Try and op.Catch.
+// It restores the thread state if try panics.
+func OpTryCatch(th *Thread, try func(), catchPat string,
+	catch func(e *SuExcept)) {
+	st := th.GetState()
+	var e any
+	func() {
+		defer func() {
+			e = recover()
+		}()
+		try()
+	}()
+	if e == nil {
+		return
+	}
+	if e == BlockReturn {
+		panic(e)
+	}
+	th.RestoreState(st)
+	catch(OpCatch(th, e, catchPat))
+}
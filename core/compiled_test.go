@@ -0,0 +1,76 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestCompiled(t *testing.T) {
+	assert := assert.T(t)
+	src := "function (x) { x + 1 }"
+	RegisterCompiled("testlib", "Inc", SourceHash(src), map[string]CompiledFn{
+		"": func(th *Thread, this Value, args []Value) Value {
+			return OpAdd(args[0], One)
+		}})
+	defer delete(compiled, "testlib:Inc")
+
+	f := &SuFunc{}
+	f.Nparams = 1
+	f.Names = []string{"x"}
+	f.Flags = []Flag{0}
+	assert.This(Compiled("testlib", "Inc", src+" ", "", f)).Is(f)
+	assert.That(!f.IsCompiled())
+	assert.This(Compiled("testlib", "Inc", src, "", f)).Is(f)
+	assert.That(f.IsCompiled())
+	th := &Thread{}
+	assert.This(th.Call(f, IntVal(122))).Is(IntVal(123))
+
+	// compiled functions are included in the call stack
+	var cs *SuObject
+	f.compiled = func(th *Thread, this Value, args []Value) Value {
+		cs = th.Callstack()
+		return nil
+	}
+	th.Call(f, IntVal(5))
+	assert.This(cs.Size()).Is(1)
+	call := cs.ListGet(0).(*SuObject)
+	assert.This(call.Get(th, SuStr("fn"))).Is(f)
+	assert.This(call.Get(th, SuStr("locals")).Get(th, SuStr("x"))).
+		Is(IntVal(5))
+	assert.This(th.fp).Is(0)
+
+	// coverage uses the byte code
+	f.cover = []uint16{0}
+	cs = nil
+	th.Call(f, IntVal(5))
+	assert.That(cs == nil)
+}
+
+func TestOpTryCatch(t *testing.T) {
+	th := &Thread{}
+	th.Push(True)
+	var caught Value
+	OpTryCatch(th, func() {
+		th.Push(False)
+		panic("oops")
+	}, "oo", func(e *SuExcept) {
+		caught = e
+	})
+	assert.T(t).This(caught).Is(SuStr("oops"))
+	assert.T(t).This(th.sp).Is(1)
+	e := assert.Catch(func() {
+		OpTryCatch(th, func() { panic("oops") }, "x", func(*SuExcept) {})
+	})
+	assert.T(t).This(e).Is(SuStr("oops"))
+}
+
+func TestCompiledVersion(t *testing.T) {
+	c := &SuClass{}
+	Compiled("testlib", "Foo", "class { }", "123", c)
+	assert.T(t).This(c.Version).Is("123")
+	assert.T(t).This(c.String()).Is("/* class v123 */")
+}
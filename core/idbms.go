@@ -62,6 +62,10 @@ type IDbms interface {
 	// Nonce returns a random string from the server
 	Nonce(*Thread) string
 
+	// Prepare returns a handle for a query with $name parameters
+	// to use with ITran.QueryPrepared
+	Prepare(query string) int
+
 	// Run is used by the old style string.ServerEval()
 	Run(th *Thread, code string) Value
 
@@ -116,10 +120,19 @@ type ITran interface {
 	// Query starts a query
 	Query(query string, sv *Sviews) IQuery
 
+	// QueryPrepared starts a query from a Prepare handle
+	// with the parameters bound to the given values.
+	// It panics with PreparedNotFound if the handle is no longer valid.
+	QueryPrepared(handle int, params map[string]Value, sv *Sviews) IQuery
+
 	// Action executes an insert, update, or delete
 	// and returns the number of records processed
 	Action(th *Thread, action string) int
 
+	// OutputMany outputs records to a table.
+	// It returns an error for each record, "" if it was output.
+	OutputMany(th *Thread, table string, recs []Record) []string
+
 	// Update modifies a record
 	Update(th *Thread, table string, off uint64, rec Record) uint64
 
@@ -216,6 +229,11 @@ type IQueryCursor interface {
 	Tree() Value
 }
 
+// PreparedNotFound is the error from QueryPrepared
+// if the prepared query has been discarded.
+// The query can be prepared again to get a new handle.
+const PreparedNotFound = "prepared query not found"
+
 // For timestamps with milliseconds up to TsThreshold,
 // a client is allowed to increment the milliseconds TsInitialBatch times
 // before requesting another timestamp.
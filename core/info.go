@@ -45,6 +45,21 @@ func InfoStr(name string) Value {
 	panic("unknown info type")
 }
 
+// InfoNum returns the value of a numeric info, or false if not numeric
+func InfoNum(name string) (float64, bool) {
+	switch x := infos[name].(type) {
+	case *int:
+		return float64(*x), true
+	case *atomic.Int64:
+		return float64(x.Load()), true
+	case *atomic.Int32:
+		return float64(x.Load()), true
+	case func() int:
+		return float64(x()), true
+	}
+	return 0, false
+}
+
 func InfoList() []string {
 	return maps.Keys(infos)
 }
@@ -38,11 +38,32 @@ func (th *Thread) invoke(fn *SuFunc, this Value) Value {
 	return th.run()
 }
 
+// invokeCompiled calls the Go version of a function (see Compiled).
+// It pushes a frame, like run, so call stacks include the function.
+func (th *Thread) invokeCompiled(fn *SuFunc, this Value, args []Value) Value {
+	th.CheckCancel()
+	if th.fp >= len(th.frames) {
+		panic("function call overflow")
+	}
+	th.frames[th.fp] = Frame{fn: fn, this: this, locals: locals{v: args}}
+	th.fp++
+	if th.fp > th.fpMax {
+		th.fpMax = th.fp
+	}
+	if th.profile.enabled {
+		th.profile.calls[fn]++
+	}
+	result := fn.compiled(th, this, args)
+	th.fp--
+	return result
+}
+
 // run is needed in addition to interp
 // because we can only recover panic on the way out of a function
 // so if the exception is caught we have to re-enter interp
 // Called by Thread.invoke (above) and SuClosure.Call
 func (th *Thread) run() Value {
+	th.CheckCancel()
 	fr := &th.frames[th.fp]
 	fr.ip = 0
 	th.fp++
@@ -117,7 +138,11 @@ func (th *Thread) interp() (ret Value) {
 		return int(uint16(code[fr.ip-2])<<8 + uint16(code[fr.ip-1]))
 	}
 	jump := func() {
-		fr.ip += fetchInt16()
+		j := fetchInt16()
+		fr.ip += j
+		if j < 0 { // loop
+			th.CheckCancel()
+		}
 	}
 	pushResult := func(result Value) {
 		switch oc {
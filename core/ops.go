@@ -239,7 +239,13 @@ func catToStr(th *Thread, v Value) string {
 }
 
 func OpCatN(th *Thread, count int) Value {
-	values := th.stack[th.sp-count : th.sp]
+	result := catValues(th, th.stack[th.sp-count:th.sp])
+	th.sp -= count // pop
+	return result
+}
+
+// catValues concatenates values, it modifies the slice
+func catValues(th *Thread, values []Value) Value {
 	totalLen := 0
 	var firstExcept *SuExcept
 	for i, v := range values {
@@ -261,7 +267,6 @@ func OpCatN(th *Thread, count int) Value {
 	for _, v := range values {
 		pos += copy(result[pos:], string(v.(SuStr)))
 	}
-	th.sp -= count // pop
 	resultStr := SuStr(hacks.BStoS(result))
 	if firstExcept != nil {
 		return &SuExcept{SuStr: resultStr, Callstack: firstExcept.Callstack}
@@ -410,7 +415,7 @@ func logStringError(th *Thread, e string) {
 }
 
 func LogUncaught(th *Thread, where string, e any) {
-	log.Println("ERROR:", "uncaught in", where+":", e)
+	th.Log(fmt.Sprint("ERROR: uncaught in ", where, ": ", e))
 	if isRuntimeError(e) {
 		dbg.PrintStack()
 	}
@@ -29,6 +29,10 @@ type ParamSpec struct {
 	// See also: Name(value) builtin function
 	Name string
 
+	// Version identifies the library record it was compiled from.
+	// See also: Name(value, version:) builtin function
+	Version string
+
 	// Values contains any literals in the function
 	// starting with parameter defaults
 	Values []Value
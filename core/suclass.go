@@ -21,6 +21,7 @@ type SuClass struct {
 	parentsCache atomic.Value // used by SuInstance getParents
 	Lib          string
 	Name         string
+	Version      string // see ParamSpec Version
 	MemBase
 	Base Gnum
 }
@@ -47,7 +48,7 @@ func (c *SuClass) String() string {
 	if c.Base != 0 {
 		s += " : " + Global.Name(c.Base)
 	}
-	s += " */"
+	s += str.Opt(" v", c.Version) + " */"
 	return s
 }
 
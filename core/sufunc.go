@@ -25,6 +25,9 @@ type SuFunc struct {
 	// ArgSpecs used by calls in the code
 	ArgSpecs []ArgSpec
 
+	// compiled is set (by Compiled) if there is a Go version of the code
+	compiled CompiledFn
+
 	// cover is used for coverage tracking. nil means no tracking.
 	// If len(cover) < len(Code) then bool coverage else counts.
 	cover []uint16
@@ -57,6 +60,9 @@ func (f *SuFunc) Call(th *Thread, this Value, as *ArgSpec) Value {
 			this.Put(th, SuStr(name), args[i])
 		}
 	}
+	if f.compiled != nil && f.cover == nil {
+		return th.invokeCompiled(f, this, args)
+	}
 	return th.invoke(f, this)
 }
 
@@ -90,7 +96,7 @@ func (f *SuFunc) String() string {
 	} else {
 		s += "function"
 	}
-	s += " */"
+	s += str.Opt(" v", f.Version) + " */"
 	return s
 }
 
@@ -117,6 +117,32 @@ func (st *SuTran) Query(th *Thread, query string) *SuQuery {
 	return NewSuQuery(th, st, query, iquery)
 }
 
+// QueryPrepared runs a prepared query.
+// If the handle is no longer valid, the query is prepared again.
+func (st *SuTran) QueryPrepared(th *Thread, handle int, query string,
+	params map[string]Value) *SuQuery {
+	st.ckActive()
+	iquery := st.queryPrepared(handle, params)
+	if iquery == nil {
+		handle = th.Dbms().Prepare(query)
+		iquery = st.itran.QueryPrepared(handle, params, nil)
+	}
+	return NewSuQuery(th, st, query, iquery)
+}
+
+// queryPrepared returns nil if the prepared query was not found
+func (st *SuTran) queryPrepared(handle int,
+	params map[string]Value) (iquery IQuery) {
+	defer func() {
+		if e := recover(); e != nil {
+			if s, ok := e.(string); !ok || s != PreparedNotFound {
+				panic(e)
+			}
+		}
+	}()
+	return st.itran.QueryPrepared(handle, params, nil)
+}
+
 func (st *SuTran) ReadCount() int {
 	st.ckActive()
 	return st.itran.ReadCount()
@@ -127,6 +153,20 @@ func (st *SuTran) Action(th *Thread, action string) int {
 	return st.itran.Action(th, action)
 }
 
+// OutputMany outputs a list of records to a table
+// and returns an error for each record, "" if it was output
+func (st *SuTran) OutputMany(th *Thread, table string, obs []Container) []string {
+	st.ckActive()
+	q := st.itran.Query(table, nil)
+	hdr := q.Header()
+	q.Close()
+	recs := make([]Record, len(obs))
+	for i, ob := range obs {
+		recs[i] = ob.ToRecord(th, hdr)
+	}
+	return st.itran.OutputMany(th, table, recs)
+}
+
 func (st *SuTran) Rollback() {
 	switch st.status {
 	case stAborted:
@@ -43,3 +43,13 @@ func (sv *Sviews) DropSview(name string) bool {
 	}
 	return false
 }
+
+// Len returns the number of session views
+func (sv *Sviews) Len() int {
+	if sv == nil {
+		return 0
+	}
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+	return len(sv.defs)
+}
@@ -20,6 +20,8 @@ import (
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
 	"github.com/apmckinlay/gsuneido/util/generic/cache"
+	"github.com/apmckinlay/gsuneido/util/jsonlog"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/regex"
 	"github.com/apmckinlay/gsuneido/util/str"
 	"github.com/apmckinlay/gsuneido/util/tr"
@@ -95,6 +97,9 @@ type thread1 struct {
 
 	// ReturnMulti is used by op.ReturnMulti and op.PushReturn
 	ReturnMulti []Value
+
+	// span is the current trace span (see StartSpan)
+	span otel.Context
 }
 
 // thread2 is the non-reset-able part of Thread
@@ -116,6 +121,11 @@ type thread2 struct {
 
 	// Name is the name of the thread (default is Thread-#)
 	Name string
+
+	// cancel is closed by Cancel, it is nil for internal threads
+	cancel     chan struct{}
+	cancelled  atomic.Bool
+	cancelOnce sync.Once
 }
 
 var threadNum atomic.Int32
@@ -125,12 +135,14 @@ var threadNum atomic.Int32
 // Internal threads can just use a zero Thread.
 func NewThread(parent *Thread) *Thread {
 	th := setup(&Thread{})
+	th.cancel = make(chan struct{})
 	if parent != nil {
 		if suneido := parent.Suneido.Load(); suneido != nil {
 			suneido.SetConcurrent()
 			th.Suneido.Store(suneido)
 		}
 		th.sv = parent.sv
+		th.span = parent.span
 	}
 	return th
 }
@@ -170,6 +182,16 @@ func (th *Thread) SetSession(s string) {
 	th.session.Store(s)
 }
 
+// Log writes a message to the log.
+// With structured logging it includes the thread's session and name.
+func (th *Thread) Log(msg string) {
+	if jsonlog.On() {
+		jsonlog.Println(msg, th.Session(), th.Name)
+	} else {
+		log.Println(msg)
+	}
+}
+
 func (th *Thread) SetSviews(sv *Sviews) {
 	th.sv = sv
 }
@@ -309,6 +331,9 @@ var DbmsAuth = false
 func (th *Thread) Dbms() IDbms {
 	if th.dbms == nil {
 		th.dbms = GetDbms()
+		if sl, ok := th.dbms.(spanLinker); ok {
+			sl.SetSpanSource(th.SpanContext)
+		}
 		if s := th.session.Load(); s != "" {
 			// session id was set before connecting
 			th.dbms.SessionId(th, s)
@@ -317,6 +342,42 @@ func (th *Thread) Dbms() IDbms {
 	return th.dbms.Unwrap()
 }
 
+// spanLinker is implemented by client dbms sessions
+// so their requests can be children of the thread's current span
+type spanLinker interface {
+	SetSpanSource(fn func() otel.Context)
+}
+
+// StartSpan starts a trace span as a child of the thread's current span
+// and makes it the current span.
+// It returns nil (which is safe to use) if tracing is not enabled.
+func (th *Thread) StartSpan(name string, kind otel.Kind) *otel.Span {
+	sp := otel.Start(th.span, name, kind)
+	if sp != nil {
+		th.span = sp.Context
+	}
+	return sp
+}
+
+// EndSpan ends a span from StartSpan and restores the previous current span
+func (th *Thread) EndSpan(sp *otel.Span) {
+	if sp != nil {
+		th.span = sp.Parent()
+		sp.End()
+	}
+}
+
+// SpanContext returns the thread's current trace span context
+func (th *Thread) SpanContext() otel.Context {
+	return th.span
+}
+
+// SetSpanContext sets the thread's current trace span context
+// e.g. from a request
+func (th *Thread) SetSpanContext(c otel.Context) {
+	th.span = c
+}
+
 // Close closes the thread's dbms connection (if it has one)
 func (th *Thread) Close() {
 	if th.dbms != nil && options.Action == "client" {
@@ -325,13 +386,47 @@ func (th *Thread) Close() {
 	}
 }
 
+// Cancel requests the thread to stop.
+// It is delivered as a "cancelled" exception at the next safe point
+// (function call or loop) and wakes up blocking waits that use Cancelled.
+// It may be called from other threads.
+func (th *Thread) Cancel() {
+	th.cancelOnce.Do(func() {
+		th.cancelled.Store(true)
+		if th.cancel != nil {
+			close(th.cancel)
+		}
+	})
+}
+
+// Cancelled returns a channel that is closed when the thread is cancelled
+func (th *Thread) Cancelled() <-chan struct{} {
+	return th.cancel
+}
+
+// IsCancelled returns whether Cancel has been called
+func (th *Thread) IsCancelled() bool {
+	return th.cancelled.Load()
+}
+
+// CheckCancel throws "cancelled" if the thread has been cancelled
+func (th *Thread) CheckCancel() {
+	if th.cancelled.Load() {
+		panic("cancelled")
+	}
+}
+
 func (th *Thread) Cat(x, y Value) Value {
 	return OpCat(th, x, y)
 }
 
 func (th *Thread) SessionId(id string) string {
 	if id != "" && th == MainThread {
-		log.SetPrefix(id + " ")
+		if jsonlog.On() {
+			jsonlog.SetSession(id)
+		} else {
+			log.SetPrefix(id + " ")
+		}
 	}
 	if th.dbms == nil {
 		// don't create a connection just to get/set the session id
@@ -6,12 +6,14 @@ package trace
 import (
 	"fmt"
 	"log"
+	"log/slog"
 	"math"
 	"os"
 	"strings"
 	"sync"
 
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
+	"github.com/apmckinlay/gsuneido/util/jsonlog"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 )
@@ -92,8 +94,8 @@ func (w what) Println(first any, rest ...any) {
 		for i := range rest {
 			format(&rest[i])
 		}
-		s := w.String() + fmt.Sprint(first) + " " + fmt.Sprintln(rest...)
-		Print(s)
+		s := fmt.Sprint(first) + " " + fmt.Sprintln(rest...)
+		w.print(s)
 	}
 }
 
@@ -105,12 +107,16 @@ func Println(args ...any) {
 }
 
 func Print(s string) {
+	what(0).print(s)
+}
+
+func (w what) print(s string) {
 	c := cur.Load()
 	if c&LogFile != 0 || c&(LogFile|Console) == 0 {
-		logPrint(s)
+		w.logPrint(s)
 	}
 	if c&Console != 0 || c&(LogFile|Console) == 0 {
-		consolePrint(s)
+		consolePrint(w.String() + s)
 	}
 }
 
@@ -160,7 +166,16 @@ func (w what) On() bool {
 var traceLog *os.File
 var traceLogOnce sync.Once
 
-func logPrint(s string) {
+func (w what) logPrint(s string) {
+	if jsonlog.On() {
+		source := "trace"
+		if cat := strings.TrimSpace(w.String()); cat != "" {
+			source += "." + strings.ToLower(cat)
+		}
+		jsonlog.Log(slog.LevelDebug, strings.TrimSuffix(s, "\n"),
+			"source", source)
+		return
+	}
 	traceLogOnce.Do(func() {
 		var err error
 		traceLog, err = os.OpenFile("trace.log",
@@ -170,6 +185,6 @@ func logPrint(s string) {
 		}
 	})
 	if traceLog != nil {
-		traceLog.WriteString(s)
+		traceLog.WriteString(w.String() + s)
 	}
 }
@@ -8,10 +8,12 @@ import (
 	"math"
 	"math/rand/v2"
 	"strconv"
+	"strings"
 	"sync/atomic"
 
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
+	"github.com/apmckinlay/gsuneido/util/metric"
 	"github.com/apmckinlay/gsuneido/util/ordset"
 	"github.com/apmckinlay/gsuneido/util/ranges"
 )
@@ -543,6 +545,7 @@ func (ck *Check) abort(tn int, reason string) bool {
 	if reason == "" {
 		reason = "abort"
 	}
+	tranAborts.Inc(abortKind(reason))
 	t.failure.Store(reason)
 	ck.removeByTable(t)
 	delete(ck.actvTran, tn)
@@ -554,6 +557,35 @@ func (ck *Check) abort(tn int, reason string) bool {
 	return true
 }
 
+var tranCommits metric.Counter
+var _ = metric.Register("suneido_tran_commits",
+	"Committed update transactions", &tranCommits)
+
+var tranAborts = metric.NewCounterVec("reason")
+var _ = metric.Register("suneido_tran_aborts",
+	"Aborted update transactions by reason", tranAborts)
+
+// abortKind categorizes abort reasons (which may include table names)
+func abortKind(reason string) string {
+	switch {
+	case reason == "abort" || reason == "aborted":
+		return "rollback"
+	case strings.Contains(reason, "conflicted with"):
+		return "conflict"
+	case strings.Contains(reason, "exclusive"):
+		return "exclusive"
+	case strings.HasPrefix(reason, "too many reads"):
+		return "too_many_reads"
+	case strings.HasPrefix(reason, "too many writes"):
+		return "too_many_writes"
+	case reason == "transaction exceeded max age":
+		return "max_age"
+	case reason == "database is locked":
+		return "locked"
+	}
+	return "other"
+}
+
 // Commit finishes a transaction.
 // It returns false if the transaction is not found (e.g. already aborted).
 // No additional checking required since actions have already been checked.
@@ -579,6 +611,7 @@ func (ck *Check) commit(ut *UpdateTran) []string {
 	}
 	// move transaction from active to committed
 	delete(ck.actvTran, tn)
+	tranCommits.Inc()
 	if ut.ct.hasUpdates {
 		assert.That(ut.ct.readConflict == "")
 		ck.cmtdTran[tn] = t
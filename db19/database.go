@@ -37,6 +37,7 @@ type Database struct {
 
 	ck Checker
 	triggers
+	libWatch
 	Store *stor.Stor
 
 	// state is the central immutable state of the database.
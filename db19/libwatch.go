@@ -0,0 +1,58 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package db19
+
+import (
+	"slices"
+	"strings"
+
+	. "github.com/apmckinlay/gsuneido/core"
+)
+
+// libWatch reports the names of library records
+// changed by committed update transactions
+// so the affected globals can be unloaded.
+type libWatch struct {
+	tables  func() []string
+	changed func(names []string)
+}
+
+// WatchLibraries sets the function that returns the library tables
+// and the function to call (after commit) with the changed record names.
+// It should be called once, before any update transactions.
+func (db *Database) WatchLibraries(tables func() []string,
+	changed func(names []string)) {
+	db.libWatch = libWatch{tables: tables, changed: changed}
+}
+
+// libChange records the names from the old and new records
+// if the table is a library
+func (t *UpdateTran) libChange(table string, oldrec, newrec Record) {
+	lw := &t.db.libWatch
+	if lw.tables == nil || !slices.Contains(lw.tables(), table) {
+		return
+	}
+	fld := slices.Index(t.getSchema(table).Columns, "name")
+	if fld < 0 {
+		return
+	}
+	for _, rec := range []Record{oldrec, newrec} {
+		if rec == "" {
+			continue
+		}
+		name := rec.GetStr(fld)
+		if i := strings.Index(name, "__"); i > 0 {
+			name = name[:i] // library tag
+		}
+		if name != "" && !slices.Contains(t.libNames, name) {
+			t.libNames = append(t.libNames, name)
+		}
+	}
+}
+
+func (t *UpdateTran) libChanged() {
+	if len(t.libNames) > 0 {
+		t.db.libWatch.changed(t.libNames)
+	}
+}
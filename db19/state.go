@@ -15,6 +15,7 @@ import (
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/cksum"
 	"github.com/apmckinlay/gsuneido/util/generic/cache"
+	"github.com/apmckinlay/gsuneido/util/metric"
 )
 
 type DbState struct {
@@ -120,6 +121,8 @@ func (db *Database) persist(exec execPersist, flush bool) *DbState {
 		return nil
 	}
 	// fmt.Println("persist")
+	t := time.Now()
+	defer func() { persistSeconds.Observe(time.Since(t).Seconds()) }()
 	var newState *DbState
 	db.GetState().Meta.Persist(exec.Submit) // outside UpdateState
 	updates := exec.Results()
@@ -140,6 +143,10 @@ func (db *Database) persist(exec execPersist, flush bool) *DbState {
 	return newState
 }
 
+var persistSeconds = metric.NewHistogram(metric.LatencyBounds...)
+var _ = metric.Register("suneido_persist_seconds",
+	"Duration of database persists", persistSeconds)
+
 // PersistSync is for tests
 func (db *Database) PersistSync() {
 	db.GetState().Meta.ResetClock() // prevent flattening
@@ -4,12 +4,14 @@
 package tools
 
 import (
+	"encoding/csv"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/apmckinlay/gsuneido/core"
 	. "github.com/apmckinlay/gsuneido/db19"
 	"github.com/apmckinlay/gsuneido/db19/stor"
 	"github.com/apmckinlay/gsuneido/dbms/query"
@@ -100,3 +102,32 @@ func TestLoadFkey(*testing.T) {
 	_, err = LoadDbTable("tmp3", "tmp3.su", "", "", db)
 	ck(err)
 }
+
+func TestLoadCsv(t *testing.T) {
+	db := CreateDb(stor.HeapStor(8192))
+	query.DoAdmin(db, "create tmp (a, b, c) key(a) index(b)", nil)
+	cr := csv.NewReader(strings.NewReader("b,a\nx,1\ny,2\nz,3\n"))
+	n, err := LoadCsv(db, "tmp", cr)
+	ck(err)
+	assert.T(t).This(n).Is(3)
+	assert.T(t).This(db.GetState().Meta.GetRoInfo("tmp").Nrows).Is(3)
+	ck(db.Check())
+
+	cr = csv.NewReader(strings.NewReader(
+		"a,b\n12345678901234567890,true\n007,1.50\n"))
+	_, err = LoadCsv(db, "tmp", cr)
+	ck(err)
+	rt := db.NewReadTran()
+	get := func(a string) core.Value {
+		return rt.Lookup("tmp", 0, core.Pack(core.SuStr(a))).GetVal(1)
+	}
+	assert.T(t).This(get("12345678901234567890")).Is(core.SuStr("true"))
+	assert.T(t).This(get("007")).Is(core.SuStr("1.50"))
+
+	cr = csv.NewReader(strings.NewReader("a,d\n1,2\n"))
+	_, err = LoadCsv(db, "tmp", cr)
+	assert.T(t).This(err.Error()).Is("error loading tmp: nonexistent column: d")
+	cr = csv.NewReader(strings.NewReader("a\n1\n1\n"))
+	_, err = LoadCsv(db, "tmp", cr)
+	assert.That(strings.Contains(err.Error(), "duplicate"))
+}
@@ -0,0 +1,88 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package tools
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/apmckinlay/gsuneido/core"
+	. "github.com/apmckinlay/gsuneido/db19"
+	"github.com/apmckinlay/gsuneido/util/cksum"
+	"github.com/apmckinlay/gsuneido/util/sortlist"
+)
+
+// LoadCsv replaces the contents of an existing table with CSV rows.
+// The first row must be column names (a subset of the table's columns).
+// Values are loaded as strings, the same as CsvReader,
+// so values like account numbers and zip codes are not altered.
+//
+// Like LoadDbTable, it builds the indexes directly rather than
+// going through transactions. It is not transactional.
+// The table is exclusive while loading, so update transactions
+// that use it will fail, and concurrent readers see the old contents.
+// If there is an error, the table is left unchanged,
+// but the space already used by the new records is not reclaimed
+// until the database is compacted.
+func LoadCsv(db *Database, table string, cr *csv.Reader) (n int, err error) {
+	if db.IsCorrupted() {
+		return 0, fmt.Errorf("load not allowed when database is locked")
+	}
+	db.AddExclusive(table)
+	defer func() {
+		db.EndExclusive(table)
+		if e := recover(); e != nil {
+			err = fmt.Errorf("error loading %s: %v", table, e)
+		}
+	}()
+	sc := db.GetState().Meta.GetRoSchema(table)
+	if sc == nil {
+		panic("nonexistent table")
+	}
+	ts := tableSchema(db, sc.DumpString())
+	hdr, err := cr.Read()
+	ck(err)
+	flds := make([]int, len(hdr))
+	for i, col := range hdr {
+		flds[i] = slices.Index(ts.Columns, col)
+		if flds[i] < 0 {
+			panic("nonexistent column: " + col)
+		}
+	}
+	store := db.Store
+	list := sortlist.NewUnsorted(func(x uint64) bool { return x == 0 })
+	vals := make([]string, len(ts.Columns))
+	var size int64
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		ck(err)
+		clear(vals)
+		for i, v := range row {
+			vals[flds[i]] = v
+		}
+		var rb core.RecordBuilder
+		for _, v := range vals {
+			rb.Add(core.SuStr(v))
+		}
+		rec := rb.Trim().Build()
+		off, buf := store.Alloc(len(rec) + cksum.Len)
+		copy(buf, rec)
+		cksum.Update(buf)
+		list.Add(off)
+		n++
+		size += int64(len(rec))
+	}
+	list.Finish()
+	ts.SetupIndexes()
+	// unlike a dump, the rows are not in key order
+	list.Sort(MakeLess(store, &ts.Indexes[0].Ixspec))
+	loadTable2(db, ts, n, size, list, true)
+	db.Persist()
+	return n, nil
+}
@@ -77,13 +77,19 @@ func (t *tran) GetStore() *stor.Stor {
 	return t.db.Store
 }
 
+// Meta is used by prepared queries to check if the schema has changed
+func (t *tran) Meta() *meta.Meta {
+	return t.meta
+}
+
 //-------------------------------------------------------------------
 
 type ReadTran struct {
 	tran
-	num  int
-	asof int64
-	off  uint64
+	num   int
+	asof  int64
+	off   uint64
+	reads atomic.Int32
 }
 
 var nextReadTran atomic.Int32
@@ -151,8 +157,9 @@ func (t *ReadTran) Lookup(table string, iIndex int, key string) *core.DbRec {
 }
 
 func (t *ReadTran) Read(string, int, string, string) {
-	// Read transactions don't need to track reads.
-	// See UpdateTran Read.
+	// Read transactions don't need to track what was read
+	// (see UpdateTran Read) but the count is used by the slow query log
+	t.reads.Add(1)
 }
 
 func (t *ReadTran) Output(*core.Thread, string, core.Record) {
@@ -167,8 +174,10 @@ func (t *ReadTran) Update(*core.Thread, string, uint64, core.Record) uint64 {
 	panic("can't update from read-only transaction")
 }
 
+// ReadCount returns the number of range reads.
+// Unlike UpdateTran, adjacent ranges are not consolidated.
 func (t *ReadTran) ReadCount() int {
-	return 0
+	return int(t.reads.Load())
 }
 
 func (t *ReadTran) WriteCount() int {
@@ -218,6 +227,7 @@ func (t *ReadTran) Abort() string {
 type UpdateTran struct {
 	ct *CkTran
 	ReadTran
+	libNames   []string // changed library records, see libwatch.go
 	writeCount int
 }
 
@@ -259,12 +269,14 @@ func (t *UpdateTran) Complete() string {
 	if !t.db.ck.Commit(t) {
 		return t.ct.failure.Load()
 	}
+	t.libChanged()
 	return ""
 }
 
 // Commit is used by tests. It panics on error.
 func (t *UpdateTran) Commit() {
 	t.ck(t.db.ck.Commit(t))
+	t.libChanged()
 }
 
 // commit is internal, called by checkco (to serialize)
@@ -314,12 +326,63 @@ func (t *UpdateTran) Output(th *core.Thread, table string, rec core.Record) {
 	trace.Dbms.Println("tran Output", table)
 	t.write()
 	ts := t.getSchema(table)
-	ti := t.tran.GetInfo(table) // readonly
 	rec = rec.Truncate(len(ts.Columns))
-	n := rec.Len()
-	off, buf := t.db.Store.Alloc(n + cksum.Len)
-	copy(buf, rec[:n])
-	cksum.Update(buf)
+	keys := t.outputCheck(ts, table, rec)
+	t.output(th, ts, table, rec, keys)
+}
+
+// OutputMany outputs a batch of records to a table.
+// It returns an error for each record, "" if the record was output.
+// Duplicate keys and foreign key violations only fail that record,
+// other errors (e.g. conflicts) panic as with Output.
+// Trigger exceptions also panic, since the record and anything
+// the trigger did have already been written.
+// The preceding records have been output,
+// so the transaction should be rolled back.
+func (t *UpdateTran) OutputMany(th *core.Thread, table string,
+	recs []core.Record) []string {
+	errs := make([]string, len(recs))
+	if t.db.corrupted.Load() {
+		return errs // prevent appending to database
+	}
+	trace.Dbms.Println("tran OutputMany", table, len(recs))
+	ts := t.getSchema(table)
+	for i, rec := range recs {
+		rec = rec.Truncate(len(ts.Columns))
+		var keys []string
+		errs[i] = func() (err string) {
+			defer func() {
+				if e := recover(); e != nil {
+					if s, ok := e.(string); ok && recordError(s) {
+						err = s
+					} else {
+						panic(e)
+					}
+				}
+			}()
+			keys = t.outputCheck(ts, table, rec)
+			return ""
+		}()
+		if errs[i] == "" {
+			t.write()
+			t.output(th, ts, table, rec, keys)
+		}
+	}
+	return errs
+}
+
+// recordError returns whether an outputCheck error only fails the record
+func recordError(err string) bool {
+	return strings.HasPrefix(err, "duplicate key: ") ||
+		strings.HasPrefix(err, "output blocked by foreign key: ")
+}
+
+// outputCheck returns the index keys for a record.
+// It panics if the output would create a duplicate key
+// or is blocked by a foreign key.
+func (t *UpdateTran) outputCheck(ts *meta.Schema, table string,
+	rec core.Record) []string {
+	ti := t.tran.GetInfo(table) // readonly
 	keys := make([]string, len(ts.Indexes))
 	for i := range ts.Indexes {
 		ix := ts.Indexes[i]
@@ -334,7 +397,17 @@ func (t *UpdateTran) Output(th *core.Thread, table string, rec core.Record) {
 		}
 		t.fkeyOutputBlock(ts, i, rec)
 	}
+	return keys
+}
+
+func (t *UpdateTran) output(th *core.Thread, ts *meta.Schema, table string,
+	rec core.Record, keys []string) {
+	n := rec.Len()
+	off, buf := t.db.Store.Alloc(n + cksum.Len)
+	copy(buf, rec[:n])
+	cksum.Update(buf)
 	t.ck(t.db.ck.Output(t.ct, table, keys))
+	var ti *meta.Info
 	func() {
 		defer func() {
 			if e := recover(); e != nil {
@@ -349,6 +422,7 @@ func (t *UpdateTran) Output(th *core.Thread, table string, rec core.Record) {
 	}()
 	ti.Nrows++
 	ti.Size += int64(n)
+	t.libChange(table, "", rec)
 	t.db.CallTrigger(th, t, table, "", rec)
 }
 
@@ -440,6 +514,7 @@ func (t *UpdateTran) Delete(th *core.Thread, table string, off uint64) {
 		assert.That(ti.Size >= n)
 		ti.Size -= n
 	}()
+	t.libChange(table, rec, "")
 	t.db.CallTrigger(th, t, table, rec, "")
 }
 
@@ -602,6 +677,7 @@ func (t *UpdateTran) update(th *core.Thread, table string, oldoff uint64, newrec
 			}
 		}
 	}()
+	t.libChange(table, oldrec, newrec)
 	t.db.CallTrigger(th, t, table, oldrec, newrec)
 	return newoff
 }
@@ -184,6 +184,30 @@ func TestOutputDupConflict(*testing.T) {
 		Panics("conflicted")
 }
 
+func TestOutputMany(t *testing.T) {
+	assert := assert.T(t)
+	db := CreateDb(stor.HeapStor(8192))
+	db.CheckerSync()
+	createTbl(db)
+	ut := db.NewUpdateTran()
+	errs := ut.OutputMany(nil, "mytable",
+		[]core.Record{mkrec("1"), mkrec("2"), mkrec("1")})
+	assert.This(errs).Is([]string{"", "", "duplicate key: one in mytable"})
+	assert.This(ut.WriteCount()).Is(2)
+
+	checkerAbortT1 = true
+	defer func() { checkerAbortT1 = false }()
+	t2 := db.NewUpdateTran()
+	assert.This(func() {
+		t2.OutputMany(nil, "mytable", []core.Record{mkrec("3"), mkrec("1")})
+	}).Panics("conflicted")
+
+	ut.Abort()
+	assert.This(func() {
+		ut.OutputMany(nil, "mytable", []core.Record{mkrec("4")})
+	}).Panics("transaction")
+}
+
 func TestGetIndexI(*testing.T) {
 	db := CreateDb(stor.HeapStor(8192))
 	StartConcur(db, 50*time.Millisecond)
@@ -432,3 +456,31 @@ func TestCombineBug(t *testing.T) {
 
 	db.MustCheck()
 }
+
+func TestLibWatch(t *testing.T) {
+	db := CreateDb(stor.HeapStor(8192))
+	StartConcur(db, 50*time.Millisecond)
+	db.Create(&schema.Schema{
+		Table:   "mylib",
+		Columns: []string{"name", "text"},
+		Indexes: []schema.Index{{Mode: 'k', Columns: []string{"name"}}},
+	})
+	createTbl(db)
+	var changed []string
+	db.WatchLibraries(func() []string { return []string{"mylib"} },
+		func(names []string) { changed = append(changed, names...) })
+
+	ut := db.NewUpdateTran()
+	ut.Output(nil, "mylib", mkrec("Foo", "function () { }"))
+	ut.Output(nil, "mylib", mkrec("Bar__webgui", "class { }"))
+	ut.Output(nil, "mytable", mkrec("Baz"))
+	assert.T(t).This(changed).Is(nil) // not until commit
+	ut.Commit()
+	assert.T(t).This(changed).Is([]string{"Foo", "Bar"})
+
+	changed = nil
+	ut = db.NewUpdateTran()
+	ut.Output(nil, "mylib", mkrec("Abort"))
+	ut.Abort()
+	assert.T(t).This(changed).Is(nil)
+}
@@ -5,6 +5,7 @@ package dbms
 
 import (
 	"bytes"
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
@@ -13,25 +14,55 @@ import (
 	"time"
 
 	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/options"
 )
 
 var VersionMismatch func(string) // injected by gsuneido.go
 
-func ConnectClient(addr string, port string) net.Conn {
-	conn, err := net.Dial("tcp", addr+":"+port)
+// ConnectClient connects to the server and does the hello handshake.
+// The client will reconnect if the connection is lost.
+func ConnectClient(addr string, port string) *dbmsClient {
+	conn, features, errmsg := connect(addr, port)
+	if errmsg != "" {
+		cantConnect(errmsg)
+	}
+	dc := NewDbmsClient(conn, features)
+	dc.addr, dc.port = addr, port
+	dc.cc.OnLost(dc.lost)
+	dc.resume()
+	return dc
+}
+
+const connectTimeout = 10 * time.Second
+
+// connect returns the connection (using TLS if the server requires it)
+// and the hello flags for the features that were agreed on
+// (see NewDbmsClient)
+func connect(addr string, port string) (net.Conn, byte, string) {
+	conn, err := net.DialTimeout("tcp", addr+":"+port, connectTimeout)
 	if err != nil {
 		checkServerStatus(addr, port)
-		cantConnect(err.Error())
+		return nil, 0, err.Error()
 	}
-	conn.Write(hello())
-	errmsg := checkHello(conn)
+	conn.Write(hello(helloFlags(true)))
+	flags, errmsg := checkHello(conn)
 	if errmsg != "" {
 		if strings.HasPrefix(errmsg, "version mismatch") {
 			clientVersionMismatch(conn)
 		}
-		cantConnect(errmsg)
+		conn.Close()
+		return nil, 0, errmsg
+	}
+	if flags&helloTls != 0 {
+		conn, err = tlsHandshake(tls.Client(conn, clientTlsConfig(addr)))
+		if err != nil {
+			return nil, 0, "tls: " + err.Error()
+		}
+	} else if options.Tls {
+		conn.Close()
+		return nil, 0, "server does not support TLS"
 	}
-	return conn
+	return conn, flags & helloFlags(false), ""
 }
 
 func clientVersionMismatch(conn net.Conn) {
@@ -48,11 +48,17 @@ func _() {
 	_ = x[WriteCount-37]
 	_ = x[EndSession-38]
 	_ = x[Asof-39]
+	_ = x[LibChanged-40]
+	_ = x[Resume-41]
+	_ = x[Prepare-42]
+	_ = x[QueryPrepared-43]
+	_ = x[GetMany-44]
+	_ = x[OutputMany-45]
 }
 
-const _Command_name = "AbortAdminAuthCheckCloseCommitConnectionsCursorCursorsEraseExecStrategyFinalGetGetOneHeaderInfoKeysKillLibGetLibrariesLogNonceOrderOutputQueryReadCountActionRewindRunSessionIdSizeTimestampTokenTransactionTransactionsUpdateWriteCountEndSessionAsof"
+const _Command_name = "AbortAdminAuthCheckCloseCommitConnectionsCursorCursorsEraseExecStrategyFinalGetGetOneHeaderInfoKeysKillLibGetLibrariesLogNonceOrderOutputQueryReadCountActionRewindRunSessionIdSizeTimestampTokenTransactionTransactionsUpdateWriteCountEndSessionAsofLibChangedResumePrepareQueryPreparedGetManyOutputMany"
 
-var _Command_index = [...]uint8{0, 5, 10, 14, 19, 24, 30, 41, 47, 54, 59, 63, 71, 76, 79, 85, 91, 95, 99, 103, 109, 118, 121, 126, 131, 137, 142, 151, 157, 163, 166, 175, 179, 188, 193, 204, 216, 222, 232, 242, 246}
+var _Command_index = [...]uint16{0, 5, 10, 14, 19, 24, 30, 41, 47, 54, 59, 63, 71, 76, 79, 85, 91, 95, 99, 103, 109, 118, 121, 126, 131, 137, 142, 151, 157, 163, 166, 175, 179, 188, 193, 204, 216, 222, 232, 242, 246, 256, 262, 269, 282, 289, 299}
 
 func (i Command) String() string {
 	if i >= Command(len(_Command_index)-1) {
@@ -49,4 +49,17 @@ const (
 	WriteCount
 	EndSession
 	Asof
+	// LibChanged is sent from the server to the clients
+	// (not as a response) when library records are committed
+	LibChanged
+	// Resume is used by the client to reconnect after a lost connection
+	Resume
+	// Prepare returns a handle for a query with parameters
+	Prepare
+	// QueryPrepared starts a query from a Prepare handle and parameters
+	QueryPrepared
+	// GetMany returns a batch of rows for client read-ahead
+	GetMany
+	// OutputMany outputs a batch of records to a table
+	OutputMany
 )
@@ -4,7 +4,19 @@
 package dbms
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -13,12 +25,14 @@ import (
 	. "github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/db19"
 	"github.com/apmckinlay/gsuneido/db19/stor"
+	"github.com/apmckinlay/gsuneido/dbms/commands"
 	"github.com/apmckinlay/gsuneido/dbms/mux"
 	"github.com/apmckinlay/gsuneido/options"
 	"github.com/apmckinlay/gsuneido/util/assert"
+	"github.com/apmckinlay/gsuneido/util/str"
 )
 
-func TestClientServer(*testing.T) {
+func TestClientServer(t *testing.T) {
 	// trace.Set(int(trace.ClientServer))
 	options.BuiltDate = "Dec 29 2020 12:34"
 	db := db19.CreateDb(stor.HeapStor(8192))
@@ -26,10 +40,10 @@ func TestClientServer(*testing.T) {
 	p1, p2 := net.Pipe()
 	workers = mux.NewWorkers(doRequest)
 	go newServerConn(dbmsLocal, p1)
-	errmsg := checkHello(p2)
+	_, errmsg := checkHello(p2)
 	assert.This(errmsg).Is("")
-	p2.Write(hello())
-	c := NewDbmsClient(p2)
+	p2.Write(hello(helloFlags(true)))
+	c := NewDbmsClient(p2, 0)
 	ses := c.NewSession()
 	args := SuObjectOf(SuStr("tables sort table"))
 	ses.Get(nil, args, Next)
@@ -63,3 +77,295 @@ func BenchmarkTwo(b *testing.B) {
 		}()
 	}
 }
+
+func TestClientServerTls(t *testing.T) {
+	options.BuiltDate = "Dec 29 2020 12:34"
+	certPem, keyPem := testCert(t)
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.T(t).This(err).Is(nil)
+	serverTls = &tls.Config{Certificates: []tls.Certificate{cert}}
+	options.TlsCA = filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(options.TlsCA, certPem, 0644)
+	options.Compress = 100
+	defer func() {
+		serverTls = nil
+		options.TlsCA = ""
+		options.Compress = 0
+	}()
+	port := testServer(t)
+	conn, features, errmsg := connect("127.0.0.1", port)
+	assert.T(t).This(errmsg).Is("")
+	_, ok := conn.(*tls.Conn)
+	assert.T(t).That(ok)
+	assert.T(t).This(features).Is(byte(helloZip | helloTrace))
+	c := NewDbmsClient(conn, features)
+	ses := c.NewSession()
+	row, _, _ := ses.Get(nil, SuObjectOf(SuStr("tables sort table")), Next)
+	assert.T(t).That(row != nil)
+	ses.Close()
+}
+
+// testServer starts a server on a random port and returns the port
+func testServer(t *testing.T) string {
+	db := db19.CreateDb(stor.HeapStor(8192))
+	db19.StartConcur(db, 50*time.Millisecond)
+	dbmsLocal := NewDbmsLocal(db)
+	workers = mux.NewWorkers(doRequest)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t).This(err).Is(nil)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go newServerConn(dbmsLocal, conn)
+		}
+	}()
+	return str.AfterLast(l.Addr().String(), ":")
+}
+
+func TestReconnect(t *testing.T) {
+	options.BuiltDate = "Dec 29 2020 12:34"
+	port := testServer(t)
+	dc := ConnectClient("127.0.0.1", port)
+	key := dc.resumeKey
+	assert.T(t).This(key).Isnt("")
+	th := &Thread{}
+	ses := dc.NewSession()
+	ses.SessionId(th, "mysession")
+	ses.Admin("sview myview = tables where table = 'tables'", nil)
+	ut := ses.Transaction(true)
+	rt := ses.Transaction(false)
+
+	dc.cc.Close() // drop the connection
+	for !dc.cc.Lost() {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.T(t).This(ut.Complete()).Is("conflict: " + mux.LostConnection)
+	assert.T(t).This(rt.Complete()).Is("")
+	assert.T(t).This(dc.gen).Is(1)
+	// the server aborted the old update transaction
+	tn := IntVal(ut.(*muxTran).tn)
+	assert.T(t).This(ses.Transactions().Find(tn)).Is(False)
+	assert.T(t).This(dc.resumeKey).Isnt(key)
+	row, _, _ := ses.Get(nil, SuObjectOf(SuStr("myview sort table")), Next)
+	assert.T(t).That(row != nil)
+	assert.T(t).This(ses.SessionId(&Thread{}, "")).Is("mysession")
+	assert.T(t).This(func() { ut.Delete(nil, "tables", 0) }).Panics("aborted")
+}
+
+func TestCommitLost(t *testing.T) {
+	p1, p2 := net.Pipe()
+	// a server that drops the connection during Commit
+	ws := mux.NewWorkers(func(wb *mux.WriteBuf, _ *Thread, _ uint64,
+		data []byte) {
+		switch commands.Command(data[0]) {
+		case commands.Transaction:
+			wb.PutBool(true).PutInt(1).EndMsg() // odd for update
+		case commands.Commit:
+			p1.Close()
+		}
+	})
+	go mux.NewServerConn(p1).Run(ws.Submit)
+	dc := NewDbmsClient(p2, 0)
+	dc.cc.OnLost(func(string) {})
+	ses := dc.NewSession()
+	assert.T(t).This(ses.Transaction(true).Complete()).
+		Is("conflict: " + mux.LostConnection +
+			" during commit, the outcome is unknown")
+}
+
+func TestNotResumed(t *testing.T) {
+	options.BuiltDate = "Dec 29 2020 12:34"
+	port := testServer(t)
+	dc := ConnectClient("127.0.0.1", port)
+	ses := dc.NewSession()
+	dc.authorized = true  // as if Auth had succeeded
+	dc.resumeKey = "nope" // as if the server had restarted
+
+	dc.cc.Close() // drop the connection
+	for !dc.cc.Lost() {
+		time.Sleep(time.Millisecond)
+	}
+	assert.T(t).This(func() { ses.Transaction(false) }).
+		Panics("could not be resumed, please log in again")
+	assert.T(t).This(func() { ses.Transaction(false) }).
+		Panics("please log in again")
+	// Auth is allowed (the test database has no users)
+	assert.T(t).This(func() { ses.Auth(&Thread{}, "x") }).
+		Panics("already authorized")
+	assert.T(t).This(ses.Transaction(false).Complete()).Is("")
+}
+
+func TestClientServerPrepared(t *testing.T) {
+	assert := assert.T(t)
+	options.BuiltDate = "Dec 29 2020 12:34"
+	port := testServer(t)
+	dc := ConnectClient("127.0.0.1", port)
+	ses := dc.NewSession()
+	h := ses.Prepare("tables where table = $t")
+	tran := ses.Transaction(false)
+	for _, table := range []string{"tables", "columns", "nonexistent"} {
+		q := tran.QueryPrepared(h, map[string]Value{"t": SuStr(table)}, nil)
+		row, _ := q.Get(nil, Next)
+		assert.This(row != nil).Is(table != "nonexistent")
+		q.Close()
+	}
+
+	// as if the server had discarded the prepared query
+	dc.prepared[h] = -1
+	assert.This(func() { tran.QueryPrepared(h, nil, nil) }).
+		Panics(PreparedNotFound)
+	_, ok := dc.prepared[h]
+	assert.That(!ok)
+	h2 := ses.Prepare("tables where table = $t")
+	q := tran.QueryPrepared(h2, map[string]Value{"t": SuStr("tables")}, nil)
+	row, _ := q.Get(nil, Next)
+	assert.That(row != nil)
+	assert.This(tran.Complete()).Is("")
+}
+
+func TestReadAhead(t *testing.T) {
+	assert := assert.T(t)
+	options.BuiltDate = "Dec 29 2020 12:34"
+	port := testServer(t)
+	dc := ConnectClient("127.0.0.1", port)
+	ses := dc.NewSession()
+	ses.Admin("create tmp (k) key(k)", nil)
+	ut := ses.Transaction(true)
+	for i := range 20 {
+		ut.Action(nil, "insert { k: "+strconv.Itoa(i)+" } into tmp")
+	}
+	assert.This(ut.Complete()).Is("")
+
+	get := func(dirs string, fn func(Dir) Row) string {
+		var sb strings.Builder
+		for _, d := range dirs {
+			if row := fn(Dir(d)); row == nil {
+				sb.WriteString("eof ")
+			} else {
+				sb.WriteString(row[0].GetVal(0).String() + " ")
+			}
+		}
+		return sb.String()
+	}
+	rt := ses.Transaction(false)
+	q := rt.Query("tmp sort k", nil)
+	fn := func(dir Dir) Row { row, _ := q.Get(nil, dir); return row }
+	assert.This(get("+++++--+", fn)).Is("0 1 2 3 4 3 2 3 ")
+	assert.This(get(strings.Repeat("+", 17), fn)).
+		Is("4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 eof ")
+	assert.This(get("-----", fn)).Is("19 18 17 16 15 ")
+	q.Rewind()
+	assert.This(get("++", fn)).Is("0 1 ")
+	assert.This(q.Header().Columns).Is([]string{"k"})
+
+	c := ses.Cursor("tmp sort k", nil)
+	ut = ses.Transaction(true)
+	cfn := func(tran ITran) func(Dir) Row {
+		return func(dir Dir) Row { row, _ := c.Get(nil, tran, dir); return row }
+	}
+	assert.This(get("+++", cfn(rt))).Is("0 1 2 ")
+	assert.This(get("+-", cfn(ut))).Is("3 2 ")
+	assert.This(get("--", cfn(rt))).Is("1 0 ")
+	rt.Complete()
+	ut.Complete()
+
+	// read ahead is not reused by a different transaction
+	rt = ses.Transaction(false)
+	c.Rewind()
+	assert.This(get("++", cfn(rt))).Is("0 1 ") // 2 is read ahead
+	rt.Complete()
+	ut = ses.Transaction(true)
+	ut.Action(nil, "delete tmp where k is 2")
+	assert.This(ut.Complete()).Is("")
+	rt = ses.Transaction(false)
+	assert.This(get("++", cfn(rt))).Is("3 4 ")
+	rt.Complete()
+}
+
+func TestReadAheadSize(t *testing.T) {
+	assert := assert.T(t)
+	options.BuiltDate = "Dec 29 2020 12:34"
+	port := testServer(t)
+	dc := ConnectClient("127.0.0.1", port)
+	ses := dc.NewSession()
+	ses.Admin("create tmp (k, s) key(k)", nil)
+	ut := ses.Transaction(true)
+	big := strings.Repeat("x", 6000)
+	const nrows = 150
+	for i := range nrows {
+		ut.Action(nil, "insert { k: "+strconv.Itoa(i)+", s: '"+big+"' } into tmp")
+	}
+	assert.This(ut.Complete()).Is("")
+
+	q := ses.Transaction(false).Query("tmp sort k", nil).(*muxQuery)
+	for i := range nrows {
+		row, _ := q.Get(nil, Next)
+		assert.This(row[0].GetVal(0)).Is(IntVal(i))
+		size := len(row[0].Record) // including this row
+		for _, r := range q.rows {
+			size += len(r.row[0].Record)
+		}
+		assert.That(size <= maxBatch)
+	}
+	row, _ := q.Get(nil, Next)
+	assert.That(row == nil)
+}
+
+func TestOutputMany(t *testing.T) {
+	assert := assert.T(t)
+	options.BuiltDate = "Dec 29 2020 12:34"
+	port := testServer(t)
+	dc := ConnectClient("127.0.0.1", port)
+	ses := dc.NewSession()
+	ses.Admin("create tmp (k) key(k)", nil)
+	rec := func(k int) Record {
+		var rb RecordBuilder
+		rb.Add(IntVal(k))
+		return rb.Build()
+	}
+	ut := ses.Transaction(true)
+	errs := ut.OutputMany(nil, "tmp", []Record{rec(1), rec(2), rec(1), rec(3)})
+	assert.This(errs[:2]).Is([]string{"", ""})
+	assert.That(strings.Contains(errs[2], "duplicate key"))
+	assert.This(errs[3]).Is("")
+	assert.This(ut.Complete()).Is("")
+
+	rt := ses.Transaction(false)
+	q := rt.Query("tmp", nil)
+	n := 0
+	for row, _ := q.Get(nil, Next); row != nil; row, _ = q.Get(nil, Next) {
+		n++
+	}
+	assert.This(n).Is(3)
+	assert.This(func() { rt.OutputMany(nil, "tmp", []Record{rec(4)}) }).
+		Panics("read-only")
+	rt.Complete()
+}
+
+// testCert returns a self-signed certificate and key for 127.0.0.1
+func testCert(t *testing.T) (certPem, keyPem []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.T(t).This(err).Is(nil)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.T(t).This(err).Is(nil)
+	kb, err := x509.MarshalECPrivateKey(key)
+	assert.T(t).This(err).Is(nil)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb})
+}
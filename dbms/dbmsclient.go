@@ -6,10 +6,13 @@ package dbms
 import (
 	"bufio"
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"slices"
 
@@ -17,29 +20,209 @@ import (
 	"github.com/apmckinlay/gsuneido/core/trace"
 	"github.com/apmckinlay/gsuneido/dbms/commands"
 	"github.com/apmckinlay/gsuneido/dbms/mux"
+	qry "github.com/apmckinlay/gsuneido/dbms/query"
 	"github.com/apmckinlay/gsuneido/options"
 	"github.com/apmckinlay/gsuneido/util/ascii"
 	"github.com/apmckinlay/gsuneido/util/assert"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/str"
 )
 
 // dbmsClient is the mux client that matches dbmsserver.
+// If the connection is lost, it reconnects on the next request
+// (see ConnectClient)
 type dbmsClient struct {
-	cc *mux.ClientConn
+	cc        *mux.ClientConn // guarded by lock
+	gen       int             // incremented by reconnect, guarded by lock
+	addr      string
+	port      string
+	resumeKey string            // from the server, guarded by lock
+	sviews    map[string]string // session view admin, guarded by lock
+	// prepared maps Prepare handles to server handles, guarded by lock.
+	// It is cleared by reconnect since the server may have restarted.
+	prepared map[int]int
+	// authorized is set when Auth succeeds, guarded by lock
+	authorized bool
+	// notResumed is set by reconnect if an authorized connection
+	// could not be resumed. Requests panic until Auth is called.
+	// guarded by lock
+	notResumed bool
+	lock       sync.Mutex
+}
+
+// NewDbmsClient returns a client for conn.
+// features are the hello flags agreed on with the server.
+// With helloZip, messages of at least options.Compress are compressed.
+// With helloTrace, requests include the trace context.
+func NewDbmsClient(conn net.Conn, features byte) *dbmsClient {
+	return &dbmsClient{cc: newClientConn(conn, features),
+		sviews: make(map[string]string), prepared: make(map[int]int)}
+}
+
+func newClientConn(conn net.Conn, features byte) *mux.ClientConn {
+	cc := mux.NewClientConn(conn)
+	if features&helloZip != 0 {
+		cc.SetCompress(options.Compress)
+	}
+	if features&helloTrace != 0 {
+		cc.SetTrace()
+	}
+	cc.OnNotify(libChanged)
+	return cc
 }
 
-func NewDbmsClient(conn net.Conn) *dbmsClient {
-	cc := mux.NewClientConn(conn)
-	return &dbmsClient{cc: cc}
+func (dc *dbmsClient) lost(err string) {
+	log.Println("client: lost connection:", err)
+}
+
+// current returns the connection and its generation,
+// reconnecting first if the connection has been lost
+func (dc *dbmsClient) current() (*mux.ClientConn, int) {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if dc.cc.Lost() && dc.addr != "" {
+		dc.reconnect()
+	}
+	if dc.notResumed {
+		panic("client: lost connection and the session could not be resumed," +
+			" please log in again")
+	}
+	return dc.cc, dc.gen
+}
+
+// allowAuth lets Auth be used after a session could not be resumed
+func (dc *dbmsClient) allowAuth() {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.notResumed = false
+}
+
+func (dc *dbmsClient) setAuthorized() {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.authorized = true
+}
+
+const reconnectTimeout = time.Minute
+
+// reconnect retries until reconnectTimeout and then it is fatal.
+// It must be called with the lock held.
+func (dc *dbmsClient) reconnect() {
+	deadline := time.Now().Add(reconnectTimeout)
+	delay := 100 * time.Millisecond
+	for {
+		conn, features, errmsg := connect(dc.addr, dc.port)
+		if errmsg == "" {
+			dc.cc = newClientConn(conn, features)
+			break
+		}
+		if time.Now().After(deadline) {
+			cantConnect("lost connection: " + errmsg)
+		}
+		time.Sleep(delay)
+		delay = min(2*delay, 5*time.Second)
+	}
+	dc.gen++
+	clear(dc.prepared)
+	dc.cc.OnLost(dc.lost)
+	resumed := dc.resume()
+	Global.UnloadAll() // may have missed LibChanged
+	log.Println("client: reconnected, resumed:", resumed)
+	if !resumed && dc.authorized {
+		// the new connection is not authorized
+		dc.authorized = false
+		dc.notResumed = true
+	}
+}
+
+// resume gets a key from the server to resume the connection after it is lost.
+// If we already have a key, and the server still holds the lost connection,
+// the authorization is resumed.
+// Session views are replayed since they are per connection.
+func (dc *dbmsClient) resume() bool {
+	cs := dc.cc.NewClientSession()
+	defer func() {
+		cs.PutCmd(commands.EndSession)
+		cs.EndMsg()
+	}()
+	cs.PutCmd(commands.Resume).PutStr(dc.resumeKey)
+	cs.Request()
+	resumed := cs.GetBool()
+	dc.resumeKey = cs.GetStr()
+	for _, admin := range dc.sviews {
+		cs.PutCmd(commands.Admin).PutStr(admin)
+		cs.Request()
+	}
+	return resumed
+}
+
+// saveSview keeps the session view definitions so they can be replayed
+func (dc *dbmsClient) saveSview(admin string) {
+	s := strings.TrimSpace(admin)
+	if name, ok := strings.CutPrefix(s, "drop "); ok {
+		dc.lock.Lock()
+		delete(dc.sviews, strings.TrimSpace(name))
+		dc.lock.Unlock()
+	} else if def, ok := strings.CutPrefix(s, "sview "); ok {
+		name := strings.TrimSpace(str.BeforeFirst(def, "="))
+		dc.lock.Lock()
+		dc.sviews[name] = admin
+		dc.lock.Unlock()
+	}
+}
+
+// libChanged handles LibChanged notifications from the server
+func libChanged(data []byte) {
+	var rb mux.ReadBuf
+	rb.SetBuf(data)
+	if commands.Command(rb.GetByte()) != commands.LibChanged {
+		return
+	}
+	for _, name := range rb.GetStrs() {
+		Global.Unload(name)
+	}
 }
 
 type muxSession struct {
 	*mux.ClientSession
+	dc         *dbmsClient
+	gen        int    // the connection generation of ClientSession
+	sid        string // the session id set by SessionId, for reconnect
+	spanSource func() otel.Context
 }
 
 func (dc *dbmsClient) NewSession() *muxSession {
-	cs := dc.cc.NewClientSession()
-	return &muxSession{ClientSession: cs}
+	cc, gen := dc.current()
+	return &muxSession{ClientSession: cc.NewClientSession(), dc: dc, gen: gen}
+}
+
+// PutCmd starts a request.
+// If the connection was replaced, it switches to a new ClientSession
+// and restores the session id.
+func (ms *muxSession) PutCmd(cmd commands.Command) *mux.WriteBuf {
+	if cc, gen := ms.dc.current(); gen != ms.gen {
+		ms.ClientSession = cc.NewClientSession()
+		ms.ClientSession.SetSpanSource(ms.spanSource)
+		ms.gen = gen
+		if ms.sid != "" {
+			ms.ClientSession.PutCmd(commands.SessionId).PutStr(ms.sid)
+			ms.Request()
+			ms.GetStr()
+		}
+	}
+	return ms.ClientSession.PutCmd(cmd)
+}
+
+// stale returns whether the connection has been lost or replaced
+func (ms *muxSession) stale() bool {
+	ms.dc.lock.Lock()
+	defer ms.dc.lock.Unlock()
+	return ms.gen != ms.dc.gen || ms.dc.cc.Lost()
+}
+
+func (ms *muxSession) SetSpanSource(fn func() otel.Context) {
+	ms.spanSource = fn
+	ms.ClientSession.SetSpanSource(fn)
 }
 
 // Dbms interface
@@ -49,15 +232,18 @@ var _ IDbms = (*muxSession)(nil)
 func (ms *muxSession) Admin(admin string, _ *Sviews) {
 	ms.PutCmd(commands.Admin).PutStr(admin)
 	ms.Request()
+	ms.dc.saveSview(admin)
 }
 
 func (ms *muxSession) Auth(th *Thread, s string) bool {
 	if s == "" {
 		return false
 	}
+	ms.dc.allowAuth()
 	ms.PutCmd(commands.Auth).PutStr(s)
 	ms.Request()
 	if ms.GetBool() {
+		ms.dc.setAuthorized()
 		if options.Mode == "gui" {
 			SendErrorLog(ms, th.SessionId(""))
 		}
@@ -109,6 +295,9 @@ func (ms *muxSession) Check() string {
 }
 
 func (ms *muxSession) Close() {
+	if ms.stale() {
+		return // the server has already ended the session
+	}
 	ms.PutCmd(commands.EndSession)
 	ms.EndMsg()
 }
@@ -219,6 +408,35 @@ func (ms *muxSession) Nonce(*Thread) string {
 	return ms.GetStr_()
 }
 
+// Prepare returns a local handle, which remains valid after a reconnect,
+// and prepares the query on the server
+func (ms *muxSession) Prepare(query string) int {
+	h := qry.Prepare(query)
+	ms.serverPrepared(h)
+	return h
+}
+
+// serverPrepared returns the server handle for a Prepare handle,
+// preparing the query on the server if it has not been done
+// on the current connection
+func (ms *muxSession) serverPrepared(h int) int {
+	ms.dc.lock.Lock()
+	sh, ok := ms.dc.prepared[h]
+	ms.dc.lock.Unlock()
+	if ok {
+		return sh
+	}
+	ms.PutCmd(commands.Prepare).PutStr(qry.GetPrepared(h).String())
+	ms.Request()
+	sh = ms.GetInt()
+	ms.dc.lock.Lock()
+	if ms.gen == ms.dc.gen {
+		ms.dc.prepared[h] = sh
+	}
+	ms.dc.lock.Unlock()
+	return sh
+}
+
 func (ms *muxSession) Run(_ *Thread, code string) Value {
 	ms.PutCmd(commands.Run).PutStr(code)
 	ms.Request()
@@ -237,6 +455,9 @@ func (ms *muxSession) SessionId(th *Thread, id string) string {
 	ms.Request()
 	s := ms.GetStr()
 	th.SetSession(s)
+	if id != "" {
+		ms.sid = s
+	}
 	return s
 }
 
@@ -262,7 +483,7 @@ func (ms *muxSession) Transaction(update bool) ITran {
 	ms.PutCmd(commands.Transaction).PutBool(update)
 	ms.Request()
 	tn := ms.GetInt()
-	return &muxTran{muxSession: ms, tn: tn}
+	return &muxTran{muxSession: ms, tn: tn, gen: ms.gen}
 }
 
 func (ms *muxSession) Transactions() *SuObject {
@@ -314,12 +535,32 @@ func (ms *muxSession) getRow(off int) Row {
 
 type muxTran struct {
 	*muxSession
-	tn int
+	tn  int
+	gen int
 }
 
 var _ ITran = (*muxTran)(nil)
 
+// lost returns whether the transaction was on a connection that was lost.
+// The server will abort it (if it was an update transaction)
+func (tc *muxTran) lost() bool {
+	_, gen := tc.dc.current()
+	return gen != tc.gen
+}
+
+const tranLost = "transaction aborted: " + mux.LostConnection
+
+func (tc *muxTran) PutCmd(cmd commands.Command) *mux.WriteBuf {
+	if tc.lost() {
+		panic(tranLost)
+	}
+	return tc.muxSession.PutCmd(cmd)
+}
+
 func (tc *muxTran) Abort() string {
+	if tc.lost() {
+		return ""
+	}
 	tc.PutCmd(commands.Abort).PutInt(tc.tn)
 	tc.Request()
 	return ""
@@ -332,20 +573,49 @@ func (tc *muxTran) Asof(asof int64) int64 {
 }
 
 func (tc *muxTran) Complete() string {
+	if tc.lost() {
+		if tc.tn%2 == 1 { // update
+			return "conflict: " + mux.LostConnection
+		}
+		return ""
+	}
 	tc.PutCmd(commands.Commit).PutInt(tc.tn)
-	tc.Request()
+	if !tc.commitRequest() {
+		if tc.tn%2 == 1 { // update
+			return "conflict: " + mux.LostConnection +
+				" during commit, the outcome is unknown"
+		}
+		return ""
+	}
 	if tc.GetBool() {
 		return ""
 	}
 	return tc.GetStr()
 }
 
+// commitRequest returns false if the connection was lost during the commit
+func (tc *muxTran) commitRequest() (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if e != mux.LostConnection {
+				panic(e)
+			}
+			ok = false
+		}
+	}()
+	tc.Request()
+	return true
+}
+
 func (tc *muxTran) Delete(_ *Thread, table string, off uint64) {
 	tc.PutCmd(commands.Erase).PutInt(tc.tn).PutStr(table).PutInt(int(off))
 	tc.Request()
 }
 
 func (tc *muxTran) Get(_ *Thread, query Value, dir Dir) (Row, *Header, string) {
+	if tc.lost() {
+		panic(tranLost)
+	}
 	return tc.get(tc.tn, query, dir)
 }
 
@@ -353,7 +623,33 @@ func (tc *muxTran) Query(query string, _ *Sviews) IQuery {
 	tc.PutCmd(commands.Query).PutInt(tc.tn).PutStr(query)
 	tc.Request()
 	qn := tc.GetInt()
-	return tc.muxSession.newClientQuery(qn)
+	return tc.muxSession.newClientQuery(qn, tc.tn%2 == 1)
+}
+
+func (tc *muxTran) QueryPrepared(handle int, params map[string]Value,
+	_ *Sviews) IQuery {
+	sh := tc.muxSession.serverPrepared(handle)
+	wb := tc.PutCmd(commands.QueryPrepared).PutInt(tc.tn).PutInt(sh).
+		PutInt(len(params))
+	for name, val := range params {
+		wb.PutStr(name).PutVal(val)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if s, ok := e.(string); ok &&
+				strings.HasPrefix(s, PreparedNotFound) {
+				// discarded by the server, SuTran will prepare it again
+				tc.dc.lock.Lock()
+				delete(tc.dc.prepared, handle)
+				tc.dc.lock.Unlock()
+				panic(PreparedNotFound)
+			}
+			panic(e)
+		}
+	}()
+	tc.Request()
+	qn := tc.GetInt()
+	return tc.muxSession.newClientQuery(qn, tc.tn%2 == 1)
 }
 
 func (tc *muxTran) ReadCount() int {
@@ -368,6 +664,33 @@ func (tc *muxTran) Action(_ *Thread, action string) int {
 	return tc.GetInt()
 }
 
+// outputBatch is the size (in bytes) of the batches sent by OutputMany
+const outputBatch = 256 * 1024
+
+// OutputMany sends the records in batches
+// to avoid a round trip per record
+func (tc *muxTran) OutputMany(_ *Thread, table string, recs []Record) []string {
+	errs := make([]string, len(recs))
+	for i := 0; i < len(recs); {
+		n, size := 0, 0
+		for i+n < len(recs) && (n == 0 || size+len(recs[i+n]) <= outputBatch) {
+			size += len(recs[i+n])
+			n++
+		}
+		wb := tc.PutCmd(commands.OutputMany).PutInt(tc.tn).PutStr(table).PutInt(n)
+		for _, rec := range recs[i : i+n] {
+			wb.PutRec(rec)
+		}
+		tc.Request()
+		for nerrs := tc.GetInt(); nerrs > 0; nerrs-- {
+			j := tc.GetInt()
+			errs[i+j] = tc.GetStr()
+		}
+		i += n
+	}
+	return errs
+}
+
 func (tc *muxTran) Update(_ *Thread, table string, off uint64, rec Record) uint64 {
 	tc.PutCmd(commands.Update).
 		PutInt(tc.tn).PutStr(table).PutInt(int(off)).PutRec(rec)
@@ -401,7 +724,113 @@ type muxQueryCursor struct {
 	hdr  *Header
 	keys []string // cache
 	id   int
+	gen  int
 	qc   qcType
+	readAhead
+}
+
+// readAhead buffers rows fetched with GetMany.
+// The batch size starts at one row and doubles
+// as long as Get continues in the same direction.
+// The server is positioned after the buffered rows,
+// so to discard them (e.g. on a change of direction or transaction)
+// the server steps back over them.
+type readAhead struct {
+	rows  []aheadRow
+	batch int
+	tn    int // the transaction the rows were read with
+	dir   Dir
+	eof   bool // the server reached the end (and rewound)
+}
+
+type aheadRow struct {
+	row   Row
+	table string
+}
+
+const maxReadAhead = 1024 // rows
+
+// get returns the next row in the given direction.
+// tn is zero for queries.
+// ahead is false for update transactions,
+// which need current data, so they only fetch one row at a time.
+func (qc *muxQueryCursor) get(tn int, dir Dir, ahead bool) (Row, string) {
+	ra := &qc.readAhead
+	same := ahead && dir == ra.dir && tn == ra.tn
+	if same {
+		if len(ra.rows) > 0 {
+			return ra.next()
+		}
+		if ra.eof {
+			*ra = readAhead{}
+			return nil, ""
+		}
+	}
+	back := ra.dir.Reverse()
+	skip := len(ra.rows)
+	if ra.eof {
+		skip++
+	}
+	if !ahead && skip == 0 {
+		*ra = readAhead{}
+		return qc.getOne(tn, dir)
+	}
+	n := 1
+	if same {
+		n = min(2*ra.batch, maxReadAhead)
+	}
+	*ra = readAhead{tn: tn, dir: dir, batch: n, rows: ra.rows[:0]}
+	qc.PutCmd(commands.GetMany).PutByte(byte(dir)).PutInt(tn).PutInt(qc.id).
+		PutByte(byte(back)).PutInt(skip).PutInt(n).PutBool(qc.hdr == nil)
+	qc.Request()
+	if qc.hdr == nil {
+		qc.hdr = qc.getHdr()
+	}
+	ra.eof = qc.GetBool()
+	for n = qc.GetInt(); n > 0; n-- {
+		off := qc.GetInt()
+		table := qc.GetStr()
+		ra.rows = append(ra.rows, aheadRow{row: qc.getRow(off), table: table})
+	}
+	if len(ra.rows) == 0 {
+		*ra = readAhead{} // the server has rewound
+		return nil, ""
+	}
+	return ra.next()
+}
+
+func (ra *readAhead) next() (Row, string) {
+	r := ra.rows[0]
+	ra.rows = ra.rows[1:]
+	return r.row, r.table
+}
+
+func (qc *muxQueryCursor) getOne(tn int, dir Dir) (Row, string) {
+	qc.PutCmd(commands.Get).PutByte(byte(dir)).PutInt(tn).PutInt(qc.id)
+	qc.Request()
+	if !qc.GetBool() {
+		return nil, ""
+	}
+	off := qc.GetInt()
+	table := qc.GetStr()
+	row := qc.getRow(off)
+	return row, table
+}
+
+func (qc *muxQueryCursor) lost() bool {
+	_, gen := qc.dc.current()
+	return gen != qc.gen
+}
+
+func (qc *muxQueryCursor) PutCmd(cmd commands.Command) *mux.WriteBuf {
+	if qc.lost() {
+		what := "query"
+		if qc.qc == cursor {
+			what = "cursor"
+		}
+		panic(what + " closed: " + mux.LostConnection)
+	}
+	return qc.muxSession.PutCmd(cmd)
 }
 
 type qcType byte
@@ -412,6 +841,9 @@ const (
 )
 
 func (qc *muxQueryCursor) Close() {
+	if qc.lost() {
+		return
+	}
 	qc.PutCmd(commands.Close).PutInt(qc.id).PutByte(byte(qc.qc))
 	qc.Request()
 }
@@ -441,6 +873,7 @@ func (qc *muxQueryCursor) Order() []string {
 }
 
 func (qc *muxQueryCursor) Rewind() {
+	qc.readAhead = readAhead{}
 	qc.PutCmd(commands.Rewind).PutInt(qc.id).PutByte(byte(qc.qc))
 	qc.Request()
 }
@@ -458,24 +891,18 @@ func (qc *muxQueryCursor) Tree() Value {
 // muxQuery implements IQuery ------------------------------------
 type muxQuery struct {
 	muxQueryCursor
+	update bool
 }
 
-func (ms *muxSession) newClientQuery(qn int) *muxQuery {
-	return &muxQuery{muxQueryCursor{muxSession: ms, id: qn, qc: query}}
+func (ms *muxSession) newClientQuery(qn int, update bool) *muxQuery {
+	return &muxQuery{muxQueryCursor: muxQueryCursor{muxSession: ms, id: qn,
+		gen: ms.gen, qc: query}, update: update}
 }
 
 var _ IQuery = (*muxQuery)(nil)
 
 func (q *muxQuery) Get(_ *Thread, dir Dir) (Row, string) {
-	q.PutCmd(commands.Get).PutByte(byte(dir)).PutInt(0).PutInt(q.id)
-	q.Request()
-	if !q.GetBool() {
-		return nil, ""
-	}
-	off := q.GetInt()
-	table := q.GetStr()
-	row := q.getRow(off)
-	return row, table
+	return q.get(0, dir, !q.update)
 }
 
 func (q *muxQuery) Output(_ *Thread, rec Record) {
@@ -489,20 +916,13 @@ type muxCursor struct {
 }
 
 func (ms *muxSession) newClientCursor(cn int) *muxCursor {
-	return &muxCursor{muxQueryCursor{muxSession: ms, id: cn, qc: cursor}}
+	return &muxCursor{muxQueryCursor{muxSession: ms, id: cn, gen: ms.gen,
+		qc: cursor}}
 }
 
 var _ ICursor = (*muxCursor)(nil)
 
 func (q *muxCursor) Get(_ *Thread, tran ITran, dir Dir) (Row, string) {
 	t := tran.(*muxTran)
-	q.PutCmd(commands.Get).PutByte(byte(dir)).PutInt(t.tn).PutInt(q.id)
-	q.Request()
-	if !q.GetBool() {
-		return nil, ""
-	}
-	off := q.GetInt()
-	table := q.GetStr()
-	row := q.getRow(off)
-	return row, table
+	return q.get(t.tn, dir, t.tn%2 == 0)
 }
@@ -4,10 +4,15 @@
 package dbms
 
 import (
+	"bufio"
+	"encoding/csv"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"unicode/utf8"
 
 	"slices"
 
@@ -19,8 +24,10 @@ import (
 	"github.com/apmckinlay/gsuneido/db19/tools"
 	qry "github.com/apmckinlay/gsuneido/dbms/query"
 	"github.com/apmckinlay/gsuneido/options"
+	"github.com/apmckinlay/gsuneido/util/decode"
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
 	"github.com/apmckinlay/gsuneido/util/generic/slc"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/str"
 )
 
@@ -35,9 +42,20 @@ type DbmsLocal struct {
 func NewDbmsLocal(db *db19.Database) *DbmsLocal {
 	dbms := DbmsLocal{db: db}
 	dbms.libraries.Store([]string{"stdlib"})
+	db.WatchLibraries(dbms.Libraries, libChangedLocal)
 	return &dbms
 }
 
+// libChangedLocal is called by db19 after a transaction that modified
+// library records commits. It unloads them here and in any clients.
+func libChangedLocal(names []string) {
+	trace.Dbms.Println("LibChanged", names)
+	for _, name := range names {
+		Global.Unload(name)
+	}
+	notifyLibChanged(names)
+}
+
 // Dbms interface
 
 var _ IDbms = (*DbmsLocal)(nil)
@@ -87,8 +105,8 @@ func (dbms *DbmsLocal) Cursor(query string, sv *Sviews) ICursor {
 	tran := dbms.db.NewReadTran()
 	q, fixcost, varcost := buildQuery(query, tran, sv, qry.CursorMode)
 	trace.Query.Println("cursor", fixcost+varcost, "-", query)
-	return cursorLocal{queryLocal{
-		Query: q, cost: fixcost + varcost, mode: qry.CursorMode}}
+	return cursorLocal{queryLocal{Query: q, cost: fixcost + varcost,
+		mode: qry.CursorMode, text: query, slow: newSlowTotal()}}
 }
 
 func buildQuery(query string, tran qry.QueryTran, sv *Sviews,
@@ -184,8 +202,33 @@ func (dbms *DbmsLocal) Load(table, from, privateKey, passphrase string) int {
 	return n
 }
 
+// LoadCsv replaces the contents of a table with the rows from a CSV file
+// (with a header row of column names)
+func (dbms *DbmsLocal) LoadCsv(table, from, delimiter, encoding string) int {
+	f, err := os.Open(from)
+	if err != nil {
+		panic("LoadCsv: " + err.Error())
+	}
+	defer f.Close()
+	r, err := decode.Reader(bufio.NewReader(f), encoding)
+	if err != nil {
+		panic("LoadCsv: " + err.Error())
+	}
+	cr := csv.NewReader(r)
+	if delimiter != "" {
+		cr.Comma, _ = utf8.DecodeRuneInString(delimiter)
+	}
+	cr.ReuseRecord = true
+	n, err := tools.LoadCsv(dbms.db, table, cr)
+	if err != nil {
+		panic(err.Error())
+	}
+	return n
+}
+
 // LibGet returns a list of strings.
-// The strings are in pairs - "<lib>:<name>[__tag]", "definition".
+// The strings are in pairs - "<lib>[__tag]@<version>", "definition".
+// The version identifies the library record (it is the record offset).
 // The order is significant - first by Libraries() and then by LibraryTags.
 // Later definitions can override or inherit from earlier ones.
 // NOTE: libload depends on every library being in the results.
@@ -236,7 +279,8 @@ func (dbms *DbmsLocal) LibGet1(rt *db19.ReadTran, lib, name string, defs []strin
 		key := rb.String()
 		off := ix.Lookup(key)
 		if off != 0 {
-			defs = append(defs, lib+tag, rt.GetRecord(off).GetStr(fld))
+			defs = append(defs, lib+tag+"@"+strconv.FormatUint(off, 10),
+				rt.GetRecord(off).GetStr(fld))
 			found = true
 		}
 	}
@@ -266,6 +310,10 @@ func (*DbmsLocal) Nonce(th *Thread) string {
 	return th.Nonce
 }
 
+func (*DbmsLocal) Prepare(query string) int {
+	return qry.Prepare(query)
+}
+
 func (*DbmsLocal) Run(th *Thread, s string) Value {
 	defer th.Suneido.Store(th.Suneido.Load())
 	th.Suneido.Store(nil) // use main Suneido object
@@ -403,13 +451,23 @@ func (t ReadTranLocal) Get(th *Thread, query Value, dir Dir) (Row, *Header, stri
 func (t ReadTranLocal) Query(query string, sv *Sviews) IQuery {
 	q, fixcost, varcost := buildQuery(query, t.ReadTran, sv, qry.ReadMode)
 	trace.Query.Println(fixcost+varcost, "-", query)
-	return queryLocal{Query: q, cost: fixcost + varcost, mode: qry.ReadMode}
+	return queryLocal{Query: q, cost: fixcost + varcost, mode: qry.ReadMode,
+		text: query, tran: t, slow: newSlowTotal()}
+}
+
+func (t ReadTranLocal) QueryPrepared(handle int, params map[string]Value,
+	sv *Sviews) IQuery {
+	return queryPrepared(handle, params, t.ReadTran, sv, qry.ReadMode, t)
 }
 
 func (t ReadTranLocal) Action(*Thread, string) int {
 	panic("cannot do action in read-only transaction")
 }
 
+func (t ReadTranLocal) OutputMany(*Thread, string, []Record) []string {
+	panic("can't output to read-only transaction")
+}
+
 // UpdateTranLocal --------------------------------------------------------
 
 type UpdateTranLocal struct {
@@ -423,14 +481,34 @@ func (t UpdateTranLocal) Get(th *Thread, query Value, dir Dir) (Row, *Header, st
 func (t UpdateTranLocal) Query(query string, sv *Sviews) IQuery {
 	q, fixcost, varcost := buildQuery(query, t.UpdateTran, sv, qry.UpdateMode)
 	trace.Query.Println("update", fixcost+varcost, "-", query)
-	return queryLocal{Query: q, cost: fixcost + varcost, mode: qry.UpdateMode}
+	return queryLocal{Query: q, cost: fixcost + varcost, mode: qry.UpdateMode,
+		text: query, tran: t, slow: newSlowTotal()}
+}
+
+func (t UpdateTranLocal) QueryPrepared(handle int, params map[string]Value,
+	sv *Sviews) IQuery {
+	return queryPrepared(handle, params, t.UpdateTran, sv, qry.UpdateMode, t)
 }
 
 func (t UpdateTranLocal) Action(th *Thread, action string) int {
 	defer th.Suneido.Store(th.Suneido.Load())
 	th.Suneido.Store(nil) // use main Suneido object
 	trace.Dbms.Println("Action", action)
-	return qry.DoAction(th, t.UpdateTran, action)
+	span := th.StartSpan("Action", otel.Internal)
+	defer th.EndSpan(span)
+	span.SetAttr("action", action)
+	st := slowStart(t.UpdateTran)
+	n := qry.DoAction(th, t.UpdateTran, action)
+	st.check(th, "Action", action, nil)
+	return n
+}
+
+func (t UpdateTranLocal) OutputMany(th *Thread, table string,
+	recs []Record) []string {
+	defer th.Suneido.Store(th.Suneido.Load())
+	th.Suneido.Store(nil) // use main Suneido object
+	trace.Dbms.Println("OutputMany", table, len(recs))
+	return t.UpdateTran.OutputMany(th, table, recs)
 }
 
 func (t UpdateTranLocal) Update(th *Thread, table string, oldoff uint64, newrec Record) uint64 {
@@ -445,9 +523,22 @@ func (t UpdateTranLocal) Update(th *Thread, table string, oldoff uint64, newrec
 type queryLocal struct {
 	// Query is embedded so most methods are "inherited" directly
 	qry.Query
-	keys []string // cache
-	cost qry.Cost
-	mode qry.Mode
+	keys    []string // cache
+	cost    qry.Cost
+	mode    qry.Mode
+	text    string      // for the slow query log
+	tran    readCounter // nil for cursors
+	release func()      // for prepared queries, returns the plan for reuse
+	slow    *slowTotal  // nil if the slow query log is disabled
+}
+
+func queryPrepared(handle int, params map[string]Value, tran qry.QueryTran,
+	sv *Sviews, mode qry.Mode, rc readCounter) IQuery {
+	p := qry.GetPrepared(handle)
+	q, cost, release := p.Query(tran, sv, mode, params)
+	trace.Query.Println(mode, cost, "-", p)
+	return queryLocal{Query: q, cost: cost, mode: mode,
+		text: p.String(), tran: rc, release: release, slow: newSlowTotal()}
 }
 
 func (q queryLocal) Keys() []string {
@@ -481,22 +572,38 @@ func (q queryLocal) Order() []string {
 }
 
 func (q queryLocal) Get(th *Thread, dir Dir) (Row, string) {
+	return q.get(th, q.tran, dir)
+}
+
+func (q queryLocal) get(th *Thread, tran readCounter, dir Dir) (Row, string) {
 	defer th.Suneido.Store(th.Suneido.Load())
 	th.Suneido.Store(nil) // use main Suneido object
+	st := slowStart(tran)
 	row := q.Query.Get(th, dir)
+	q.slow.add(th, st)
 	if row == nil {
+		q.checkSlow()
 		// this is required for SuQuery to stick at eof unidirectionally
 		q.Query.Rewind()
 	}
 	return row, q.Query.Updateable()
 }
 
+// checkSlow logs the query if the Gets since the last check were slow
+func (q queryLocal) checkSlow() {
+	q.slow.check("Get", q.text, func() string { return q.Strategy(false) })
+}
+
 func (q queryLocal) Tree() Value {
 	qry.CalcSelf(q.Query)
 	return qry.NewSuQueryNode(q.Query)
 }
 
 func (q queryLocal) Close() {
+	q.checkSlow()
+	if q.release != nil {
+		q.release()
+	}
 }
 
 // cursorLocal
@@ -507,5 +614,5 @@ type cursorLocal struct {
 
 func (q cursorLocal) Get(th *Thread, t ITran, dir Dir) (Row, string) {
 	q.Query.SetTran(t.(qry.QueryTran))
-	return q.queryLocal.Get(th, dir)
+	return q.queryLocal.get(th, t, dir)
 }
@@ -5,11 +5,19 @@ package dbms
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"maps"
 	"net"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,9 +27,13 @@ import (
 	"github.com/apmckinlay/gsuneido/core/trace"
 	"github.com/apmckinlay/gsuneido/dbms/commands"
 	"github.com/apmckinlay/gsuneido/dbms/mux"
+	qry "github.com/apmckinlay/gsuneido/dbms/query"
 	"github.com/apmckinlay/gsuneido/options"
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
+	"github.com/apmckinlay/gsuneido/util/jsonlog"
+	"github.com/apmckinlay/gsuneido/util/metric"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/str"
 	"golang.org/x/time/rate"
 )
@@ -40,6 +52,7 @@ var lastNum atomic.Int64 // used for queries, cursors
 type serverConn struct {
 	dbms       IDbms
 	conn       net.Conn
+	msc        *mux.ServerConn
 	sessions   map[uint32]*serverSession // the sessions on this connection
 	remoteAddr string
 	Sviews
@@ -48,6 +61,10 @@ type serverConn struct {
 	logSize      atomic.Int32 // cumulative size of logged data in bytes
 	nonce        string       // for authentication, shared across sessions
 	nonceOld     bool         // for two-phase expiration like tokens
+	resumeKey    string       // guarded by serverConnsLock, see cmdResume
+	notifyLock   sync.Mutex   // guards notifyNames and notifying
+	notifyNames  []string     // pending LibChanged names
+	notifying    bool         // whether a notifier goroutine is running
 	// id is primarily used as a key to store the set of connections in a map
 	id uint32
 }
@@ -55,7 +72,9 @@ type serverConn struct {
 // serverSession handles one client session.
 // It should be thread contained, other than sessionId
 type serverSession struct {
-	sc            *serverConn
+	sc *serverConn
+	// lock is held while handling a request, see abortUpdates
+	lock          sync.Mutex
 	*mux.WriteBuf         // set per request
 	thread        *Thread // set per request
 	trans         map[int]ITran
@@ -74,6 +93,8 @@ type intSet map[int]struct{}
 // Server listens and accepts connections. It never returns.
 func Server(dbms *DbmsLocal) {
 	workers = mux.NewWorkers(doRequest)
+	workers.SetLimits(options.MaxWorkers, options.MaxQueue)
+	serverTls = serverTlsConfig()
 	l, err := net.Listen("tcp", ":"+options.Port)
 	if err != nil {
 		Fatal(err)
@@ -98,6 +119,7 @@ func background() {
 		idleCheck()
 		expireTokens()
 		expireNonces()
+		expireResumable()
 	}
 }
 
@@ -135,8 +157,9 @@ func (sc *serverConn) serverLog(args ...any) {
 
 func newServerConn(dbms *DbmsLocal, conn net.Conn) {
 	trace.ClientServer.Println("server connection")
-	conn.Write(hello())
-	if errmsg := checkHello(conn); errmsg != "" {
+	conn.Write(hello(helloFlags(serverTls != nil)))
+	flags, errmsg := checkHello(conn)
+	if errmsg != "" {
 		if strings.HasPrefix(errmsg, "version mismatch") {
 			serverVersionMismatch(dbms, conn)
 		}
@@ -144,9 +167,25 @@ func newServerConn(dbms *DbmsLocal, conn net.Conn) {
 		return
 	}
 	addr := str.BeforeLast(conn.RemoteAddr().String(), ":") // strip port
+	if serverTls != nil {
+		var err error
+		if flags&helloTls == 0 {
+			conn.Close()
+			err = errors.New("client does not support TLS")
+		} else {
+			conn, err = tlsHandshake(tls.Server(conn, serverTls))
+		}
+		if err != nil {
+			log.Println("dbms server:", addr+":", "tls:", err)
+			return
+		}
+	}
 	msc := mux.NewServerConn(conn)
-	sc := &serverConn{dbms: dbms, id: msc.Id(), conn: conn, remoteAddr: addr,
-		sessions: make(map[uint32]*serverSession)}
+	if flags&helloZip != 0 && options.Compress > 0 {
+		msc.SetCompress(options.Compress)
+	}
+	sc := &serverConn{dbms: dbms, id: msc.Id(), conn: conn, msc: msc,
+		remoteAddr: addr, sessions: make(map[uint32]*serverSession)}
 	if dbms.db.HaveUsers() {
 		sc.dbms = &DbmsUnauth{dbms: dbms}
 	}
@@ -174,6 +213,10 @@ func doRequest(wb *mux.WriteBuf, th *Thread, id uint64, req []byte) {
 	serverConnsLock.Lock()
 	if req == nil { // closing, e.g. error or lost connection in mux reader
 		// log.Println("dbms server: nil request (closing)")
+		if sc := serverConns[connId]; sc != nil && sc.resumeKey != "" {
+			resumable[sc.resumeKey] =
+				resumeState{dbms: sc.dbms, sc: sc, lost: time.Now()}
+		}
 		delete(serverConns, connId)
 		serverConnsLock.Unlock()
 		return
@@ -211,20 +254,25 @@ func doRequest(wb *mux.WriteBuf, th *Thread, id uint64, req []byte) {
 	th.SetSession(ss.sessionId.Load())
 	th.SetSviews(&sc.Sviews)
 	ss.thread = th
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
 	ss.request()
 }
 
 func (ss *serverSession) request() {
 	var icmd commands.Command
+	var span *otel.Span
 	defer func() {
 		if e := recover(); e != nil {
 			LogInternalError(ss.thread, ss.sessionId.Load(), e)
+			span.SetError(errToStr(e))
 			ss.ResetWrite()
 			ss.PutBool(false).PutStr(errToStr(e)).EndMsg()
 		}
+		ss.thread.EndSpan(span)
 	}()
 	icmd = ss.GetCmd()
-	if int(icmd) >= len(cmds) {
+	if int(icmd) >= len(cmds) || cmds[icmd] == nil {
 		serverConnsLock.Lock()
 		defer serverConnsLock.Unlock()
 		ss.sc.close()
@@ -232,6 +280,11 @@ func (ss *serverSession) request() {
 		return
 	}
 	cmd := cmds[icmd]
+	span = ss.thread.StartSpan("dbms."+icmd.String(), otel.Server)
+	span.SetAttr("session", ss.sessionId.Load())
+	defer func(t time.Time) {
+		cmdSeconds.Observe(icmd.String(), time.Since(t).Seconds())
+	}(time.Now())
 	cmd(ss)
 	assert.That(ss.Remaining() == 0) // should consume entire message
 	if icmd != commands.EndSession {
@@ -239,6 +292,10 @@ func (ss *serverSession) request() {
 	}
 }
 
+var cmdSeconds = metric.NewHistogramVec("command", metric.LatencyBounds...)
+var _ = metric.Register("suneido_dbms_command_seconds",
+	"Duration of client requests by command", cmdSeconds)
+
 func errToStr(e any) string {
 	if t, ok := e.(interface{ ToStr() (string, bool) }); ok {
 		if s, ok := t.ToStr(); ok {
@@ -266,6 +323,25 @@ func (ss *serverSession) abort() {
 	}
 }
 
+// abortUpdates aborts the update transactions of a lost connection
+// so they don't hold their reads and writes until they time out.
+// It waits for requests that are still in progress.
+func (sc *serverConn) abortUpdates() {
+	sc.sessionsLock.Lock()
+	sessions := slices.Collect(maps.Values(sc.sessions))
+	sc.sessionsLock.Unlock()
+	for _, ss := range sessions {
+		ss.lock.Lock()
+		for tn, tran := range ss.trans {
+			if tn%2 == 1 { // update
+				tran.Abort()
+				ss.deleteTran(tn)
+			}
+		}
+		ss.lock.Unlock()
+	}
+}
+
 // close is called by idleCheck and bad request. MUST hold serverConnsLock
 func (sc *serverConn) close() {
 	trace.ClientServer.Println("closing connection")
@@ -277,6 +353,134 @@ func (sc *serverConn) close() {
 	// and update transactions will time out
 }
 
+// libNotify queues library change notifications so that commits
+// do not wait for writing to the clients
+var libNotify struct {
+	lock    sync.Mutex
+	names   []string      // pending, guarded by lock
+	signal  chan struct{} // buffered, one signal is enough
+	started bool          // guarded by lock
+}
+
+// notifyTimeout is how long to wait for a client to accept a notification.
+// Connections that take longer are closed.
+// It is a variable so tests can shorten it.
+var notifyTimeout = 10 * time.Second
+
+// notifyLibChanged queues telling the connected clients to unload
+// library records changed by a committed transaction
+func notifyLibChanged(names []string) {
+	libNotify.lock.Lock()
+	defer libNotify.lock.Unlock()
+	for _, name := range names {
+		if !slices.Contains(libNotify.names, name) {
+			libNotify.names = append(libNotify.names, name)
+		}
+	}
+	if !libNotify.started {
+		libNotify.started = true
+		libNotify.signal = make(chan struct{}, 1)
+		go libNotifier()
+	}
+	select {
+	case libNotify.signal <- struct{}{}:
+	default:
+	}
+}
+
+// libNotifier passes the queued notifications to the connections.
+// Each connection sends independently so a slow client does not delay others.
+func libNotifier() {
+	for range libNotify.signal {
+		libNotify.lock.Lock()
+		names := libNotify.names
+		libNotify.names = nil
+		libNotify.lock.Unlock()
+		if len(names) == 0 {
+			continue
+		}
+		serverConnsLock.Lock()
+		for _, sc := range serverConns {
+			sc.queueLibChanged(names)
+		}
+		serverConnsLock.Unlock()
+	}
+}
+
+// queueLibChanged adds names to the connection's pending notification
+// and starts a goroutine to send it if one is not already running
+func (sc *serverConn) queueLibChanged(names []string) {
+	sc.notifyLock.Lock()
+	defer sc.notifyLock.Unlock()
+	for _, name := range names {
+		if !slices.Contains(sc.notifyNames, name) {
+			sc.notifyNames = append(sc.notifyNames, name)
+		}
+	}
+	if !sc.notifying {
+		sc.notifying = true
+		go sc.libNotifier()
+	}
+}
+
+// libNotifier sends the pending notifications for one connection.
+// If the connection is closed, notifying is left set so no more are sent.
+func (sc *serverConn) libNotifier() {
+	for {
+		sc.notifyLock.Lock()
+		names := sc.notifyNames
+		sc.notifyNames = nil
+		if len(names) == 0 {
+			sc.notifying = false
+		}
+		sc.notifyLock.Unlock()
+		if len(names) == 0 {
+			return
+		}
+		if !sc.notify(func(wb *mux.WriteBuf) {
+			wb.PutCmd(commands.LibChanged).PutStrs(names)
+		}) {
+			return
+		}
+	}
+}
+
+// notify sends a notification to the client,
+// closing the connection if it does not complete within notifyTimeout.
+// It returns false if the connection was closed.
+func (sc *serverConn) notify(fn func(wb *mux.WriteBuf)) bool {
+	done := make(chan struct{})
+	go func() {
+		sc.msc.Notify(fn)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(notifyTimeout):
+		log.Println("closing connection that is not accepting notifications",
+			sc.remoteAddr)
+		serverConnsLock.Lock()
+		sc.close()
+		serverConnsLock.Unlock()
+		return false
+	}
+}
+
+// WorkerStatus returns html for the status page
+func WorkerStatus() string {
+	if workers == nil {
+		return ""
+	}
+	n, idle, queued, max := workers.Status()
+	limit := "unlimited"
+	if max > 0 {
+		limit = "max " + strconv.Itoa(max)
+	}
+	return fmt.Sprintf("<p>Workers: %d busy, %d idle (%s), %d queued</p>\n",
+		n-idle, idle, limit, queued)
+}
+
 // Conns is used by HttpStatus
 func Conns() string {
 	var sb strings.Builder
@@ -402,6 +606,63 @@ func (ss *serverSession) auth(s string) bool {
 	return AuthToken(s)
 }
 
+// resumable holds the state of lost connections
+// so a client can resume with a new connection. Guarded by serverConnsLock.
+var resumable = make(map[string]resumeState)
+
+type resumeState struct {
+	dbms IDbms // DbmsUnauth if the connection was not authorized
+	sc   *serverConn
+	lost time.Time
+}
+
+const resumeTimeout = 5 * time.Minute
+
+func expireResumable() {
+	serverConnsLock.Lock()
+	defer serverConnsLock.Unlock()
+	for key, rs := range resumable {
+		if time.Since(rs.lost) > resumeTimeout {
+			delete(resumable, key)
+		}
+	}
+}
+
+// cmdResume takes the authorization from a lost connection, if it is held.
+// The previous sessions, transactions, and cursors are not resumed.
+// It returns a new key to resume this connection.
+func cmdResume(ss *serverSession) {
+	key := ss.GetStr()
+	var buf [16]byte
+	rand.Read(buf[:])
+	serverConnsLock.Lock()
+	rs, resumed := resumable[key]
+	if resumed {
+		delete(resumable, key)
+	} else if key != "" {
+		// the server may not have noticed the lost connection yet
+		for _, sc := range serverConns {
+			if sc.resumeKey == key && sc != ss.sc {
+				rs, resumed = resumeState{dbms: sc.dbms, sc: sc}, true
+				sc.close()
+				break
+			}
+		}
+	}
+	if resumed {
+		ss.sc.dbms = rs.dbms
+	}
+	ss.sc.resumeKey = hex.EncodeToString(buf[:])
+	newKey := ss.sc.resumeKey
+	serverConnsLock.Unlock()
+	if resumed {
+		// the client reports its update transactions as conflicts
+		rs.sc.abortUpdates()
+		ss.sc.serverLog("resumed connection")
+	}
+	ss.PutBool(true).PutBool(resumed).PutStr(newKey)
+}
+
 func cmdAsof(ss *serverSession) {
 	tn := ss.GetInt()
 	asof := ss.GetInt64()
@@ -531,6 +792,69 @@ func (ss *serverSession) getQorTC() (tbl string, hdr *Header, row Row) {
 	return
 }
 
+// cmdGetMany returns a batch of rows, for client read-ahead.
+// First, skip rows are read in the back direction and discarded.
+// This is used by the client to step back over rows it read ahead
+// but did not use.
+// A batch is at least one row, at most n rows,
+// and at most maxBatch bytes (unless it is a single row).
+func cmdGetMany(ss *serverSession) {
+	dir := ss.getDir()
+	var hdr *Header
+	var get func(dir Dir) (Row, string)
+	if t, _ := ss.getTran(); t == nil {
+		q := ss.getQuery()
+		hdr = q.Header()
+		get = func(dir Dir) (Row, string) { return q.Get(ss.thread, dir) }
+	} else {
+		c := ss.getCursor()
+		hdr = c.Header()
+		get = func(dir Dir) (Row, string) { return c.Get(ss.thread, t, dir) }
+	}
+	back := Dir(ss.GetByte())
+	skip := ss.GetInt()
+	n := ss.GetInt()
+	sendHdr := ss.GetBool()
+	for ; skip > 0; skip-- {
+		if row, _ := get(back); row == nil {
+			break
+		}
+	}
+	type batchRow struct {
+		tbl string
+		rec Record
+		off uint64
+	}
+	rows := make([]batchRow, 0, min(n, 64))
+	size := 0
+	eof := false
+	for len(rows) < n {
+		row, tbl := get(dir)
+		if row == nil {
+			eof = true
+			break
+		}
+		rec, _ := rowToRecord(row, hdr)
+		if len(rec) > maxRec {
+			panic("result too large")
+		}
+		if len(rows) > 0 && size+len(rec) > maxBatch {
+			get(dir.Reverse()) // leave it for the next batch
+			break
+		}
+		rows = append(rows, batchRow{tbl: tbl, rec: rec, off: row[0].Off})
+		size += len(rec)
+	}
+	ss.PutBool(true)
+	if sendHdr {
+		ss.PutStrs(hdr.Schema())
+	}
+	ss.PutBool(eof).PutInt(len(rows))
+	for _, r := range rows {
+		ss.PutInt(int(r.off)).PutStr(r.tbl).PutRec(r.rec)
+	}
+}
+
 func (ss *serverSession) getDir() Dir {
 	dir := Dir(ss.GetByte())
 	trace.ClientServer.Println("    <-", string(dir))
@@ -539,6 +863,8 @@ func (ss *serverSession) getDir() Dir {
 
 const maxRec = 1024 * 1024 // 1 mb
 
+const maxBatch = 256 * 1024
+
 func (ss *serverSession) rowResult(tbl string, hdr *Header, sendHdr bool, row Row) {
 	if row == nil {
 		ss.PutBool(true).PutBool(false)
@@ -706,7 +1032,12 @@ func cmdLibraries(ss *serverSession) {
 func cmdLog(ss *serverSession) {
 	s := ss.GetStr()
 	if msg := ss.sc.limitLog(s); msg != "" {
-		ss.sc.dbms.Log(msg)
+		if _, unauth := ss.sc.dbms.(*DbmsUnauth); jsonlog.On() && !unauth {
+			jsonlog.Log(slog.LevelInfo, msg, "source", "client",
+				"session", ss.sessionId.Load())
+		} else {
+			ss.sc.dbms.Log(msg)
+		}
 	}
 	ss.PutBool(true) // return true regardless
 }
@@ -745,6 +1076,30 @@ func cmdOutput(ss *serverSession) {
 	ss.PutBool(true)
 }
 
+// cmdOutputMany outputs a batch of records.
+// It returns the errors for the records that failed, with their index.
+func cmdOutputMany(ss *serverSession) {
+	tran, _ := ss.getTran()
+	table := ss.GetStr()
+	recs := make([]Record, ss.GetInt())
+	for i := range recs {
+		recs[i] = ss.GetRec()
+	}
+	errs := tran.OutputMany(ss.thread, table, recs)
+	nerrs := 0
+	for _, e := range errs {
+		if e != "" {
+			nerrs++
+		}
+	}
+	ss.PutBool(true).PutInt(nerrs)
+	for i, e := range errs {
+		if e != "" {
+			ss.PutInt(i).PutStr(e)
+		}
+	}
+}
+
 func (ss *serverSession) getQuery() IQuery {
 	qn := ss.GetInt()
 	q := ss.queries[qn]
@@ -766,7 +1121,14 @@ func (ss *serverSession) getCursor() ICursor {
 func cmdQuery(ss *serverSession) {
 	tran, tn := ss.getTran()
 	query := ss.GetStr()
+	st := slowStart(tran)
 	q := tran.Query(query, &ss.sc.Sviews)
+	st.check(ss.thread, "Query", query,
+		func() string { return q.Strategy(false) })
+	ss.PutBool(true).PutInt(ss.addQuery(q, tn))
+}
+
+func (ss *serverSession) addQuery(q IQuery, tn int) int {
 	qn := int(lastNum.Add(1))
 	ss.queries[qn] = q
 	ss.queryTrans[qn] = tn
@@ -777,7 +1139,27 @@ func cmdQuery(ss *serverSession) {
 	if len(ss.queries) != len(ss.queryTrans) {
 		log.Println("ERROR: cmdQuery", len(ss.queries), "!=", len(ss.queryTrans))
 	}
-	ss.PutBool(true).PutInt(qn)
+	return qn
+}
+
+func cmdPrepare(ss *serverSession) {
+	query := ss.GetStr()
+	ss.PutBool(true).PutInt(ss.sc.dbms.Prepare(query))
+}
+
+func cmdQueryPrepared(ss *serverSession) {
+	tran, tn := ss.getTran()
+	handle := ss.GetInt()
+	params := make(map[string]Value)
+	for n := ss.GetInt(); n > 0; n-- {
+		name := ss.GetStr()
+		params[name] = ss.GetVal()
+	}
+	st := slowStart(tran)
+	q := tran.QueryPrepared(handle, params, &ss.sc.Sviews)
+	st.check(ss.thread, "Query", qry.GetPrepared(handle).String(),
+		func() string { return q.Strategy(false) })
+	ss.PutBool(true).PutInt(ss.addQuery(q, tn))
 }
 
 func cmdReadCount(ss *serverSession) {
@@ -897,9 +1279,15 @@ var cmds = []command{ // order must match commmands.go
 	cmdWriteCount,
 	cmdEndSession,
 	cmdAsof,
-	nil,
+	nil, // LibChanged is only sent by the server
+	cmdResume,
+	cmdPrepare,
+	cmdQueryPrepared,
+	cmdGetMany,
+	cmdOutputMany,
 }
 
 func init() {
-	assert.That(cmds[commands.Asof] != nil && cmds[commands.Asof+1] == nil)
+	assert.That(cmds[commands.Asof] != nil && cmds[commands.LibChanged] == nil)
+	assert.That(len(cmds) == int(commands.OutputMany)+1)
 }
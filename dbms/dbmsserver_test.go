@@ -4,9 +4,13 @@
 package dbms
 
 import (
+	"net"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/apmckinlay/gsuneido/dbms/commands"
+	"github.com/apmckinlay/gsuneido/dbms/mux"
 	"github.com/apmckinlay/gsuneido/util/assert"
 )
 
@@ -157,3 +161,49 @@ func TestNonceExpirationLifecycle(t *testing.T) {
 	assert.T(t).This(sc.nonce).Is("")
 	assert.T(t).This(sc.nonceOld).Is(false)
 }
+
+func TestNotifyLibChanged(t *testing.T) {
+	defer func(prev time.Duration) { notifyTimeout = prev }(notifyTimeout)
+	notifyTimeout = 300 * time.Millisecond
+	stalled, stalled2 := net.Pipe() // nothing reads stalled2
+	defer stalled2.Close()
+	s1, c1 := net.Pipe()
+	defer c1.Close()
+	client := mux.NewClientConn(c1)
+	ch := make(chan string, 1)
+	client.OnNotify(func(data []byte) { ch <- string(data) })
+	client.OnLost(func(string) {})
+	serverConnsLock.Lock()
+	serverConns[1001] = &serverConn{conn: stalled,
+		msc: mux.NewServerConn(stalled), id: 1001}
+	sc := &serverConn{conn: s1, msc: mux.NewServerConn(s1), id: 1002}
+	serverConns[1002] = sc
+	serverConnsLock.Unlock()
+	defer func() {
+		serverConnsLock.Lock()
+		delete(serverConns, 1001)
+		delete(serverConns, 1002)
+		serverConnsLock.Unlock()
+		s1.Close()
+	}()
+
+	// the stalled connection should not delay the other one
+	for _, name := range []string{"Foo", "Bar"} {
+		notifyLibChanged([]string{name}) // should not block
+		select {
+		case data := <-ch:
+			assert.T(t).This(commands.Command(data[0])).
+				Is(commands.LibChanged)
+		case <-time.After(notifyTimeout / 2):
+			t.Fatal("notification not received")
+		}
+	}
+	time.Sleep(notifyTimeout * 2)
+	serverConnsLock.Lock()
+	_, ok := serverConns[1001]
+	serverConnsLock.Unlock()
+	assert.T(t).That(!ok) // closed
+	sc.notifyLock.Lock()
+	assert.T(t).That(!sc.notifying)
+	sc.notifyLock.Unlock()
+}
@@ -99,6 +99,10 @@ func (du *DbmsUnauth) Nonce(th *Thread) string {
 	return du.dbms.Nonce(th)
 }
 
+func (du *DbmsUnauth) Prepare(string) int {
+	panic(notauth)
+}
+
 func (du *DbmsUnauth) Run(*Thread, string) Value {
 	panic(notauth)
 }
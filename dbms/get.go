@@ -14,12 +14,34 @@ import (
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/generic/set"
 	"github.com/apmckinlay/gsuneido/util/generic/slc"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/str"
 )
 
 var slow = map[Dir]int{Only: 100, Any: 2000}
 
 func get(th *Thread, tran qry.QueryTran, args Value, dir Dir) (Row, *Header, string) {
+	span := th.StartSpan(dir.String(), otel.Internal)
+	defer th.EndSpan(span)
+	if span != nil {
+		span.SetAttr("query", getQuery(args.(*SuObject)))
+	}
+	st := slowStart(tran)
+	var strategy *string
+	if st.enabled() && dir != Strat {
+		strategy = new(string)
+	}
+	row, hdr, s := get1(th, tran, args, dir, strategy)
+	if strategy != nil {
+		st.check(th, dir.String(), getQuery(args.(*SuObject)),
+			func() string { return *strategy })
+	}
+	return row, hdr, s
+}
+
+// get1 does the Get. If strategy is not nil it is set to the strategy used.
+func get1(th *Thread, tran qry.QueryTran, args Value, dir Dir,
+	strategy *string) (Row, *Header, string) {
 	defer th.Suneido.Store(th.Suneido.Load())
 	th.Suneido.Store(nil) // use main Suneido object
 
@@ -32,7 +54,10 @@ func get(th *Thread, tran qry.QueryTran, args Value, dir Dir) (Row, *Header, str
 	if dir == Only || dir == Any ||
 		(dir == Strat && qry.GetSort(query) == "") {
 		query = qry.StripSort(query)
-		if row, hdr, s := fastGet(th, tran, query, ob, dir); hdr != nil {
+		if row, hdr, s, strat := fastGet(th, tran, query, ob, dir); hdr != nil {
+			if strategy != nil {
+				*strategy = strat
+			}
 			return row, hdr, s
 		}
 	}
@@ -65,6 +90,9 @@ func get(th *Thread, tran qry.QueryTran, args Value, dir Dir) (Row, *Header, str
 		d = Next
 	}
 	row := q.Get(th, d)
+	if strategy != nil {
+		*strategy = qry.String(q)
+	}
 	if dir == Only || dir == Any {
 		if w, ok := q.(*qry.Where); ok && w.InCount() > slow[dir] &&
 			!(strings.HasPrefix(query, "columns") ||
@@ -97,7 +125,8 @@ func getQuery(ob *SuObject) string {
 }
 
 // fastGet returns a nil Header to indicate it was not applicable
-func fastGet(th *Thread, tran qry.QueryTran, query string, ob *SuObject, dir Dir) (row Row, hdr *Header, strarg string) {
+func fastGet(th *Thread, tran qry.QueryTran, query string, ob *SuObject,
+	dir Dir) (row Row, hdr *Header, strarg, strat string) {
 	strarg = query
 	table := qry.JustTable(query)
 	if table == "" || tran.GetInfo(table) == nil { // could be a view
@@ -124,23 +153,23 @@ func fastGet(th *Thread, tran qry.QueryTran, query string, ob *SuObject, dir Dir
 	case Strat:
 		_, strarg, _ = getIndex(th, tran, tbl, flds, packed, dir)
 		if strarg == "" {
-			return nil, nil, ""
+			return nil, nil, "", ""
 		}
-		return existsRow, existsHdr, strarg
+		return existsRow, existsHdr, strarg, strarg
 	case Only:
-		row, hdr = getLookup(th, tran, tbl, flds, packed, dir)
+		row, hdr, strat = getLookup(th, tran, tbl, flds, packed, dir)
 		return
 	case Any:
-		row, hdr = getExists(th, tran, tbl, flds, packed, dir)
+		row, hdr, strat = getExists(th, tran, tbl, flds, packed, dir)
 		return
 	}
 	panic(assert.ShouldNotReachHere())
 }
 
-func getLookup(th *Thread, tran qry.QueryTran, table *qry.Table, flds, vals []string, dir Dir) (Row, *Header) {
-	single, _, getfn := getIndex(th, tran, table, flds, vals, dir)
+func getLookup(th *Thread, tran qry.QueryTran, table *qry.Table, flds, vals []string, dir Dir) (Row, *Header, string) {
+	single, strat, getfn := getIndex(th, tran, table, flds, vals, dir)
 	if getfn == nil {
-		return nil, nil
+		return nil, nil, ""
 	}
 	row := getfn()
 	if row != nil && !single {
@@ -148,19 +177,18 @@ func getLookup(th *Thread, tran qry.QueryTran, table *qry.Table, flds, vals []st
 			panic("Query1 not unique")
 		}
 	}
-	return row, table.Header()
+	return row, table.Header(), strat
 }
 
-func getExists(th *Thread, tran qry.QueryTran, table *qry.Table, flds, vals []string, dir Dir) (row Row, hdr *Header) {
-	_, _, getfn := getIndex(th, tran, table, flds, vals, dir)
+func getExists(th *Thread, tran qry.QueryTran, table *qry.Table, flds, vals []string, dir Dir) (Row, *Header, string) {
+	_, strat, getfn := getIndex(th, tran, table, flds, vals, dir)
 	if getfn == nil {
-		return nil, nil
+		return nil, nil, ""
 	}
-	row = getfn()
-	if row != nil {
-		return existsRow, existsHdr
+	if getfn() != nil {
+		return existsRow, existsHdr, strat
 	}
-	return nil, existsHdr
+	return nil, existsHdr, strat
 }
 
 func getIndex(th *Thread, tran qry.QueryTran, table *qry.Table,
@@ -8,7 +8,6 @@ import (
 	"io"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/apmckinlay/gsuneido/options"
@@ -17,43 +16,68 @@ import (
 
 const helloSize = 50
 
-var helloBuf [helloSize]byte
-var helloOnce sync.Once
+// The last two bytes of hello are a marker (that can't be text)
+// followed by flags for the features this side supports or requires.
+// Older versions will have text or zero there.
+const (
+	helloMarker = 0xff
+	helloTls    = 1 // the server requires TLS, the client supports it
+	helloZip    = 2 // compression of large messages
+	helloTrace  = 4 // the server accepts requests with trace context
+)
 
 // hello returns the initial connection message.
 // Both the client and the server send and receive/check this message.
-func hello() []byte {
-	helloOnce.Do(func() {
-		copy(helloBuf[:], "Suneido "+options.BuiltStr()+"\r\n")
-	})
-	return helloBuf[:]
+func hello(flags byte) []byte {
+	var buf [helloSize]byte
+	copy(buf[:helloSize-2], "Suneido "+options.BuiltStr()+"\r\n")
+	buf[helloSize-2] = helloMarker
+	buf[helloSize-1] = flags
+	return buf[:]
+}
+
+// helloFlags returns the flags for hello.
+// Clients always support TLS, servers only require it if it's configured.
+func helloFlags(tls bool) byte {
+	var flags byte
+	if tls {
+		flags |= helloTls
+	}
+	if options.Compress > 0 {
+		flags |= helloZip
+	}
+	return flags | helloTrace
 }
 
 const helloTimeout = 500 * time.Millisecond
 
-// checkHello is used by both the client and the server
-func checkHello(conn net.Conn) string {
+// checkHello is used by both the client and the server.
+// It returns the flags from the other side.
+func checkHello(conn net.Conn) (byte, string) {
 	var buf [helloSize]byte
 	conn.SetReadDeadline(time.Now().Add(helloTimeout))
 	n, err := io.ReadFull(conn, buf[:])
 	var never time.Time
 	conn.SetReadDeadline(never)
 	if n == 0 {
-		return "hello: timeout"
+		return 0, "hello: timeout"
 	}
 	if n != helloSize || err != nil {
-		return "hello: invalid response"
+		return 0, "hello: invalid response"
 	}
 	s := string(buf[:])
 	if !strings.HasPrefix(s, "Suneido ") {
-		return "hello: invalid response"
+		return 0, "hello: invalid response"
 	}
 	s = strings.TrimPrefix(s, "Suneido ")
 	if noTime(s) != noTime(options.BuiltDate) && !options.IgnoreVersion {
-		return fmt.Sprintf("version mismatch (got %s, want %s)",
+		return 0, fmt.Sprintf("version mismatch (got %s, want %s)",
 			noTime(s), noTime(options.BuiltDate))
 	}
-	return ""
+	if buf[helloSize-2] != helloMarker {
+		return 0, ""
+	}
+	return buf[helloSize-1], ""
 }
 
 func noTime(s string) string {
@@ -13,18 +13,46 @@ import (
 
 	"github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/core/trace"
+	"github.com/apmckinlay/gsuneido/dbms/commands"
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
 	"github.com/apmckinlay/gsuneido/util/generic/slc"
+	"github.com/apmckinlay/gsuneido/util/otel"
+	"github.com/klauspost/compress/zstd"
 )
 
 const HeaderSize = 4 + 4 + 1 /* size + id + final */
 
+// final byte flags
+const (
+	notFinal   = 0
+	final      = 1 // the last part of a message
+	traced     = 2 // the message starts with an otel.Context (only with final)
+	compressed = 4 // this part of the message is zstd compressed
+)
+
 type conn struct {
-	rw    io.ReadWriteCloser // the underlying connection
-	err   atomics.String
-	wlock sync.Mutex       // used by write to keep header and data together
-	hdr   [HeaderSize]byte // used by write, guarded by wlock
+	rw       io.ReadWriteCloser // the underlying connection
+	err      atomics.String
+	wlock    sync.Mutex       // used by write to keep header and data together
+	hdr      [HeaderSize]byte // used by write, guarded by wlock
+	zbuf     []byte           // used by write, guarded by wlock
+	compress int              // the minimum size to compress, 0 for none
+	trace    bool             // whether to send trace context
+}
+
+// SetCompress enables compression of writes of at least minSize bytes.
+// It should only be used if the other side has agreed (see dbms hello)
+// and it must be called before the connection is used.
+func (c *conn) SetCompress(minSize int) {
+	c.compress = minSize
+}
+
+// SetTrace enables sending the trace context with requests.
+// It should only be used if the other side has agreed (see dbms hello)
+// and it must be called before the connection is used.
+func (c *conn) SetTrace() {
+	c.trace = true
 }
 
 func (c *conn) Close() {
@@ -35,28 +63,37 @@ func (c *conn) Close() {
 }
 
 type ClientConn struct {
-	rchs map[uint32]respch // response channel per id, guarded by lock
+	rchs   map[uint32]respch // response channel per id, guarded by lock
+	notify func([]byte)      // guarded by lock
+	onLost func(err string)  // guarded by lock
+	done   chan struct{}     // closed when the connection is lost
 	conn
 	lock        sync.Mutex
 	nextSession atomic.Uint32 // the next session id
 }
 
+// notifyId is the session id used for messages from the server
+// that are not responses to a request. Client sessions start at 1.
+const notifyId = 0
+
 type respch chan []byte
 
 // NewClientConn creates a new client connection.
 // This should be one to one with the underlying connection.
 func NewClientConn(rw io.ReadWriteCloser) *ClientConn {
-	m := ClientConn{conn: conn{rw: rw}, rchs: make(map[uint32]respch)}
+	m := ClientConn{conn: conn{rw: rw}, rchs: make(map[uint32]respch),
+		done: make(chan struct{})}
 	go m.conn.reader(m.client)
 	return &m
 }
 
 type ServerConn struct {
 	conn
-	id uint32
+	nlock sync.Mutex // keeps notify messages from interleaving
+	id    uint32
 }
 
-type handler func(c *conn, id uint64, r []byte)
+type handler func(c *conn, id uint64, r []byte, span otel.Context)
 
 var nextServerConn atomic.Uint32
 
@@ -73,15 +110,54 @@ func (sc *ServerConn) Id() uint32 {
 }
 
 func (sc *ServerConn) Run(h handler) {
-	sc.conn.reader(func(sessionId uint32, data []byte) {
-		h(&sc.conn, uint64(sc.id)<<32|uint64(sessionId), data)
+	sc.conn.reader(func(sessionId uint32, data []byte, span otel.Context) {
+		h(&sc.conn, uint64(sc.id)<<32|uint64(sessionId), data, span)
 	})
 }
 
+// Notify sends a message to the client that is not a response.
+// fn should write the message, Notify does EndMsg.
+func (sc *ServerConn) Notify(fn func(wb *WriteBuf)) {
+	sc.nlock.Lock()
+	defer sc.nlock.Unlock()
+	wb := newWriteBuf(&sc.conn, notifyId)
+	fn(wb)
+	wb.EndMsg()
+}
+
+// OnNotify sets the function to call with messages from ServerConn Notify.
+// It is called from the reader goroutine so it should not block.
+func (cc *ClientConn) OnNotify(fn func(data []byte)) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.notify = fn
+}
+
+// OnLost sets the function to call if the connection is lost.
+// Requests that are waiting, or made after that, will panic.
+// If it is not set, losing the connection is fatal.
+func (cc *ClientConn) OnLost(fn func(err string)) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.onLost = fn
+}
+
+// Lost returns whether the connection has been lost
+func (cc *ClientConn) Lost() bool {
+	select {
+	case <-cc.done:
+		return true
+	default:
+		return false
+	}
+}
+
 type ClientSession struct {
 	cc  *ClientConn
 	rch respch
 	ReadWrite
+	spanSource func() otel.Context
+	span       *otel.Span // the span for the current request
 }
 
 // NewClientSession returns a new ClientSession
@@ -95,8 +171,47 @@ func (cc *ClientConn) NewClientSession() *ClientSession {
 	return &ClientSession{cc: cc, rch: rch, ReadWrite: ReadWrite{WriteBuf: *wb}}
 }
 
+// read returns the response, or nil if the connection is lost
 func (cs *ClientSession) read() []byte {
-	return <-cs.rch
+	select {
+	case data := <-cs.rch:
+		return data
+	case <-cs.cc.done:
+		// the reader has stopped, but it may have sent a response first
+		select {
+		case data := <-cs.rch:
+			return data
+		default:
+			return nil
+		}
+	}
+}
+
+// LostConnection is the panic from Request if the connection is lost
+const LostConnection = "lost connection to server"
+
+// SetSpanSource sets the function used to get the parent span for requests
+func (cs *ClientSession) SetSpanSource(fn func() otel.Context) {
+	cs.spanSource = fn
+}
+
+// PutCmd starts a request.
+// If tracing is enabled it starts a client span
+// and sends its context with the request.
+func (cs *ClientSession) PutCmd(cmd commands.Command) *WriteBuf {
+	cs.span.End() // in case the previous command was not a Request
+	cs.span = nil
+	if otel.Enabled() && cmd != commands.EndSession {
+		var parent otel.Context
+		if cs.spanSource != nil {
+			parent = cs.spanSource()
+		}
+		cs.span = otel.Start(parent, "dbms."+cmd.String(), otel.Client)
+	}
+	if cs.cc.trace {
+		cs.WriteBuf.span = cs.span.SpanContext()
+	}
+	return cs.WriteBuf.PutCmd(cmd)
 }
 
 // Request is used by DbmsClient.
@@ -105,26 +220,52 @@ func (cs *ClientSession) read() []byte {
 func (cs *ClientSession) Request() {
 	cs.EndMsg()
 	cs.ReadBuf.buf = cs.read()
+	if cs.ReadBuf.buf == nil {
+		cs.span.SetError(LostConnection)
+		cs.endSpan()
+		panic(LostConnection)
+	}
 	if !cs.GetBool() {
 		err := cs.GetStr()
 		trace.ClientServer.Println(err)
+		cs.span.SetError(err)
+		cs.endSpan()
 		panic(err + " (from server)")
 	}
+	cs.endSpan()
+}
+
+func (cs *ClientSession) endSpan() {
+	cs.span.End()
+	cs.span = nil
 }
 
 // write is called by writeBuffer to send part of a message.
-// final should be true for the last write of a message.
+// fin should be true for the last write of a message.
 // If the sender can leave HeaderSize bytes of space at the start of data,
 // then it can pass hdrSpace = true, and header & data can be written together.
-func (c *conn) write(id uint32, data []byte, hdrSpace, final bool) {
+// trc should be true for the final write of a message
+// that starts with an otel.Context
+// If compression is enabled, large enough parts are compressed.
+func (c *conn) write(id uint32, data []byte, hdrSpace, fin, trc bool) {
 	c.wlock.Lock()
 	defer c.wlock.Unlock()
+	flags := byte(notFinal)
+	if fin {
+		flags = final
+		if trc {
+			flags |= traced
+		}
+	}
+	if c.compress > 0 {
+		data, hdrSpace, flags = c.compressData(data, hdrSpace, flags)
+	}
 	var err error
 	if hdrSpace {
-		c.putHdr(data, id, len(data)-HeaderSize, final)
+		c.putHdr(data, id, len(data)-HeaderSize, flags)
 		_, err = c.rw.Write(data)
 	} else {
-		c.putHdr(c.hdr[:], id, len(data), final)
+		c.putHdr(c.hdr[:], id, len(data), flags)
 		_, err = c.rw.Write(c.hdr[:])
 		if err == nil {
 			_, err = c.rw.Write(data)
@@ -137,14 +278,44 @@ func (c *conn) write(id uint32, data []byte, hdrSpace, final bool) {
 	}
 }
 
-func (*conn) putHdr(buf []byte, id uint32, size int, final bool) {
+// compressData returns the compressed data, with header space,
+// if it is large enough and compression actually makes it smaller.
+// It must be called with wlock held.
+func (c *conn) compressData(data []byte, hdrSpace bool, flags byte) (
+	[]byte, bool, byte) {
+	src := data
+	if hdrSpace {
+		src = data[HeaderSize:]
+	}
+	if len(src) < c.compress {
+		return data, hdrSpace, flags
+	}
+	c.zbuf = encoder().EncodeAll(src, slc.Grow(c.zbuf[:0], HeaderSize))
+	if len(c.zbuf)-HeaderSize >= len(src) {
+		return data, hdrSpace, flags
+	}
+	nCompressed.Add(1)
+	return c.zbuf, true, flags | compressed
+}
+
+var encoder = sync.OnceValue(func() *zstd.Encoder {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	return enc
+})
+
+var decoder = sync.OnceValue(func() *zstd.Decoder {
+	dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxMemory(maxSize))
+	return dec
+})
+
+var nCompressed atomic.Int64
+var _ = core.AddInfo("mux.nCompressed", &nCompressed)
+
+func (*conn) putHdr(buf []byte, id uint32, size int, flags byte) {
 	binary.BigEndian.PutUint32(buf, uint32(size))
 	binary.BigEndian.PutUint32(buf[4:], id)
-	if final {
-		buf[8] = 1
-	} else {
-		buf[8] = 0
-	}
+	buf[8] = flags
 }
 
 const maxSize = 1024 * 1024 // 1 mb
@@ -153,9 +324,10 @@ const maxSize = 1024 * 1024 // 1 mb
 // and calls handler when it has a complete message.
 // client and server have different handlers.
 // Any errors close the connection.
-func (c *conn) reader(handler func(uint32, []byte)) {
+func (c *conn) reader(handler func(uint32, []byte, otel.Context)) {
 	partial := make(map[uint32][]byte)
 	hdr := make([]byte, HeaderSize)
+	var zbuf []byte
 	for {
 		n, err := io.ReadFull(c.rw, hdr)
 		if err != nil {
@@ -165,38 +337,78 @@ func (c *conn) reader(handler func(uint32, []byte)) {
 		assert.That(n == HeaderSize)
 		size := int(binary.BigEndian.Uint32(hdr))
 		sessionId := binary.BigEndian.Uint32(hdr[4:])
+		flags := hdr[8]
+		if flags&^(final|traced|compressed) != 0 ||
+			flags&(final|traced) == traced {
+			c.err.Store("bad final byte")
+			break
+		}
 		buf := partial[sessionId] // nil (empty buf) if not found
 		i := len(buf)
 		if i+size > maxSize {
 			c.err.Store("message size greater than max")
 			break
 		}
-		buf = slc.Grow(buf, size)
-		n, err = io.ReadFull(c.rw, buf[i:])
+		if flags&compressed != 0 {
+			zbuf = slc.Grow(zbuf[:0], size)
+			_, err = io.ReadFull(c.rw, zbuf)
+			if err == nil {
+				buf, err = decoder().DecodeAll(zbuf, buf)
+			}
+			if err == nil && len(buf) > maxSize {
+				c.err.Store("message size greater than max")
+				break
+			}
+		} else {
+			buf = slc.Grow(buf, size)
+			_, err = io.ReadFull(c.rw, buf[i:])
+		}
 		if err != nil {
 			c.err.Store(err.Error())
 			break
 		}
-		assert.That(n == size)
-		if hdr[8] == 0 { //nolint
+		if flags&final == 0 {
 			partial[sessionId] = buf
-		} else if hdr[8] == 1 {
+		} else {
 			delete(partial, sessionId)
 			assert.That(buf != nil)
-			handler(sessionId, buf) // process message
-		} else {
-			c.err.Store("bad final byte")
-			break
+			var span otel.Context
+			if flags&traced != 0 {
+				if len(buf) < otel.ContextSize {
+					c.err.Store("bad trace context")
+					break
+				}
+				span = otel.Decode(buf)
+				buf = buf[otel.ContextSize:]
+			}
+			handler(sessionId, buf, span) // process message
 		}
 	}
-	handler(0, nil) // notify handler
+	handler(0, nil, otel.Context{}) // notify handler
 	c.Close()
 }
 
-func (cc *ClientConn) client(id uint32, data []byte) {
+func (cc *ClientConn) client(id uint32, data []byte, _ otel.Context) {
 	// need to send id for client to pipeline messages
 	if data == nil {
-		core.Fatal("lost connection:", cc.err.Load())
+		cc.lock.Lock()
+		fn := cc.onLost
+		cc.lock.Unlock()
+		if fn == nil {
+			core.Fatal("lost connection:", cc.err.Load())
+		}
+		close(cc.done)
+		fn(cc.err.Load())
+		return
+	}
+	if id == notifyId {
+		cc.lock.Lock()
+		fn := cc.notify
+		cc.lock.Unlock()
+		if fn != nil {
+			fn(data)
+		}
+		return
 	}
 	cc.getrch(id) <- data
 }
@@ -6,12 +6,17 @@ package mux
 import (
 	"bytes"
 	"net"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/dbms/commands"
 	"github.com/apmckinlay/gsuneido/util/assert"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/race"
 	"github.com/apmckinlay/gsuneido/util/str"
 )
@@ -51,3 +56,122 @@ func TestMux(t *testing.T) {
 	wg.Wait()
 	assert.T(t).This(n.Load()).Is(nmsgs * nthreads)
 }
+
+func TestNotify(t *testing.T) {
+	p1, p2 := net.Pipe()
+	client := NewClientConn(p1)
+	ch := make(chan string, 1)
+	client.OnNotify(func(data []byte) { ch <- string(data) })
+	msc := NewServerConn(p2)
+	go msc.Run(func(*conn, uint64, []byte, otel.Context) {})
+	msc.Notify(func(wb *WriteBuf) { wb.WriteString("hello") })
+	assert.T(t).This(<-ch).Is("hello")
+}
+
+func TestTraceContext(t *testing.T) {
+	otel.Enable(filepath.Join(t.TempDir(), "spans.json"), "test")
+	defer otel.Disable()
+	p1, p2 := net.Pipe()
+	client := NewClientConn(p1)
+	ch := make(chan otel.Context, 1)
+	workers := NewWorkers(func(wb *WriteBuf, th *core.Thread, _ uint64, data []byte) {
+		ch <- th.SpanContext()
+		assert.This(data).Is([]byte{byte(commands.Check)})
+		wb.PutBool(true).EndMsg()
+	})
+	msc := NewServerConn(p2)
+	go msc.Run(workers.Submit)
+	root := otel.Start(otel.Context{}, "root", otel.Internal)
+	session := client.NewClientSession()
+	session.SetSpanSource(root.SpanContext)
+
+	// not sent unless the server has agreed
+	session.PutCmd(commands.Check)
+	session.Request()
+	assert.T(t).That(!(<-ch).Valid())
+
+	client.SetTrace()
+	session.PutCmd(commands.Check)
+	cspan := session.span
+	session.Request()
+	got := <-ch
+	assert.T(t).This(got).Is(cspan.SpanContext())
+	assert.T(t).This(cspan.Parent()).Is(root.SpanContext())
+}
+
+func TestWorkersFairness(t *testing.T) {
+	ws := &Workers{queues: make(map[uint64][]task)}
+	for _, id := range []uint64{1, 1, 1, 2, 3, 3} {
+		ws.enqueue(task{id: id})
+	}
+	var order []uint64
+	for {
+		t, ok := ws.dequeue()
+		if !ok {
+			break
+		}
+		order = append(order, t.id)
+	}
+	assert.T(t).This(order).Is([]uint64{1, 2, 3, 1, 3, 1})
+	assert.T(t).This(ws.queued).Is(0)
+}
+
+func TestWorkersOverloaded(t *testing.T) {
+	p1, p2 := net.Pipe()
+	client := NewClientConn(p1)
+	block := make(chan struct{})
+	workers := NewWorkers(func(wb *WriteBuf, _ *core.Thread, _ uint64, _ []byte) {
+		<-block
+		wb.PutBool(true).EndMsg()
+	})
+	workers.SetLimits(1, 1)
+	msc := NewServerConn(p2)
+	go msc.Run(workers.Submit)
+	results := make(chan string, 3)
+	request := func() {
+		defer func() {
+			if e := recover(); e != nil {
+				results <- e.(string)
+			}
+		}()
+		cs := client.NewClientSession()
+		cs.PutCmd(commands.Check)
+		cs.Request()
+		results <- "ok"
+	}
+	waitFor := func(fn func() bool) {
+		for !fn() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	go request()
+	waitFor(func() bool { n, _, _, _ := workers.Status(); return n == 1 })
+	go request()
+	waitFor(func() bool { _, _, q, _ := workers.Status(); return q == 1 })
+	go request()
+	assert.T(t).This(<-results).Is(overloaded + " (from server)")
+	close(block)
+	assert.T(t).This(<-results).Is("ok")
+	assert.T(t).This(<-results).Is("ok")
+}
+
+func TestCompress(t *testing.T) {
+	p1, p2 := net.Pipe()
+	client := NewClientConn(p1)
+	client.SetCompress(100)
+	workers := NewWorkers(func(wb *WriteBuf, _ *core.Thread, _ uint64, data []byte) {
+		wb.Write(bytes.ToUpper(data)).EndMsg()
+	})
+	msc := NewServerConn(p2)
+	msc.SetCompress(100)
+	go msc.Run(workers.Submit)
+	session := client.NewClientSession()
+	before := nCompressed.Load()
+	for _, s := range []string{"small", strings.Repeat("hello world ", 1000),
+		str.Random(3*bufSize, 3*bufSize)} {
+		session.WriteString(s)
+		session.EndMsg()
+		assert.T(t).This(string(session.read())).Is(str.ToUpper(s))
+	}
+	assert.T(t).That(nCompressed.Load() > before)
+}
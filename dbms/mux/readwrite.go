@@ -13,6 +13,7 @@ import (
 	"github.com/apmckinlay/gsuneido/options"
 	"github.com/apmckinlay/gsuneido/util/assert"
 	"github.com/apmckinlay/gsuneido/util/hacks"
+	"github.com/apmckinlay/gsuneido/util/otel"
 )
 
 const bufSize = 4 * 1024
@@ -22,6 +23,9 @@ type WriteBuf struct {
 	*conn
 	buf []byte
 	id  uint32
+	// span is set by ClientSession to send with the next command
+	span   otel.Context
+	traced bool // whether the current message starts with span
 }
 
 func newWriteBuf(c *conn, id uint32) *WriteBuf {
@@ -44,7 +48,7 @@ func (wb *WriteBuf) Write(data []byte) *WriteBuf {
 		wb.flush(false)
 	}
 	if len(data) >= bufSize {
-		wb.conn.write(wb.id, data, false, false)
+		wb.conn.write(wb.id, data, false, false, false)
 	} else {
 		wb.buf = append(wb.buf, data...)
 	}
@@ -59,7 +63,7 @@ func (wb *WriteBuf) WriteString(data string) *WriteBuf {
 	if len(data) >= bufSize {
 		// it would be safer/better to use []byte(s)
 		// but strings are used for large data so we want to avoid copying
-		wb.conn.write(wb.id, hacks.Stobs(data), false, false)
+		wb.conn.write(wb.id, hacks.Stobs(data), false, false, false)
 	} else {
 		wb.buf = append(wb.buf, data...)
 	}
@@ -82,8 +86,11 @@ func (wb *WriteBuf) EndMsg() {
 }
 
 func (wb *WriteBuf) flush(final bool) {
-	wb.conn.write(wb.id, wb.buf, true, final)
+	wb.conn.write(wb.id, wb.buf, true, final, final && wb.traced)
 	wb.buf = wb.buf[:HeaderSize]
+	if final {
+		wb.traced = false
+	}
 }
 
 //-------------------------------------------------------------------
@@ -99,6 +106,10 @@ const traceLimit = 100
 func (wb *WriteBuf) PutCmd(cmd commands.Command) *WriteBuf {
 	trace.ClientServer.Println(">", cmd)
 	wb.ResetWrite()
+	if wb.span.Valid() {
+		wb.buf = wb.span.Encode(wb.buf)
+		wb.traced = true
+	}
 	wb.Write1(byte(cmd))
 	return wb
 }
@@ -224,6 +235,7 @@ func (wb *WriteBuf) PutVal(val Value) *WriteBuf {
 
 func (wb *WriteBuf) ResetWrite() {
 	wb.buf = wb.buf[:HeaderSize] // discard content, keep capacity
+	wb.traced = false
 }
 
 const maxio = 1024 * 1024 // 1 mb
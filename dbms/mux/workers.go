@@ -5,58 +5,159 @@ package mux
 
 import (
 	"log"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/dbms/commands"
 	"github.com/apmckinlay/gsuneido/util/dbg"
+	"github.com/apmckinlay/gsuneido/util/otel"
 )
 
 // Workers creates worker goroutines on demand.
 // It is not used by mux directly, but can be used by server handlers.
-// The number of workers is not limited.
+// By default the number of workers is not limited.
+// With SetLimits, requests that arrive when all the workers are busy
+// are queued, taking turns between sessions,
+// and if the queue is full they are rejected.
 // Unnecessary workers will be terminated.
 type Workers struct {
 	ch        chan task
 	h         workfn
 	killClock atomic.Int64
+
+	lock     sync.Mutex
+	n        int // the number of workers
+	idle     int // the number of workers waiting on ch
+	max      int // the maximum number of workers, 0 for unlimited
+	maxQueue int // the maximum number of queued tasks, 0 for unlimited
+	queued   int
+	queues   map[uint64][]task // per session
+	turns    []uint64          // sessions with queued tasks, in turn order
 }
 
 type task struct {
 	c    *conn
 	data []byte
 	id   uint64
+	span otel.Context
 }
 
 var nWorker atomic.Int64
 var _ = core.AddInfo("server.nWorker", &nWorker)
 
+var nQueued atomic.Int64
+var _ = core.AddInfo("server.nQueued", &nQueued)
+
+var nRejected atomic.Int64
+var _ = core.AddInfo("server.nRejected", &nRejected)
+
 type workfn func(wb *WriteBuf, th *core.Thread, id uint64, rb []byte)
 
 // NewWorkers creates a new goroutine pool
 func NewWorkers(h workfn) *Workers {
 	ch := make(chan task) // intentionally unbuffered
-	ws := &Workers{ch: ch, h: h}
+	ws := &Workers{ch: ch, h: h, queues: make(map[uint64][]task)}
 	go ws.killer()
 	return ws
 }
 
+// SetLimits sets the maximum number of workers
+// and the maximum number of queued requests. Zero means unlimited.
+func (ws *Workers) SetLimits(maxWorkers, maxQueue int) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	ws.max = maxWorkers
+	ws.maxQueue = maxQueue
+}
+
+// Status returns the current number of workers, how many are idle,
+// how many requests are queued, and the maximum number of workers
+func (ws *Workers) Status() (n, idle, queued, max int) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	return ws.n, ws.idle, ws.queued, ws.max
+}
+
+const overloaded = "server overloaded, please try again"
+
 // Submit passes a task to a worker
-func (ws *Workers) Submit(c *conn, id uint64, data []byte) {
-	t := task{c: c, id: id, data: data}
-	select {
-	// try to use an existing worker goroutine
-	case ws.ch <- t:
-	default:
+func (ws *Workers) Submit(c *conn, id uint64, data []byte, span otel.Context) {
+	t := task{c: c, id: id, data: data, span: span}
+	ws.lock.Lock()
+	if ws.idle > 0 {
+		// use an existing worker goroutine
+		ws.idle--
+		ws.lock.Unlock()
+		ws.ch <- t
+		return
+	}
+	if ws.max == 0 || ws.n < ws.max {
 		// otherwise start a new one
+		ws.n++
+		ws.lock.Unlock()
 		ws.killClock.Store(0) // prevent immediate kill
 		go ws.worker(t)
+		return
+	}
+	if ws.maxQueue > 0 && ws.queued >= ws.maxQueue && !mustRun(data) {
+		ws.lock.Unlock()
+		nRejected.Add(1)
+		wb := newWriteBuf(c, uint32(id))
+		wb.PutBool(false).PutStr(overloaded).EndMsg()
+		return
+	}
+	ws.enqueue(t)
+	ws.lock.Unlock()
+}
+
+// mustRun returns true for requests that are not replied to
+// (closing the connection or ending a session) so can't be rejected
+func mustRun(data []byte) bool {
+	return len(data) == 0 || commands.Command(data[0]) == commands.EndSession
+}
+
+// enqueue must be called with the lock held
+func (ws *Workers) enqueue(t task) {
+	q := ws.queues[t.id]
+	if len(q) == 0 {
+		ws.turns = append(ws.turns, t.id)
 	}
+	ws.queues[t.id] = append(q, t)
+	ws.queued++
+	nQueued.Add(1)
+}
+
+// dequeue returns the next task from the session whose turn it is.
+// It must be called with the lock held.
+func (ws *Workers) dequeue() (task, bool) {
+	if len(ws.turns) == 0 {
+		return task{}, false
+	}
+	id := ws.turns[0]
+	ws.turns = ws.turns[1:]
+	q := ws.queues[id]
+	t := q[0]
+	if len(q) == 1 {
+		delete(ws.queues, id)
+	} else {
+		ws.queues[id] = q[1:]
+		ws.turns = append(ws.turns, id) // go to the back of the line
+	}
+	ws.queued--
+	nQueued.Add(-1)
+	return t, true
 }
 
 func (ws *Workers) worker(t task) {
 	nWorker.Add(1)
-	defer nWorker.Add(-1)
+	defer func() {
+		ws.lock.Lock()
+		ws.n--
+		ws.lock.Unlock()
+		nWorker.Add(-1)
+	}()
 	// each worker has its own WriteBuf and Thread
 	wb := newWriteBuf(nil, 0)
 	th := core.NewThread(nil)
@@ -74,9 +175,10 @@ func (ws *Workers) worker(t task) {
 	for {
 		wb.conn = t.c
 		wb.id = uint32(t.id)
+		th.SetSpanContext(t.span)  // the client's span, if any
 		ws.h(wb, th, t.id, t.data) // do the task
 		th.Invalidate()
-		t = <-ws.ch                // blocking, wait for message
+		t = ws.next()
 		if t.c == nil {
 			return // got poison pill so terminate
 		}
@@ -84,14 +186,35 @@ func (ws *Workers) worker(t task) {
 	}
 }
 
+// next returns a queued task if there is one,
+// otherwise it waits for Submit (or killer)
+func (ws *Workers) next() task {
+	ws.lock.Lock()
+	if t, ok := ws.dequeue(); ok {
+		ws.lock.Unlock()
+		return t
+	}
+	ws.idle++
+	ws.lock.Unlock()
+	return <-ws.ch // blocking, wait for message
+}
+
 func (ws *Workers) killer() {
 	const interval = 2 * time.Second
 	const createDelay = 10 // * interval
 	const minWorkers = 3
 	for {
 		time.Sleep(interval)
-		if ws.killClock.Add(1) > createDelay && nWorker.Load() > minWorkers {
-			ws.ch <- task{} // send poison pill to a worker
+		if ws.killClock.Add(1) > createDelay {
+			ws.lock.Lock()
+			kill := ws.idle > 0 && ws.n > minWorkers
+			if kill {
+				ws.idle--
+			}
+			ws.lock.Unlock()
+			if kill {
+				ws.ch <- task{} // send poison pill to a worker
+			}
 		}
 	}
 }
@@ -191,6 +191,7 @@ func (jb *joinBase) String(op string) string {
 }
 
 func (jb *joinBase) SetTran(qt QueryTran) {
+	jb.Query2.SetTran(qt)
 	jb.qt = qt
 	jb.st = MakeSuTran(qt)
 	jb.lookupCache.Reset()
@@ -0,0 +1,428 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package query
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/apmckinlay/gsuneido/compile/ast"
+	"github.com/apmckinlay/gsuneido/compile/lexer"
+	tok "github.com/apmckinlay/gsuneido/compile/tokens"
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/core/types"
+	"github.com/apmckinlay/gsuneido/db19/meta"
+)
+
+// Prepared is a query with $name parameters e.g. "tables where table = $t"
+//
+// If the parameters are only used as col = $name terms in the outer where,
+// the query is optimized once, grouped by the parameter columns,
+// and the values are applied with Select,
+// the same as the right hand side of a join.
+// These plans are kept and reused as long as the schema does not change.
+//
+// Otherwise, the values are substituted into the query as literals
+// and it is parsed and optimized as usual.
+type Prepared struct {
+	query  string
+	handle int
+	params []param
+	lock   sync.Mutex
+	plans  []*plan // idle plans
+	slow   bool    // parameters are not just in the outer where
+}
+
+type param struct {
+	name     string
+	pos, end int
+}
+
+type plan struct {
+	q     Query
+	meta  *meta.Meta
+	cols  []string // the columns for Select
+	names []string // the parameter for each column
+	mode  Mode
+	cost  Cost
+}
+
+// maxIdle is the maximum number of idle plans kept for a prepared query
+const maxIdle = 4
+
+// maxPrepared is the number of prepared queries that are kept.
+// The least recently used are discarded.
+const maxPrepared = 1000
+
+var prepared = struct {
+	lock     sync.Mutex
+	lru      *list.List // of *Prepared, most recently used at the front
+	byText   map[string]*list.Element
+	byHandle map[int]*list.Element
+	next     int
+}{lru: list.New(), byText: make(map[string]*list.Element),
+	byHandle: make(map[int]*list.Element)}
+
+// Prepare returns a handle for a query with $name parameters.
+// Preparing the same query text again returns the same handle
+// unless it has been discarded.
+func Prepare(query string) int {
+	prepared.lock.Lock()
+	defer prepared.lock.Unlock()
+	if e, ok := prepared.byText[query]; ok {
+		prepared.lru.MoveToFront(e)
+		return e.Value.(*Prepared).handle
+	}
+	p := &Prepared{query: query, params: prepareParams(query),
+		handle: prepared.next}
+	prepared.next++
+	e := prepared.lru.PushFront(p)
+	prepared.byText[query] = e
+	prepared.byHandle[p.handle] = e
+	if prepared.lru.Len() > maxPrepared {
+		old := prepared.lru.Remove(prepared.lru.Back()).(*Prepared)
+		delete(prepared.byText, old.query)
+		delete(prepared.byHandle, old.handle)
+	}
+	return p.handle
+}
+
+// GetPrepared returns the Prepared for a handle from Prepare.
+// It panics with PreparedNotFound if the query has been discarded.
+func GetPrepared(handle int) *Prepared {
+	prepared.lock.Lock()
+	defer prepared.lock.Unlock()
+	e, ok := prepared.byHandle[handle]
+	if !ok {
+		panic(PreparedNotFound)
+	}
+	prepared.lru.MoveToFront(e)
+	return e.Value.(*Prepared)
+}
+
+// prepareParams uses the query lexer to find the $name parameters
+// so that strings and comments are handled correctly.
+// A $ following an operand is the concatenation operator.
+func prepareParams(query string) []param {
+	var params []param
+	lxr := lexer.NewQueryLexer(query)
+	prev := tok.Nil
+	for item := lxr.Next(); item.Token != tok.Eof; item = lxr.Next() {
+		switch item.Token {
+		case tok.Whitespace, tok.Newline, tok.Comment:
+			continue
+		}
+		if item.Token != tok.Cat || endsOperand(prev) {
+			prev = item.Token
+			continue
+		}
+		pos := int(item.Pos)
+		next := lxr.Next()
+		if next.Token != tok.Identifier || int(next.Pos) != pos+1 {
+			panic("Prepare: invalid parameter, expected $name")
+		}
+		params = append(params, param{name: next.Text, pos: pos,
+			end: pos + 1 + len(next.Text)})
+		prev = next.Token
+	}
+	if len(params) == 0 {
+		panic("Prepare: query has no parameters")
+	}
+	return params
+}
+
+func endsOperand(t tok.Token) bool {
+	switch t {
+	case tok.Identifier, tok.Number, tok.String, tok.Symbol, tok.True,
+		tok.False, tok.RParen, tok.RBracket, tok.RCurly:
+		return true
+	}
+	return false
+}
+
+// String returns the original query text
+func (p *Prepared) String() string {
+	return p.query
+}
+
+// paramMark is the prefix of the string constants
+// that stand in for the parameters when planning
+const paramMark = "\x01$"
+
+// substitute returns the query text with the parameters replaced
+func (p *Prepared) substitute(fn func(name string) string) string {
+	var sb strings.Builder
+	prev := 0
+	for _, pm := range p.params {
+		sb.WriteString(p.query[prev:pm.pos])
+		sb.WriteString(fn(pm.name))
+		prev = pm.end
+	}
+	sb.WriteString(p.query[prev:])
+	return sb.String()
+}
+
+// Query returns the query with the parameters bound to the args.
+// If release is not nil, it should be called
+// when the query is finished with, so the plan can be reused.
+func (p *Prepared) Query(t QueryTran, sv *Sviews, mode Mode,
+	args map[string]Value) (q Query, cost Cost, release func()) {
+	for _, pm := range p.params {
+		if _, ok := args[pm.name]; !ok {
+			panic("prepared query: missing parameter: " + pm.name)
+		}
+	}
+	for name, val := range args {
+		if !slices.ContainsFunc(p.params,
+			func(pm param) bool { return pm.name == name }) {
+			panic("prepared query: unknown parameter: " + name)
+		}
+		if !literal(val) {
+			panic("prepared query: invalid parameter value: " + name)
+		}
+	}
+	m := tranMeta(t)
+	pl := p.getPlan(t, sv, mode, m)
+	if pl == nil {
+		query := p.substitute(func(name string) string {
+			return args[name].String()
+		})
+		q = ParseQuery(query, t, sv)
+		q, fixcost, varcost := Setup(q, mode, t)
+		return q, fixcost + varcost, nil
+	}
+	vals := make([]string, len(pl.names))
+	for i, name := range pl.names {
+		vals[i] = PackValue(args[name])
+	}
+	pl.q.SetTran(t)
+	pl.q.Select(pl.cols, vals)
+	if m == nil || sv.Len() > 0 || !reusable(pl.q) {
+		return pl.q, pl.cost, nil
+	}
+	done := false
+	return pl.q, pl.cost, func() {
+		if !done {
+			done = true
+			p.putPlan(pl)
+		}
+	}
+}
+
+// literal returns whether a value can be used as a parameter.
+// Only these can be substituted into the query text safely.
+func literal(val Value) bool {
+	switch val.Type() {
+	case types.Boolean, types.Number, types.String, types.Date:
+		return true
+	case types.Object, types.Record:
+		iter := ToContainer(val).Iter2(true, true)
+		for k, v := iter(); k != nil; k, v = iter() {
+			if !literal(k) || !literal(v) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// tranMeta returns the meta data for the transaction
+// or nil if it is not available, in which case plans are not reused
+func tranMeta(t QueryTran) *meta.Meta {
+	if mt, ok := t.(interface{ Meta() *meta.Meta }); ok {
+		return mt.Meta()
+	}
+	return nil
+}
+
+// getPlan returns an idle plan for the same schema and mode if there is one,
+// otherwise it builds a new plan.
+// It returns nil if the query needs to use substitution.
+func (p *Prepared) getPlan(t QueryTran, sv *Sviews, mode Mode,
+	m *meta.Meta) *plan {
+	p.lock.Lock()
+	if p.slow {
+		p.lock.Unlock()
+		return nil
+	}
+	plans := p.plans[:0]
+	var pl *plan
+	for _, x := range p.plans {
+		if m == nil || !x.meta.SameSchemaAs(m) {
+			continue // discard stale plans
+		}
+		if pl == nil && x.mode == mode && sv.Len() == 0 {
+			pl = x
+		} else {
+			plans = append(plans, x)
+		}
+	}
+	clear(p.plans[len(plans):])
+	p.plans = plans
+	p.lock.Unlock()
+	if pl != nil {
+		return pl
+	}
+	pl = p.buildPlan(t, sv, mode)
+	if pl == nil {
+		p.lock.Lock()
+		p.slow = true
+		p.lock.Unlock()
+		return nil
+	}
+	pl.meta = m
+	return pl
+}
+
+func (p *Prepared) putPlan(pl *plan) {
+	pl.q.Select(nil, nil)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.plans) < maxIdle {
+		p.plans = append(p.plans, pl)
+	}
+}
+
+// buildPlan parses the query with placeholders for the parameters,
+// removes the parameter terms from the outer where,
+// and optimizes the remainder grouped by the parameter columns.
+// It returns nil if the parameters are used anywhere else.
+func (p *Prepared) buildPlan(t QueryTran, sv *Sviews, mode Mode) *plan {
+	query := p.substitute(func(name string) string {
+		return SuStr(paramMark + name).String()
+	})
+	q := ParseQuery(query, t, sv)
+	sort, _ := q.(*Sort)
+	if sort != nil {
+		q = sort.source
+	}
+	w, ok := q.(*Where)
+	if !ok {
+		return nil
+	}
+	pl := &plan{mode: mode}
+	var rest []ast.Expr
+	for _, e := range w.expr.Exprs {
+		if col, name, ok := paramTerm(e); ok &&
+			!slices.Contains(pl.cols, col) {
+			pl.cols = append(pl.cols, col)
+			pl.names = append(pl.names, name)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	if len(rest) == 0 {
+		q = w.source
+	} else {
+		q = NewWhere(w.source, &ast.Nary{Tok: tok.And, Exprs: rest}, t)
+	}
+	if len(pl.cols) == 0 || hasParam(q) {
+		return nil
+	}
+	q = q.Transform()
+	var index []string
+	var fixcost, varcost Cost
+	if sort == nil {
+		best := bestGrouped(q, mode, nil, 1, pl.cols)
+		index, fixcost, varcost = best.index, best.fixcost, best.varcost
+	} else {
+		index = append(slices.Clip(pl.cols), sort.order...)
+		fixcost, varcost = Optimize(q, mode, index, 1)
+	}
+	if fixcost+varcost >= impossible {
+		return nil
+	}
+	q = SetApproach(q, index, 1, t)
+	if sort != nil {
+		s := NewSort(q, sort.reverse, sort.order)
+		s.index = index
+		q = s
+	}
+	Warnings(p.query, q)
+	pl.q = q
+	pl.cost = fixcost + varcost
+	return pl
+}
+
+// paramTerm returns the column and parameter name
+// if e is col = $name (or $name = col)
+func paramTerm(e ast.Expr) (string, string, bool) {
+	b, ok := e.(*ast.Binary)
+	if !ok || b.Tok != tok.Is {
+		return "", "", false
+	}
+	id, ok1 := b.Lhs.(*ast.Ident)
+	c, ok2 := b.Rhs.(*ast.Constant)
+	if !ok1 || !ok2 {
+		id, ok1 = b.Rhs.(*ast.Ident)
+		c, ok2 = b.Lhs.(*ast.Constant)
+	}
+	if !ok1 || !ok2 {
+		return "", "", false
+	}
+	if name, ok := paramName(c.Val); ok {
+		return id.Name, name, true
+	}
+	return "", "", false
+}
+
+func paramName(v Value) (string, bool) {
+	if s, ok := v.(SuStr); ok && strings.HasPrefix(string(s), paramMark) {
+		return string(s)[len(paramMark):], true
+	}
+	return "", false
+}
+
+// hasParam returns whether any where or extend in the query
+// still contains a parameter
+func hasParam(q0 Query) bool {
+	found := false
+	var fn func(ast.Node) ast.Node
+	fn = func(n ast.Node) ast.Node {
+		if c, ok := n.(*ast.Constant); ok {
+			if _, ok := paramName(c.Val); ok {
+				found = true
+			}
+		}
+		n.Children(fn)
+		return n
+	}
+	switch q := q0.(type) {
+	case *Where:
+		fn(q.expr)
+	case *Extend:
+		for _, e := range q.exprs {
+			if e != nil {
+				fn(e)
+			}
+		}
+	}
+	switch q := q0.(type) {
+	case q2i:
+		return found || hasParam(q.Source()) || hasParam(q.Source2())
+	case q1i:
+		return found || hasParam(q.Source())
+	}
+	return found
+}
+
+// reusable returns whether a query tree can be reused in another transaction.
+// Temporary indexes and map strategies hold data from the transaction.
+func reusable(q0 Query) bool {
+	switch q := q0.(type) {
+	case *Table:
+		return true
+	case *Project:
+		return q.strat != projMap && reusable(q.source)
+	case *Where, *Rename, *Extend, *Sort:
+		return reusable(q.(q1i).Source())
+	case *Join, *LeftJoin:
+		q2 := q.(q2i)
+		return reusable(q2.Source()) && reusable(q2.Source2())
+	}
+	return false
+}
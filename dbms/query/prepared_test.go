@@ -0,0 +1,118 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package query
+
+import (
+	"strconv"
+	"testing"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestPrepared(t *testing.T) {
+	assert := assert.T(t)
+	db := heapDb()
+	db.adm("create cus (ck, name, city) key(ck) index(city, ck)")
+	db.act("insert { ck: 1, name: 'axon', city: 'saskatoon' } into cus")
+	db.act("insert { ck: 2, name: 'bob', city: 'calgary' } into cus")
+	db.act("insert { ck: 3, name: 'cron', city: 'saskatoon' } into cus")
+
+	run := func(query string, args map[string]Value) (Query, string) {
+		t.Helper()
+		rt := db.NewReadTran()
+		q, _, release := GetPrepared(Prepare(query)).Query(rt, nil, ReadMode, args)
+		s := queryAll2(q)
+		if release != nil {
+			release()
+		}
+		return q, s
+	}
+	query := "cus where city = $city and name isnt 'bob' sort reverse ck"
+	q1, s := run(query, map[string]Value{"city": SuStr("saskatoon")})
+	assert.This(s).Is(`ck=3 name=cron city=saskatoon | ` +
+		`ck=1 name=axon city=saskatoon`)
+	q2, s := run(query, map[string]Value{"city": SuStr("calgary")})
+	assert.This(s).Is("")
+	assert.That(q1 == q2) // plan reused
+	assert.This(Prepare(query)).Is(Prepare(query))
+
+	// parameters that are not just in the outer where use substitution
+	_, s = run("cus where name > $name", map[string]Value{"name": SuStr("bob")})
+	assert.This(s).Is(`ck=3 name=cron city=saskatoon`)
+
+	// $ inside strings is not a parameter
+	_, s = run(`cus where name isnt "$x" and ck = $ck`,
+		map[string]Value{"ck": IntVal(2)})
+	assert.This(s).Is(`ck=2 name=bob city=calgary`)
+
+	// $ after an operand is concatenation
+	_, s = run("cus extend x = name $ '!' where x = $x $ '' and ck < $ck",
+		map[string]Value{"x": SuStr("bob!"), "ck": IntVal(3)})
+	assert.This(s).Is(`ck=2 name=bob city=calgary x=bob!`)
+
+	// only literal values
+	_, s = run(query, map[string]Value{"city": SuObjectOf(SuStr("x"))})
+	assert.This(s).Is("")
+	assert.This(func() { run(query, map[string]Value{"city": &SuClass{}}) }).
+		Panics("invalid parameter value: city")
+	assert.This(func() {
+		run(query, map[string]Value{"city": SuObjectOf(&SuClass{})})
+	}).Panics("invalid parameter value: city")
+
+	// schema change discards plans
+	db.adm("ensure cus index(name)")
+	q3, s := run(query, map[string]Value{"city": SuStr("saskatoon")})
+	assert.That(q3 != q1)
+	assert.This(s).Is(`ck=3 name=cron city=saskatoon | ` +
+		`ck=1 name=axon city=saskatoon`)
+
+	assert.This(func() { Prepare("cus where ck = 1") }).
+		Panics("query has no parameters")
+	assert.This(func() { run(query, nil) }).
+		Panics("missing parameter: city")
+	assert.This(func() {
+		run(query, map[string]Value{"city": False, "x": True})
+	}).Panics("unknown parameter: x")
+}
+
+func TestPreparedDiscard(t *testing.T) {
+	assert := assert.T(t)
+	h := Prepare("tables where table = $t")
+	for i := range maxPrepared {
+		Prepare("tables where table = $t" + strconv.Itoa(i))
+	}
+	assert.This(func() { GetPrepared(h) }).Panics(PreparedNotFound)
+	h2 := Prepare("tables where table = $t")
+	assert.This(h2).Isnt(h)
+	assert.This(GetPrepared(h2).String()).Is("tables where table = $t")
+}
+
+func TestPreparedDataChange(t *testing.T) {
+	assert := assert.T(t)
+	db := heapDb()
+	db.adm("create cus (ck, name) key(ck)")
+	db.adm("create ord (ok, ck) key(ok) index(ck)")
+	db.act("insert { ck: 1, name: 'axon' } into cus")
+	db.act("insert { ok: 10, ck: 1 } into ord")
+
+	p := GetPrepared(Prepare("ord join by(ck) cus where ck = $ck"))
+	args := map[string]Value{"ck": One}
+	run := func() (Query, string) {
+		t.Helper()
+		q, _, release := p.Query(db.NewReadTran(), nil, ReadMode, args)
+		s := queryAll2(q)
+		if release != nil {
+			release()
+		}
+		return q, s
+	}
+	q1, s := run()
+	assert.This(s).Is("ok=10 ck=1 name=axon")
+	db.act("insert { ok: 11, ck: 1 } into ord")
+	db.act("update cus set name = 'bob'")
+	q2, s := run()
+	assert.That(q1 == q2) // plan reused
+	assert.This(s).Is("ok=10 ck=1 name=bob | ok=11 ck=1 name=bob")
+}
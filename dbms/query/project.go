@@ -171,6 +171,7 @@ func (p *Project) String() string {
 
 func (p *Project) SetTran(t QueryTran) {
 	p.st = MakeSuTran(t)
+	p.source.SetTran(t)
 }
 
 // projectKeys is also used by Summarize
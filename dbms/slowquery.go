@@ -0,0 +1,151 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package dbms
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/options"
+	"github.com/apmckinlay/gsuneido/util/jsonlog"
+)
+
+// The slow query log records queries and actions that take longer than
+// options.SlowQueryTime or do more than options.SlowQueryReads reads
+// (the transaction ReadCount). Entries are JSON lines in
+// options.SlowQueryLog, rotated like the JSON log.
+
+type slowEntry struct {
+	Time     string  `json:"time"`
+	Session  string  `json:"session"`
+	Op       string  `json:"op"`
+	Query    string  `json:"query"`
+	Strategy string  `json:"strategy,omitempty"`
+	Reads    int     `json:"reads"`
+	Ms       float64 `json:"ms"`
+}
+
+type readCounter interface {
+	ReadCount() int
+}
+
+// slowTimer is returned by slowStart.
+// The zero value means the slow query log is disabled.
+type slowTimer struct {
+	t     time.Time
+	rc    readCounter
+	reads int
+}
+
+func slowStart(tran any) slowTimer {
+	if options.SlowQueryTime == 0 && options.SlowQueryReads == 0 {
+		return slowTimer{}
+	}
+	st := slowTimer{t: time.Now()}
+	if rc, ok := tran.(readCounter); ok && rc != nil {
+		st.rc = rc
+		st.reads = rc.ReadCount()
+	}
+	return st
+}
+
+func (st slowTimer) enabled() bool {
+	return !st.t.IsZero()
+}
+
+func (st slowTimer) elapsed() (time.Duration, int) {
+	reads := 0
+	if st.rc != nil {
+		reads = st.rc.ReadCount() - st.reads
+	}
+	return time.Since(st.t), reads
+}
+
+// check logs the operation if it exceeded either threshold.
+// strategy is only called if the operation is logged.
+func (st slowTimer) check(th *Thread, op, query string,
+	strategy func() string) {
+	if !st.enabled() {
+		return
+	}
+	d, reads := st.elapsed()
+	logSlow(th.Session(), op, query, d, reads, strategy)
+}
+
+// slowTotal accumulates the time and reads of the Gets on a query
+// so a query is logged once (at eof or Close)
+// whether it is slow from a few slow Gets or many fast ones.
+// nil means the slow query log is disabled.
+type slowTotal struct {
+	session string
+	d       time.Duration
+	reads   int
+}
+
+func newSlowTotal() *slowTotal {
+	if options.SlowQueryTime == 0 && options.SlowQueryReads == 0 {
+		return nil
+	}
+	return &slowTotal{}
+}
+
+func (tot *slowTotal) add(th *Thread, st slowTimer) {
+	if tot == nil || !st.enabled() {
+		return
+	}
+	d, reads := st.elapsed()
+	tot.session = th.Session()
+	tot.d += d
+	tot.reads += reads
+}
+
+// check logs the query if the totals exceeded either threshold
+// and then resets the totals
+func (tot *slowTotal) check(op, query string, strategy func() string) {
+	if tot == nil {
+		return
+	}
+	session, d, reads := tot.session, tot.d, tot.reads
+	*tot = slowTotal{}
+	logSlow(session, op, query, d, reads, strategy)
+}
+
+func logSlow(session, op, query string, d time.Duration, reads int,
+	strategy func() string) {
+	if !(options.SlowQueryTime > 0 && d >= options.SlowQueryTime) &&
+		!(options.SlowQueryReads > 0 && reads >= options.SlowQueryReads) {
+		return
+	}
+	e := slowEntry{Time: time.Now().Format(time.RFC3339Nano),
+		Session: session, Op: op, Query: query, Reads: reads,
+		Ms: float64(d.Microseconds()) / 1000}
+	if strategy != nil {
+		e.Strategy = strategy()
+	}
+	writeSlow(&e)
+}
+
+var slowLog struct {
+	once sync.Once
+	w    *jsonlog.Rotator
+}
+
+func writeSlow(e *slowEntry) {
+	slowLog.once.Do(func() {
+		var err error
+		slowLog.w, err = jsonlog.NewRotator(options.SlowQueryLog,
+			options.LogMaxSize, options.LogMaxAge, options.LogKeep)
+		if err != nil {
+			log.Println("ERROR: slow query log:", err)
+		}
+	})
+	if slowLog.w == nil {
+		return
+	}
+	b, _ := json.Marshal(e)
+	slowLog.w.Write(append(b, '\n'))
+}
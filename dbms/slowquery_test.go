@@ -0,0 +1,110 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package dbms
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/db19"
+	"github.com/apmckinlay/gsuneido/db19/stor"
+	qry "github.com/apmckinlay/gsuneido/dbms/query"
+	"github.com/apmckinlay/gsuneido/options"
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slowquery.log")
+	defer func(log string) {
+		options.SlowQueryLog = log
+		options.SlowQueryTime = 0
+		slowLog.once = sync.Once{}
+		slowLog.w = nil
+	}(options.SlowQueryLog)
+	options.SlowQueryLog = path
+	options.SlowQueryTime = time.Nanosecond // log everything
+
+	db := db19.CreateDb(stor.HeapStor(8192))
+	db19.StartConcur(db, 50*time.Millisecond)
+	qry.DoAdmin(db, "create tmp (a, b) key(a)", nil)
+	th := &Thread{}
+	th.SetSession("sess1")
+	tran := UpdateTranLocal{UpdateTran: db.NewUpdateTran()}
+	tran.Action(th, "insert { a: 1, b: 2 } into tmp")
+	q := tran.Query("tmp where b is 2", nil)
+	row, _ := q.Get(th, Next)
+	assert.T(t).That(row != nil)
+	q.Close() // Gets are logged at eof or Close
+	args := SuObjectOf(SuStr("tmp"))
+	args.Set(SuStr("a"), One)
+	row, _, _ = tran.Get(th, args, Only)
+	assert.T(t).That(row != nil)
+	slowLog.w.Close()
+
+	b, err := os.ReadFile(path)
+	assert.T(t).This(err).Is(nil)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.T(t).This(len(lines)).Is(3)
+	var e slowEntry
+	assert.T(t).This(json.Unmarshal([]byte(lines[0]), &e)).Is(nil)
+	assert.T(t).This(e.Session).Is("sess1")
+	assert.T(t).This(e.Op).Is("Action")
+	assert.T(t).This(e.Query).Is("insert { a: 1, b: 2 } into tmp")
+	assert.T(t).This(json.Unmarshal([]byte(lines[1]), &e)).Is(nil)
+	assert.T(t).This(e.Op).Is("Get")
+	assert.T(t).This(e.Query).Is("tmp where b is 2")
+	assert.T(t).That(strings.Contains(e.Strategy, "tmp"))
+	assert.T(t).This(json.Unmarshal([]byte(lines[2]), &e)).Is(nil)
+	assert.T(t).This(e.Query).Is("tmp")
+	assert.T(t).That(strings.HasPrefix(e.Strategy, "key: tmp"))
+}
+
+func TestSlowQueryReads(t *testing.T) {
+	assert := assert.T(t)
+	path := filepath.Join(t.TempDir(), "slowquery.log")
+	defer func(log string) {
+		options.SlowQueryLog = log
+		options.SlowQueryReads = 0
+		slowLog.once = sync.Once{}
+		slowLog.w = nil
+	}(options.SlowQueryLog)
+	db := db19.CreateDb(stor.HeapStor(8192))
+	db19.StartConcur(db, 50*time.Millisecond)
+	qry.DoAdmin(db, "create tmp (a, b) key(a)", nil)
+	th := &Thread{}
+	ut := UpdateTranLocal{UpdateTran: db.NewUpdateTran()}
+	ut.Action(th, "insert { a: 1, b: 2 } into tmp")
+	ut.Action(th, "insert { a: 2, b: 3 } into tmp")
+	ut.Commit()
+	options.SlowQueryLog = path
+	options.SlowQueryReads = 1
+
+	readAll := func(get func() Row) {
+		for get() != nil {
+		}
+	}
+	rt := &ReadTranLocal{ReadTran: db.NewReadTran()}
+	q := rt.Query("tmp", nil)
+	readAll(func() Row { row, _ := q.Get(th, Next); return row })
+	c := (&DbmsLocal{db: db}).Cursor("tmp where b > 2", nil)
+	readAll(func() Row { row, _ := c.Get(th, rt, Next); return row })
+	slowLog.w.Close()
+
+	b, err := os.ReadFile(path)
+	assert.This(err).Is(nil)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.This(len(lines)).Is(2)
+	for i, query := range []string{"tmp", "tmp where b > 2"} {
+		var e slowEntry
+		assert.This(json.Unmarshal([]byte(lines[i]), &e)).Is(nil)
+		assert.This(e.Query).Is(query)
+		assert.That(e.Reads >= 1)
+	}
+}
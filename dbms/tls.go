@@ -0,0 +1,82 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package dbms
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"time"
+
+	. "github.com/apmckinlay/gsuneido/core"
+	"github.com/apmckinlay/gsuneido/options"
+)
+
+// serverTls is set by Server if options.TlsCert is set
+var serverTls *tls.Config
+
+// serverTlsConfig returns the server TLS configuration from the options,
+// or nil if TLS is not enabled
+func serverTlsConfig() *tls.Config {
+	if options.TlsCert == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(options.TlsCert, options.TlsKey)
+	if err != nil {
+		Fatal("DbmsServer: tls:", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert},
+		MinVersion: tls.VersionTLS12}
+	if options.TlsCA != "" {
+		config.ClientCAs = loadCA()
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// clientTlsConfig returns the client TLS configuration from the options.
+// Without options.TlsCA the server is verified with the system roots.
+func clientTlsConfig(addr string) *tls.Config {
+	config := &tls.Config{ServerName: addr, MinVersion: tls.VersionTLS12}
+	if options.TlsCA != "" {
+		config.RootCAs = loadCA()
+	}
+	if options.TlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(options.TlsCert, options.TlsKey)
+		if err != nil {
+			cantConnect("tls: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config
+}
+
+func loadCA() *x509.CertPool {
+	pem, err := os.ReadFile(options.TlsCA)
+	if err != nil {
+		Fatal("tls:", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		Fatal("tls: no certificates found in", options.TlsCA)
+	}
+	return pool
+}
+
+const tlsTimeout = 5 * time.Second
+
+// tlsHandshake does the handshake now, with a timeout,
+// rather than on the first read or write.
+// If it fails, it closes the connection.
+func tlsHandshake(tc *tls.Conn) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/apmckinlay/gsuneido/builtin"
 	"github.com/apmckinlay/gsuneido/compile"
+	_ "github.com/apmckinlay/gsuneido/compiled"
 	. "github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/db19"
 	"github.com/apmckinlay/gsuneido/db19/tools"
@@ -27,6 +28,8 @@ import (
 	"github.com/apmckinlay/gsuneido/options"
 	"github.com/apmckinlay/gsuneido/util/dbg"
 	"github.com/apmckinlay/gsuneido/util/exit"
+	"github.com/apmckinlay/gsuneido/util/jsonlog"
+	"github.com/apmckinlay/gsuneido/util/otel"
 	"github.com/apmckinlay/gsuneido/util/str"
 	"github.com/apmckinlay/gsuneido/util/system"
 	// sync "github.com/sasha-s/go-deadlock"
@@ -39,13 +42,23 @@ var help = `options:
 	-check
 	-c[lient][=ipaddress] (default 127.0.0.1)
 	-compact
+	-compress[=#] or -z[=#] (minimum message size, default 1024)
 	-d[ump] [table]
 	-h[elp] or -?
+	-j[son]l[og][=filename] (default suneido.log)
 	-l[oad] [table] (or @filename)
+	-m[ax]w[orkers]=# (default unlimited)
+	-m[ax]q[ueue]=# (default unlimited)
+	-otel=filename or url (export trace spans)
 	-p[ass]p[hrase]=string (for -load)
 	-p[ort][=#] (default 3147)
 	-repair
 	-s[erver]
+	-s[low]q[uery]=ms
+	-s[low]r[eads]=#
+	-tls (client requires TLS)
+	-tlscert=filename -tlskey=filename
+	-tlsca=filename (certificate authority to verify the other side)
 	-v[ersion]
 	-w[eb][=#] (default -port + 1)`
 
@@ -91,8 +104,17 @@ func main() {
 		}
 	}
 
+	if options.JsonLog != "" {
+		startJsonLog()
+	}
+	if options.Otel != "" {
+		otel.Enable(options.Otel, "gsuneido"+str.Opt("-", options.Action))
+		exit.Add("otel", otel.Flush)
+	}
+
 	Libload = libload // dependency injection
 	mainThread.Name = "main"
+	jsonlog.SetThread(mainThread.Name)
 	mainThread.SetSviews(&sviews)
 	MainThread = &mainThread
 
@@ -213,13 +235,14 @@ func main() {
 	}()
 	// dependency injection of GetDbms
 	if options.Action == "client" {
-		conn := dbms.ConnectClient(options.Arg, options.Port)
-		client := dbms.NewDbmsClient(conn)
+		client := dbms.ConnectClient(options.Arg, options.Port)
 		GetDbms = func() IDbms {
 			return client.NewSession()
 		}
-		if mode == "gui" {
+		if mode == "gui" && !jsonlog.On() {
 			log.SetFlags(log.Ldate | log.Ltime | log.Lmsgprefix)
+		}
+		if mode == "gui" {
 			sendErrorLog(mainThread.Dbms(), mainThread.SessionId(""))
 		}
 	} else {
@@ -266,6 +289,24 @@ func redirect() {
 	}
 }
 
+// startJsonLog switches log output to JSON lines in options.JsonLog
+func startJsonLog() {
+	path := options.JsonLog
+	if options.Action == "client" && !filepath.IsAbs(path) {
+		path = builtin.ErrlogDir() + path
+	}
+	w, err := jsonlog.NewRotator(path,
+		options.LogMaxSize, options.LogMaxAge, options.LogKeep)
+	if err != nil {
+		Fatal("JsonLog failed:", err)
+	}
+	source := options.Action
+	if source != "server" && source != "client" {
+		source = "local"
+	}
+	jsonlog.Start(w, source)
+}
+
 func versionMismatch(s string) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -432,8 +473,10 @@ var prompt = func(s string) { fmt.Fprintln(os.Stderr, s) }
 
 func repl() {
 	builtin.InheritHandles = true
-	log.SetFlags(log.Ltime)
-	log.SetPrefix("")
+	if !jsonlog.On() {
+		log.SetFlags(log.Ltime)
+		log.SetPrefix("")
+	}
 
 	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
 		prompt = func(string) {}
@@ -513,7 +556,7 @@ func libload(th *Thread, name string) (result Value, e any) {
 	defs := th.Dbms().LibGet(name)
 	ovLib, ovSrc := LibraryOverrides.Get(name)
 	for i := 0; i < len(defs); i += 2 {
-		libtag := defs[i]
+		libtag, version, _ := strings.Cut(defs[i], "@")
 		lib := str.BeforeLast(libtag, "__")
 		tag := libtag[len(lib):]
 		src := defs[i+1]
@@ -530,17 +573,18 @@ func libload(th *Thread, name string) (result Value, e any) {
 			continue
 		}
 		if src != "" {
-			result = llcompile(libtag, name, src, result)
+			result = llcompile(libtag, name, src, version, result)
 		}
 	}
 	if ovLib == "" && ovSrc != "" {
-		result = llcompile("", name, ovSrc, result)
+		result = llcompile("", name, ovSrc, "", result)
 	}
 	return result, nil
 }
 
-func llcompile(lib, name, src string, prevDef Value) Value {
+func llcompile(lib, name, src, version string, prevDef Value) Value {
 	// want to pass the name from the start (rather than adding after)
 	// so it propagates to nested Named values
-	return compile.NamedConstant(lib, name, src, prevDef)
+	v := compile.NamedConstant(lib, name, src, prevDef)
+	return Compiled(lib, name, src, version, v)
 }
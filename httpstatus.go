@@ -19,6 +19,7 @@ import (
 	"github.com/apmckinlay/gsuneido/core"
 	"github.com/apmckinlay/gsuneido/dbms"
 	"github.com/apmckinlay/gsuneido/options"
+	"github.com/apmckinlay/gsuneido/util/metric"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 )
@@ -30,6 +31,7 @@ func startHttpStatus() {
 		return // already started
 	}
 	http.HandleFunc("/", httpStatus)
+	http.HandleFunc("/metrics", httpOpenMetrics)
 	http.HandleFunc("/metrics/", httpMetrics)
 	http.HandleFunc("/info/", httpInfo)
 	port := "3148"
@@ -83,6 +85,7 @@ func body() string {
 	if dbmsLocal != nil {
 		s += `<p>Database: ` + mb(dbmsLocal.Size()) + `
 		` + trans() + `
+		` + dbms.WorkerStatus() + `
 		` + dbms.Conns()
 	}
 	return s + `<p><a href="info/">Suneido Info</a> &nbsp;&nbsp;
@@ -135,7 +138,7 @@ func httpMetrics(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if req.URL.Path == "/metrics" || req.URL.Path == "/metrics/" {
+	if req.URL.Path == "/metrics/" {
 		io.WriteString(w,
 			`<html>
 			<head><title>Go metrics</title></head>
@@ -169,6 +172,30 @@ func httpMetrics(w http.ResponseWriter, req *http.Request) {
 
 var printer = message.NewPrinter(language.English)
 
+// httpOpenMetrics serves the numeric info values and the registered metrics
+// in the OpenMetrics text format (e.g. for Prometheus)
+func httpOpenMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type",
+		"application/openmetrics-text; version=1.0.0; charset=utf-8")
+	names := core.InfoList()
+	sort.Strings(names)
+	for _, name := range names {
+		if n, ok := core.InfoNum(name); ok {
+			metric.WriteGauge(w, "suneido_"+metric.Name(name), "", n)
+		}
+	}
+	if dbmsLocal != nil {
+		metric.WriteGauge(w, "suneido_database_size_bytes",
+			"Size of the database file", float64(dbmsLocal.Size()))
+	}
+	metric.Write(w)
+	io.WriteString(w, "# EOF\n")
+}
+
 func httpInfo(w http.ResponseWriter, req *http.Request) {
 	if req.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
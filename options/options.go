@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/apmckinlay/gsuneido/util/generic/atomics"
 	"github.com/apmckinlay/gsuneido/util/regex"
@@ -44,6 +45,45 @@ var CmdLine string
 
 var ErrorLog = "error.log"
 
+// JsonLog is the structured (JSON lines) log file, "" if not enabled.
+// It is rotated when it exceeds LogMaxSize or LogMaxAge,
+// keeping LogKeep old files.
+var (
+	JsonLog    string
+	LogMaxSize int64 = 20 * 1024 * 1024
+	LogMaxAge        = 7 * 24 * time.Hour
+	LogKeep          = 5
+)
+
+// Otel is where to export trace spans, a file name or collector url,
+// "" if tracing is not enabled
+var Otel string
+
+// SlowQueryTime and SlowQueryReads are the thresholds
+// for the slow query log, zero disables.
+var (
+	SlowQueryTime  time.Duration
+	SlowQueryReads int
+	SlowQueryLog   = "slowquery.log"
+)
+
+// Compress is the minimum size of client-server messages to compress,
+// 0 disables compression. Both sides must enable it for it to be used.
+var Compress int
+
+// TlsCert and TlsKey are the certificate and key files for TLS.
+// For the server they enable TLS and then all clients must use it.
+// For the client they are only required if the server uses TlsCA.
+// TlsCA is the certificate authority file used to verify the other side.
+// On the server it means clients must supply a certificate.
+// Tls makes the client require TLS.
+var (
+	TlsCert string
+	TlsKey  string
+	TlsCA   string
+	Tls     bool
+)
+
 var (
 	AllWarningsThrow = regex.Compile("")
 	NoWarningsThrow  = regex.Compile(`\A\Z`)
@@ -62,6 +102,14 @@ var Coverage atomic.Bool
 // Additional tags should include the "__" prefix.
 var LibraryTags = []string{""}
 
+// MaxWorkers limits the number of dbms server workers (0 for unlimited).
+// When they are all busy, up to MaxQueue requests (0 for unlimited)
+// are queued, further requests are rejected.
+var (
+	MaxWorkers int
+	MaxQueue   int
+)
+
 var Nworkers = func() int {
 	return min(8, max(1, runtime.NumCPU()-1)) // ???
 }()
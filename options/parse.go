@@ -7,6 +7,7 @@ import (
 	"math/bits"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Parse processes the command line options
@@ -78,6 +79,98 @@ loop:
 					error("invalid web port number")
 				}
 			}
+		case match(&args, "-jsonlog"), match(&args, "-jl"):
+			JsonLog = "suneido.log"
+			args = optEqualArg(args, &JsonLog)
+			if JsonLog == "" {
+				error("json log file name required")
+			}
+		case match(&args, "-logmaxsize"), match(&args, "-lms"):
+			mb := ""
+			args = optEqualArg(args, &mb)
+			if n, ok := atoui(mb); ok && n > 0 {
+				LogMaxSize = int64(n) * 1024 * 1024
+			} else {
+				error("invalid log max size megabytes")
+			}
+		case match(&args, "-logmaxage"), match(&args, "-lma"):
+			days := ""
+			args = optEqualArg(args, &days)
+			if n, ok := atoui(days); ok && n > 0 {
+				LogMaxAge = time.Duration(n) * 24 * time.Hour
+			} else {
+				error("invalid log max age days")
+			}
+		case match(&args, "-logkeep"), match(&args, "-lk"):
+			keep := ""
+			args = optEqualArg(args, &keep)
+			if n, ok := atoui(keep); ok {
+				LogKeep = n
+			} else {
+				error("invalid log keep")
+			}
+		case match(&args, "-maxworkers"), match(&args, "-mw"):
+			mw := ""
+			args = optEqualArg(args, &mw)
+			if n, ok := atoui(mw); ok && n > 0 {
+				MaxWorkers = n
+			} else {
+				error("invalid max workers")
+			}
+		case match(&args, "-maxqueue"), match(&args, "-mq"):
+			mq := ""
+			args = optEqualArg(args, &mq)
+			if n, ok := atoui(mq); ok && n > 0 {
+				MaxQueue = n
+			} else {
+				error("invalid max queue")
+			}
+		case match(&args, "-otel"):
+			args = optEqualArg(args, &Otel)
+			if Otel == "" {
+				error("otel destination required")
+			}
+		case match(&args, "-slowquery"), match(&args, "-sq"):
+			ms := ""
+			args = optEqualArg(args, &ms)
+			if n, ok := atoui(ms); ok && n > 0 {
+				SlowQueryTime = time.Duration(n) * time.Millisecond
+			} else {
+				error("invalid slow query milliseconds")
+			}
+		case match(&args, "-slowreads"), match(&args, "-sr"):
+			nr := ""
+			args = optEqualArg(args, &nr)
+			if n, ok := atoui(nr); ok && n > 0 {
+				SlowQueryReads = n
+			} else {
+				error("invalid slow query reads")
+			}
+		case match(&args, "-compress"), match(&args, "-z"):
+			cs := "1024"
+			args = optEqualArg(args, &cs)
+			if n, ok := atoui(cs); ok && n > 0 {
+				Compress = n
+			} else {
+				error("invalid compress size")
+			}
+		case match(&args, "-tls"):
+			Tls = true
+		case match(&args, "-tlscert"):
+			args = optEqualArg(args, &TlsCert)
+			if TlsCert == "" {
+				error("tls certificate file required")
+			}
+		case match(&args, "-tlskey"):
+			args = optEqualArg(args, &TlsKey)
+			if TlsKey == "" {
+				error("tls key file required")
+			}
+		case match(&args, "-tlsca"):
+			args = optEqualArg(args, &TlsCA)
+			if TlsCA == "" {
+				error("tls certificate authority file required")
+			}
 		case match(&args, "-printstates"):
 			setAction("printstates")
 		case match(&args, "-checkstates"):
@@ -95,6 +188,9 @@ loop:
 		error("port should only be specified with -server or -client, not " +
 			Action)
 	}
+	if (TlsCert == "") != (TlsKey == "") {
+		error("tlscert and tlskey must be used together")
+	}
 	if Port == "" && (Action == "client" || Action == "server") {
 		Port = "3147"
 	}
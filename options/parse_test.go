@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/apmckinlay/gsuneido/util/assert"
 )
@@ -18,6 +19,12 @@ func TestParse(t *testing.T) {
 		Action, Arg, Port, CmdLine, Error = "", "", "", "", ""
 		TimeoutMinutes = 0
 		WebServer, WebPort = false, ""
+		JsonLog = ""
+		LogMaxSize, LogMaxAge, LogKeep = 1, time.Hour, -1
+		SlowQueryTime, SlowQueryReads = 0, 0
+		Otel = ""
+		MaxWorkers, MaxQueue = 0, 0
+		Compress, Tls, TlsCert, TlsKey, TlsCA = 0, false, "", "", ""
 		Parse(args)
 		s := Action
 		if Arg != "" {
@@ -35,6 +42,45 @@ func TestParse(t *testing.T) {
 				s += "=" + WebPort
 			}
 		}
+		if JsonLog != "" {
+			s += " jsonlog=" + JsonLog
+		}
+		if LogMaxSize != 1 {
+			s += " logmaxsize=" + strconv.FormatInt(LogMaxSize>>20, 10)
+		}
+		if LogMaxAge != time.Hour {
+			s += " logmaxage=" + LogMaxAge.String()
+		}
+		if LogKeep != -1 {
+			s += " logkeep=" + strconv.Itoa(LogKeep)
+		}
+		if MaxWorkers != 0 {
+			s += " maxworkers=" + strconv.Itoa(MaxWorkers)
+		}
+		if MaxQueue != 0 {
+			s += " maxqueue=" + strconv.Itoa(MaxQueue)
+		}
+		if Otel != "" {
+			s += " otel=" + Otel
+		}
+		if Compress != 0 {
+			s += " compress=" + strconv.Itoa(Compress)
+		}
+		if Tls {
+			s += " tls"
+		}
+		if TlsCert != "" {
+			s += " cert=" + TlsCert + " key=" + TlsKey
+		}
+		if TlsCA != "" {
+			s += " ca=" + TlsCA
+		}
+		if SlowQueryTime != 0 {
+			s += " slowquery=" + SlowQueryTime.String()
+		}
+		if SlowQueryReads != 0 {
+			s += " slowreads=" + strconv.Itoa(SlowQueryReads)
+		}
 		if CmdLine != "" {
 			s += " | " + CmdLine
 		}
@@ -107,6 +153,40 @@ func TestParse(t *testing.T) {
 	test("-w foo", "web | foo")
 	test("-web=1.2.3.4", "error invalid web port number")
 
+	test("-jsonlog", "jsonlog=suneido.log")
+	test("-s -jl=server.log", "server jsonlog=server.log")
+	test("-jsonlog=", "error json log file name required")
+	test("-s -jl -lms=50 -lma=2 -lk=0",
+		"server jsonlog=suneido.log logmaxsize=50 logmaxage=48h0m0s logkeep=0")
+	test("-logmaxsize=0", "error invalid log max size")
+	test("-logmaxage=x", "error invalid log max age")
+	test("-logkeep", "error invalid log keep")
+
+	test("-s -maxworkers=50 -maxqueue=500",
+		"server maxworkers=50 maxqueue=500")
+	test("-s -mw=20", "server maxworkers=20")
+	test("-mw=x", "error invalid max workers")
+	test("-mq", "error invalid max queue")
+
+	test("-s -otel=http://localhost:4318/v1/traces",
+		"server otel=http://localhost:4318/v1/traces")
+	test("-otel", "error otel destination required")
+
+	test("-c -compress", "client 127.0.0.1 compress=1024")
+	test("-s -z=4096", "server compress=4096")
+	test("-z=0", "error invalid compress size")
+	test("-c=server -tls -tlsca=ca.pem", "client server tls ca=ca.pem")
+	test("-s -tlscert=cert.pem -tlskey=key.pem",
+		"server cert=cert.pem key=key.pem")
+	test("-s -tlscert=cert.pem",
+		"error tlscert and tlskey must be used together")
+	test("-tlsca", "error tls certificate authority file required")
+
+	test("-s -slowquery=500", "server slowquery=500ms")
+	test("-s -sq=2000 -sr=10000", "server slowquery=2s slowreads=10000")
+	test("-sq", "error invalid slow query milliseconds")
+	test("-sr=0", "error invalid slow query reads")
+
 	test("-xyz", "error invalid command line argument")
 
 }
@@ -56,6 +56,74 @@ func TestMulti(t *testing.T) {
 	assert.This(th.Call(f)).Is(True)
 }
 
+func TestDestructure(t *testing.T) {
+	f := compile.Constant(`function () {
+		f = function () { return #(1, (2, 3), name: "Fred", age: 42) }
+		[a, [b, c], name:, age: x] = f()
+		[_, d] = Object(4, 5)
+		return Object(a, b, c, name, x, d)
+	}`)
+	var th Thread
+	assert.This(th.Call(f)).Is(compile.Constant(`#(1, 2, 3, "Fred", 42, 5)`))
+	f = compile.Constant(`function () { [a, b] = #(1); return a }`)
+	assert.This(func() { th.Call(f) }).Panics("member not found")
+}
+
+func TestMatch(t *testing.T) {
+	f := compile.Constant(`function (x) {
+		match x
+			{
+		case 0, "": return "empty"
+		case [a]: return "one " $ a
+		case [a, [b, c]]: return "nested " $ a $ b $ c
+		case [kind: #circle, radius:]: return "circle " $ radius
+		case [a, b, name: n]: return "named " $ a $ b $ n
+		case [a, b]: return "two " $ a $ b
+		case [_, _, _]: return "three"
+		default: return "other"
+			}
+	}`)
+	var th Thread
+	test := func(arg, expected string) {
+		t.Helper()
+		assert.T(t).This(th.Call(f, compile.Constant(arg))).Is(SuStr(expected))
+	}
+	test(`0`, "empty")
+	test(`""`, "empty")
+	test(`#(1)`, "one 1")
+	test(`#(1, (2, 3))`, "nested 123")
+	test(`#(kind: circle, radius: 5)`, "circle 5")
+	test(`#(kind: square, radius: 5)`, "other")
+	test(`#(1, 2, name: n)`, "named 12n")
+	test(`#(1, 2)`, "two 12")
+	test(`[1, 2]`, "two 12")
+	test(`#(1, 2, 3)`, "three")
+	test(`#(1, 2, 3, 4)`, "other")
+	test(`"x"`, "other")
+	f = compile.Constant(`function (x) { match x { case 1: } }`)
+	assert.This(assert.Catch(func() { th.Call(f, Zero) })).
+		Is(SuStr("unhandled match value"))
+}
+
+func TestAsync(t *testing.T) {
+	f := compile.Constant(`function () {
+		a = Async({ 123 })
+		b = Async({ throw "oops" })
+		c = Async({ forever { } })
+		c.Cancel()
+		x = a.Wait()
+		try b.Wait() catch (e) { y = e }
+		try c.Wait() catch (e2) { z = e2 }
+		ch = Channel()
+		ch.Send("hello")
+		s = Select(Object(Async({ Thread.Sleep(1000) }), ch))
+		return Object(x, y, z, s, c.Done?())
+	}`)
+	var th Thread
+	assert.T(t).This(th.Call(f)).
+		Is(compile.Constant(`#(123, "oops", "cancelled", (1, "hello"), true)`))
+}
+
 func TestInRange(t *testing.T) {
 	options.StrictCompare = true
 	defer func() {
@@ -0,0 +1,33 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+// Package decode converts text in other encodings to UTF-8
+package decode
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Reader returns a reader that converts from an encoding (e.g. "windows-1252")
+// to UTF-8. An empty encoding means no conversion.
+// A leading byte order mark is removed and overrides the encoding.
+func Reader(r io.Reader, enc string) (io.Reader, error) {
+	var dec transform.Transformer = encoding.Nop.NewDecoder()
+	if enc != "" {
+		e, err := ianaindex.IANA.Encoding(enc)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return nil, errors.New("unsupported encoding: " + enc)
+		}
+		dec = e.NewDecoder()
+	}
+	return transform.NewReader(r, unicode.BOMOverride(dec)), nil
+}
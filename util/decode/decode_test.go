@@ -0,0 +1,29 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package decode
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestReader(t *testing.T) {
+	test := func(s, enc, expected string) {
+		t.Helper()
+		r, err := Reader(strings.NewReader(s), enc)
+		assert.T(t).This(err).Is(nil)
+		b, _ := io.ReadAll(r)
+		assert.T(t).This(string(b)).Is(expected)
+	}
+	test("abc", "", "abc")
+	test("\xef\xbb\xbfabc", "", "abc")
+	test("caf\xe9", "windows-1252", "café")
+	test("\xef\xbb\xbfcaf\xc3\xa9", "windows-1252", "café")
+	test("\xff\xfea\x00b\x00", "", "ab")
+	_, err := Reader(strings.NewReader(""), "nonesuch")
+	assert.T(t).That(err != nil)
+}
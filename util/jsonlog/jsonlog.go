@@ -0,0 +1,150 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+// Package jsonlog implements the optional structured log mode.
+// Each entry is a JSON line with time, level, msg, source,
+// session, and thread. Entries that don't specify a session or thread
+// get the main thread's.
+//
+// Once started, output from the standard log package
+// (e.g. log.Println("ERROR: ...")) is also written as JSON
+// with the level taken from the message prefix.
+package jsonlog
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apmckinlay/gsuneido/util/generic/atomics"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+// session is the default session id (the main thread's)
+var session atomics.String
+
+// thread is the default thread name (the main thread's)
+var thread atomics.String
+
+// source is the default source e.g. "server" or "client"
+var source atomics.String
+
+// Start switches to structured logging to w.
+// src is the default source for entries that don't specify one
+// e.g. "server" or "client"
+func Start(w io.Writer, src string) {
+	source.Store(src)
+	h := handler{slog.NewJSONHandler(w,
+		&slog.HandlerOptions{Level: slog.LevelDebug})}
+	l := slog.New(h)
+	logger.Store(l)
+	slog.SetDefault(l) // redirects the standard log package
+	log.SetPrefix("")
+}
+
+// On returns whether structured logging has been started
+func On() bool {
+	return logger.Load() != nil
+}
+
+// Source returns the default source
+func Source() string {
+	return source.Load()
+}
+
+// SetSession sets the default session id
+func SetSession(id string) {
+	session.Store(id)
+}
+
+// SetThread sets the default thread name
+func SetThread(name string) {
+	thread.Store(name)
+}
+
+// Log writes an entry. args are key, value pairs as for slog.
+// It does nothing if structured logging has not been started.
+func Log(level slog.Level, msg string, args ...any) {
+	if l := logger.Load(); l != nil {
+		l.Log(context.Background(), level, msg, args...)
+	}
+}
+
+// Println writes an entry for a message that would otherwise
+// go to the log package, from a specific session and thread.
+// The level is taken from the message prefix.
+func Println(msg, session, thread string) {
+	Log(prefixLevel(msg), msg,
+		"source", source.Load(), "session", session, "thread", thread)
+}
+
+// prefixLevel returns the level for a message from the log package
+func prefixLevel(msg string) slog.Level {
+	if strings.HasPrefix(msg, "ERROR") || strings.HasPrefix(msg, "FATAL") {
+		return slog.LevelError
+	} else if strings.HasPrefix(msg, "WARNING") {
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// ParseLevel converts debug, info, warn (or warning), or error
+func ParseLevel(s string) (slog.Level, bool) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// handler adds the default source, session, and thread
+// and sets the level of messages from the log package
+type handler struct {
+	slog.Handler
+}
+
+func (h handler) Handle(ctx context.Context, r slog.Record) error {
+	hasSource, hasSession, hasThread := false, false, false
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "source":
+			hasSource = true
+		case "session":
+			hasSession = true
+		case "thread":
+			hasThread = true
+		}
+		return true
+	})
+	r = r.Clone()
+	if !hasSource {
+		// from the log package
+		r.Level = prefixLevel(r.Message)
+		r.AddAttrs(slog.String("source", source.Load()))
+	}
+	if id := session.Load(); !hasSession && id != "" {
+		r.AddAttrs(slog.String("session", id))
+	}
+	if name := thread.Load(); !hasThread && name != "" {
+		r.AddAttrs(slog.String("thread", name))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{h.Handler.WithGroup(name)}
+}
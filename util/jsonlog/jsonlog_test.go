@@ -0,0 +1,87 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestJsonLog(t *testing.T) {
+	defer func() {
+		logger.Store(nil)
+		session.Store("")
+		thread.Store("")
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+		log.SetOutput(os.Stderr)
+	}()
+	var buf bytes.Buffer
+	Start(&buf, "server")
+	assert.T(t).That(On())
+	SetSession("main")
+	SetThread("main")
+	log.Println("ERROR: oops")
+	Log(slog.LevelInfo, "hello", "source", "client", "session", "s1",
+		"thread", "t1")
+	Println("WARNING: careful", "s2", "t2")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.T(t).This(len(lines)).Is(3)
+	get := func(line string) map[string]any {
+		var m map[string]any
+		assert.T(t).This(json.Unmarshal([]byte(line), &m)).Is(nil)
+		delete(m, "time")
+		return m
+	}
+	assert.T(t).This(get(lines[0])).Is(map[string]any{"level": "ERROR",
+		"msg": "ERROR: oops", "source": "server", "session": "main",
+		"thread": "main"})
+	assert.T(t).This(get(lines[1])).Is(map[string]any{"level": "INFO",
+		"msg": "hello", "source": "client", "session": "s1", "thread": "t1"})
+	assert.T(t).This(get(lines[2])).Is(map[string]any{"level": "WARN",
+		"msg": "WARNING: careful", "source": "server", "session": "s2",
+		"thread": "t2"})
+}
+
+func TestRotator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	r, err := NewRotator(path, 10, 0, 2)
+	assert.T(t).This(err).Is(nil)
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		r.Write([]byte(s))
+	}
+	r.Close()
+	read := func(p string) string {
+		b, _ := os.ReadFile(p)
+		return string(b)
+	}
+	assert.T(t).This(read(path)).Is("dddddd\n")
+	assert.T(t).This(read(path + ".1")).Is("cccccc\n")
+	assert.T(t).This(read(path + ".2")).Is("bbbbbb\n")
+	_, err = os.Stat(path + ".3")
+	assert.T(t).That(os.IsNotExist(err))
+}
+
+func TestRotatorAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	old := `{"time":"` + time.Now().Add(-2*time.Hour).Format(time.RFC3339) +
+		`","msg":"old"}` + "\n"
+	os.WriteFile(path, []byte(old), 0644)
+	r, err := NewRotator(path, 0, time.Hour, 1)
+	assert.T(t).This(err).Is(nil)
+	r.Write([]byte("new\n"))
+	r.Close()
+	b, _ := os.ReadFile(path)
+	assert.T(t).This(string(b)).Is("new\n")
+	b, _ = os.ReadFile(path + ".1")
+	assert.T(t).This(string(b)).Is(old)
+}
@@ -0,0 +1,120 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package jsonlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rotator is an io.Writer that appends to a file,
+// rotating it when it exceeds maxSize bytes or is older than maxAge.
+// Rotated files are renamed path.1 (most recent) to path.<keep>
+// The age is measured from the time of the first (JSON) entry in the file.
+type Rotator struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+	lock    sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+}
+
+// NewRotator opens (or creates) the file.
+// maxSize or maxAge of zero disable that trigger.
+func NewRotator(path string, maxSize int64, maxAge time.Duration,
+	keep int) (*Rotator, error) {
+	r := &Rotator{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Rotator) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	r.opened = time.Now()
+	if r.size > 0 {
+		// the age is from the first entry, not the last write,
+		// so files that are appended to across restarts still rotate
+		r.opened = firstTime(r.path, info.ModTime())
+	}
+	return nil
+}
+
+// firstTime returns the time of the first entry in the file,
+// or def if it can't be determined
+func firstTime(path string, def time.Time) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return def
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadSlice('\n')
+	if err != nil {
+		return def
+	}
+	var entry struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal(line, &entry) != nil || entry.Time.IsZero() {
+		return def
+	}
+	return entry.Time
+}
+
+func (r *Rotator) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.size > 0 &&
+		((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+			(r.maxAge > 0 && time.Since(r.opened) > r.maxAge)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *Rotator) rotate() error {
+	r.f.Close()
+	if r.keep <= 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(r.name(r.keep))
+		for i := r.keep - 1; i >= 1; i-- {
+			os.Rename(r.name(i), r.name(i+1))
+		}
+		os.Rename(r.path, r.name(1))
+	}
+	return r.open()
+}
+
+func (r *Rotator) name(i int) string {
+	return r.path + "." + strconv.Itoa(i)
+}
+
+func (r *Rotator) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,258 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+// Package metric provides simple thread-safe counters and histograms
+// that can be written in the OpenMetrics text format (e.g. for Prometheus).
+// Metrics are registered during initialization with
+//
+//	var _ = metric.Register(name, help, metric)
+package metric
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter only increases
+type Counter struct {
+	n atomic.Int64
+}
+
+func (c *Counter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *Counter) Get() int64 {
+	return c.n.Load()
+}
+
+// CounterVec is a set of counters distinguished by a label value
+type CounterVec struct {
+	label string
+	lock  sync.Mutex
+	m     map[string]*Counter
+}
+
+func NewCounterVec(label string) *CounterVec {
+	return &CounterVec{label: label, m: make(map[string]*Counter)}
+}
+
+func (cv *CounterVec) Inc(value string) {
+	cv.lock.Lock()
+	c, ok := cv.m[value]
+	if !ok {
+		c = &Counter{}
+		cv.m[value] = c
+	}
+	cv.lock.Unlock()
+	c.Inc()
+}
+
+func (cv *CounterVec) Get(value string) int64 {
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+	if c, ok := cv.m[value]; ok {
+		return c.Get()
+	}
+	return 0
+}
+
+// Gauge is a function that returns the current value
+type Gauge func() float64
+
+// Histogram counts observations in buckets with upper bounds
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Int64 // one per bound, plus +Inf
+	sum    atomic.Uint64  // float64 bits
+}
+
+// NewHistogram returns a Histogram with the given (ascending) bucket bounds
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds,
+		counts: make([]atomic.Int64, len(bounds)+1)}
+}
+
+// LatencyBounds are suitable for durations in seconds
+var LatencyBounds = []float64{.0005, .001, .0025, .005, .01, .025, .05,
+	.1, .25, .5, 1, 2.5, 5, 10}
+
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old,
+			math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Count returns the total number of observations
+func (h *Histogram) Count() int64 {
+	var n int64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+// HistogramVec is a set of histograms distinguished by a label value
+type HistogramVec struct {
+	label  string
+	bounds []float64
+	lock   sync.Mutex
+	m      map[string]*Histogram
+}
+
+func NewHistogramVec(label string, bounds ...float64) *HistogramVec {
+	return &HistogramVec{label: label, bounds: bounds,
+		m: make(map[string]*Histogram)}
+}
+
+func (hv *HistogramVec) Observe(value string, v float64) {
+	hv.lock.Lock()
+	h, ok := hv.m[value]
+	if !ok {
+		h = NewHistogram(hv.bounds...)
+		hv.m[value] = h
+	}
+	hv.lock.Unlock()
+	h.Observe(v)
+}
+
+//-------------------------------------------------------------------
+
+type entry struct {
+	name string
+	help string
+	m    any
+}
+
+var registry struct {
+	lock    sync.Mutex
+	entries []entry
+}
+
+// Register adds a metric (*Counter, *CounterVec, Gauge, *Histogram,
+// or *HistogramVec). The name should not include a _total suffix.
+// The return type is to allow var _ = Register(...)
+func Register(name, help string, m any) struct{} {
+	switch m.(type) {
+	case *Counter, *CounterVec, Gauge, *Histogram, *HistogramVec:
+	default:
+		panic("metric.Register: invalid type")
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.entries = append(registry.entries, entry{name: name, help: help, m: m})
+	return struct{}{}
+}
+
+// Write writes all the registered metrics in OpenMetrics text format.
+// It does not write the terminating # EOF
+func Write(w io.Writer) {
+	registry.lock.Lock()
+	entries := slices.Clone(registry.entries)
+	registry.lock.Unlock()
+	slices.SortFunc(entries, func(x, y entry) int {
+		return strings.Compare(x.name, y.name)
+	})
+	for _, e := range entries {
+		switch m := e.m.(type) {
+		case *Counter:
+			header(w, e.name, "counter", e.help)
+			fmt.Fprintf(w, "%s_total %d\n", e.name, m.Get())
+		case *CounterVec:
+			header(w, e.name, "counter", e.help)
+			m.lock.Lock()
+			for _, k := range sortedKeys(m.m) {
+				fmt.Fprintf(w, "%s_total{%s=%s} %d\n",
+					e.name, m.label, quote(k), m.m[k].Get())
+			}
+			m.lock.Unlock()
+		case Gauge:
+			WriteGauge(w, e.name, e.help, m())
+		case *Histogram:
+			header(w, e.name, "histogram", e.help)
+			m.write(w, e.name, "")
+		case *HistogramVec:
+			header(w, e.name, "histogram", e.help)
+			m.lock.Lock()
+			for _, k := range sortedKeys(m.m) {
+				m.m[k].write(w, e.name, m.label+"="+quote(k)+",")
+			}
+			m.lock.Unlock()
+		}
+	}
+}
+
+// WriteGauge writes a single gauge value
+func WriteGauge(w io.Writer, name, help string, v float64) {
+	header(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %s\n", name, num(v))
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	}
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	var n int64
+	for i, b := range h.bounds {
+		n += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, num(b), n)
+	}
+	n += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, n)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, n)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels,
+		num(math.Float64frombits(h.sum.Load())))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// Name converts a string to a valid metric name
+// by replacing invalid characters with underscores
+func Name(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' ||
+			c == ':' || (c >= '0' && c <= '9' && i > 0)) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
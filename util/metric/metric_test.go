@@ -0,0 +1,47 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package metric
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestWrite(t *testing.T) {
+	registry.entries = nil
+	var c Counter
+	c.Inc()
+	cv := NewCounterVec("reason")
+	cv.Inc("conflict")
+	cv.Inc("conflict")
+	cv.Inc(`a"b`)
+	h := NewHistogramVec("cmd", .1, 1)
+	h.Observe("Get", .05)
+	h.Observe("Get", .5)
+	h.Observe("Get", 2)
+	Register("test_commits", "Commits", &c)
+	Register("test_aborts", "", cv)
+	Register("test_size", "", Gauge(func() float64 { return 123 }))
+	Register("test_seconds", "", h)
+	var sb strings.Builder
+	Write(&sb)
+	assert.T(t).This(sb.String()).Is(`# TYPE test_aborts counter
+test_aborts_total{reason="a\"b"} 1
+test_aborts_total{reason="conflict"} 2
+# TYPE test_commits counter
+# HELP test_commits Commits
+test_commits_total 1
+# TYPE test_seconds histogram
+test_seconds_bucket{cmd="Get",le="0.1"} 1
+test_seconds_bucket{cmd="Get",le="1"} 2
+test_seconds_bucket{cmd="Get",le="+Inf"} 3
+test_seconds_count{cmd="Get"} 3
+test_seconds_sum{cmd="Get"} 2.55
+# TYPE test_size gauge
+test_size 123
+`)
+	assert.T(t).This(Name("server.nWorker")).Is("server_nWorker")
+}
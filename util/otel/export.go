@@ -0,0 +1,130 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package otel
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// encode returns an OTLP/JSON ExportTraceServiceRequest
+func (e *exporter) encode(batch []*Span) []byte {
+	spans := make([]jsonSpan, len(batch))
+	for i, sp := range batch {
+		js := jsonSpan{
+			TraceId:   hex.EncodeToString(sp.TraceId[:]),
+			SpanId:    hex.EncodeToString(sp.SpanId[:]),
+			Name:      sp.name,
+			Kind:      int(sp.kind),
+			StartTime: strconv.FormatInt(sp.start.UnixNano(), 10),
+			EndTime:   strconv.FormatInt(sp.end.UnixNano(), 10),
+		}
+		if sp.parent != [8]byte{} {
+			js.ParentSpanId = hex.EncodeToString(sp.parent[:])
+		}
+		for _, a := range sp.attrs {
+			js.Attributes = append(js.Attributes, jsonAttr(a.key, a.value))
+		}
+		if sp.failed {
+			js.Status = &jsonStatus{Code: 2, Message: sp.errMsg}
+		}
+		spans[i] = js
+	}
+	req := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []jsonKeyValue{
+					jsonAttr("service.name", e.service)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "gsuneido"},
+				"spans": spans,
+			}},
+		}},
+	}
+	b, _ := json.Marshal(req)
+	return b
+}
+
+type jsonSpan struct {
+	TraceId      string         `json:"traceId"`
+	SpanId       string         `json:"spanId"`
+	ParentSpanId string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	StartTime    string         `json:"startTimeUnixNano"`
+	EndTime      string         `json:"endTimeUnixNano"`
+	Attributes   []jsonKeyValue `json:"attributes,omitempty"`
+	Status       *jsonStatus    `json:"status,omitempty"`
+}
+
+type jsonStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type jsonKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func jsonAttr(key string, value any) jsonKeyValue {
+	var v map[string]any
+	switch x := value.(type) {
+	case string:
+		v = map[string]any{"stringValue": x}
+	case bool:
+		v = map[string]any{"boolValue": x}
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(x)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		v = map[string]any{"doubleValue": x}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(x)}
+	}
+	return jsonKeyValue{Key: key, Value: v}
+}
+
+func fileSender(filename string) func([]byte) error {
+	return func(b []byte) error {
+		f, err := os.OpenFile(filename,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(b, '\n'))
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}
+}
+
+func postSender(url string) func([]byte) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(b []byte) error {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return nil
+	}
+}
+
+func logError(err error) {
+	log.Println("ERROR: otel export:", err)
+}
@@ -0,0 +1,264 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+// Package otel implements simple OpenTelemetry compatible tracing.
+// Spans are exported in OTLP/JSON format,
+// either appended to a file (one request per line)
+// or posted to a collector e.g. http://localhost:4318/v1/traces
+//
+// All the Span methods are nil safe
+// so callers do not need to check if tracing is enabled.
+package otel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Context identifies a span and the trace it is part of.
+// The zero value is not valid and means there is no current span.
+type Context struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+}
+
+// ContextSize is the size of an encoded Context
+const ContextSize = 16 + 8
+
+func (c Context) Valid() bool {
+	return c.TraceId != [16]byte{}
+}
+
+// Encode appends the binary form of the Context to buf
+func (c Context) Encode(buf []byte) []byte {
+	buf = append(buf, c.TraceId[:]...)
+	return append(buf, c.SpanId[:]...)
+}
+
+// Decode returns the Context from the start of buf (see Encode)
+func Decode(buf []byte) Context {
+	var c Context
+	copy(c.TraceId[:], buf)
+	copy(c.SpanId[:], buf[16:])
+	return c
+}
+
+// TraceParent returns the W3C traceparent header value
+func (c Context) TraceParent() string {
+	return "00-" + hex.EncodeToString(c.TraceId[:]) + "-" +
+		hex.EncodeToString(c.SpanId[:]) + "-01"
+}
+
+// ParseTraceParent parses a W3C traceparent header value.
+// It returns the zero Context if the value is not valid.
+func ParseTraceParent(s string) Context {
+	var c Context
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return Context{}
+	}
+	if _, err := hex.Decode(c.TraceId[:], []byte(parts[1])); err != nil {
+		return Context{}
+	}
+	if _, err := hex.Decode(c.SpanId[:], []byte(parts[2])); err != nil {
+		return Context{}
+	}
+	return c
+}
+
+// Kind values match OTLP SpanKind
+type Kind int
+
+const (
+	Internal Kind = 1
+	Server   Kind = 2
+	Client   Kind = 3
+)
+
+type Span struct {
+	Context
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+	end    time.Time
+	attrs  []attr
+	errMsg string
+	failed bool
+}
+
+type attr struct {
+	key   string
+	value any
+}
+
+// Start returns a new span, a child of parent if it is valid,
+// otherwise the root of a new trace.
+// It returns nil if tracing is not enabled.
+func Start(parent Context, name string, kind Kind) *Span {
+	if !Enabled() {
+		return nil
+	}
+	sp := &Span{name: name, kind: kind, start: time.Now()}
+	if parent.Valid() {
+		sp.TraceId = parent.TraceId
+		sp.parent = parent.SpanId
+	} else {
+		rand.Read(sp.TraceId[:])
+	}
+	rand.Read(sp.SpanId[:])
+	return sp
+}
+
+// SpanContext returns the Context of the span,
+// or the zero Context if sp is nil
+func (sp *Span) SpanContext() Context {
+	if sp == nil {
+		return Context{}
+	}
+	return sp.Context
+}
+
+// Parent returns the Context of the parent span,
+// or the zero Context if this is a root span
+func (sp *Span) Parent() Context {
+	if sp == nil || sp.parent == [8]byte{} {
+		return Context{}
+	}
+	return Context{TraceId: sp.TraceId, SpanId: sp.parent}
+}
+
+// SetAttr adds an attribute.
+// value should be a string, bool, int, int64, or float64
+func (sp *Span) SetAttr(key string, value any) {
+	if sp != nil {
+		sp.attrs = append(sp.attrs, attr{key: key, value: value})
+	}
+}
+
+// SetError marks the span as failed
+func (sp *Span) SetError(msg string) {
+	if sp != nil {
+		sp.failed = true
+		sp.errMsg = msg
+	}
+}
+
+// End finishes the span and queues it for export
+func (sp *Span) End() {
+	if sp == nil || !sp.end.IsZero() {
+		return
+	}
+	sp.end = time.Now()
+	if e := exp.Load(); e != nil {
+		select {
+		case e.ch <- sp:
+		default:
+			Dropped.Add(1)
+		}
+	}
+}
+
+//-------------------------------------------------------------------
+
+// Dropped counts spans that were discarded because the export queue was full
+var Dropped atomic.Int32
+
+var exp atomic.Pointer[exporter]
+
+func Enabled() bool {
+	return exp.Load() != nil
+}
+
+type exporter struct {
+	ch      chan *Span
+	flush   chan chan struct{}
+	stop    chan struct{}
+	service string
+	send    func([]byte) error
+}
+
+const (
+	queueSize     = 4096
+	batchSize     = 256
+	flushInterval = 2 * time.Second
+)
+
+// Enable starts exporting spans to dest,
+// either a file name or an http(s) collector url.
+// service is used for the service.name resource attribute.
+func Enable(dest, service string) {
+	e := &exporter{ch: make(chan *Span, queueSize),
+		flush: make(chan chan struct{}), stop: make(chan struct{}),
+		service: service}
+	if strings.HasPrefix(dest, "http://") ||
+		strings.HasPrefix(dest, "https://") {
+		e.send = postSender(dest)
+	} else {
+		e.send = fileSender(dest)
+	}
+	go e.run()
+	exp.Store(e)
+}
+
+// Disable stops tracing, spans that are in progress will not be exported
+func Disable() {
+	Flush()
+	if e := exp.Swap(nil); e != nil {
+		close(e.stop)
+	}
+}
+
+// Flush exports any queued spans and waits for completion
+func Flush() {
+	if e := exp.Load(); e != nil {
+		done := make(chan struct{})
+		e.flush <- done
+		<-done
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	var errOnce sync.Once
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(e.encode(batch)); err != nil {
+			errOnce.Do(func() { logError(err) })
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case sp := <-e.ch:
+			batch = append(batch, sp)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flush:
+		drain:
+			for {
+				select {
+				case sp := <-e.ch:
+					batch = append(batch, sp)
+				default:
+					break drain
+				}
+			}
+			send()
+			close(done)
+		case <-e.stop:
+			return
+		}
+	}
+}
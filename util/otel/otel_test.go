@@ -0,0 +1,71 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package otel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestContext(t *testing.T) {
+	assert.T(t).That(!Context{}.Valid())
+	var c Context
+	for i := range c.TraceId {
+		c.TraceId[i] = byte(i + 1)
+	}
+	for i := range c.SpanId {
+		c.SpanId[i] = byte(i + 101)
+	}
+	buf := c.Encode(nil)
+	assert.T(t).This(len(buf)).Is(ContextSize)
+	assert.T(t).This(Decode(buf)).Is(c)
+	tp := c.TraceParent()
+	assert.T(t).This(tp).
+		Is("00-0102030405060708090a0b0c0d0e0f10-65666768696a6b6c-01")
+	assert.T(t).This(ParseTraceParent(tp)).Is(c)
+	assert.T(t).This(ParseTraceParent("junk")).Is(Context{})
+}
+
+func TestExport(t *testing.T) {
+	assert.T(t).That(Start(Context{}, "disabled", Internal) == nil)
+	var nilSpan *Span
+	nilSpan.SetAttr("x", 1) // nil safe
+	nilSpan.End()
+
+	path := filepath.Join(t.TempDir(), "spans.json")
+	Enable(path, "test")
+	root := Start(Context{}, "root", Server)
+	child := Start(root.SpanContext(), "child", Internal)
+	assert.T(t).This(child.Parent()).Is(root.SpanContext())
+	assert.T(t).This(root.Parent()).Is(Context{})
+	child.SetAttr("n", 123)
+	child.SetError("oops")
+	child.End()
+	root.End()
+	Disable()
+
+	b, err := os.ReadFile(path)
+	assert.T(t).This(err).Is(nil)
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []jsonSpan
+			}
+		}
+	}
+	assert.T(t).This(json.Unmarshal(b, &req)).Is(nil)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.T(t).This(len(spans)).Is(2)
+	assert.T(t).This(spans[0].Name).Is("child")
+	assert.T(t).This(spans[0].ParentSpanId).Is(spans[1].SpanId)
+	assert.T(t).This(spans[0].TraceId).Is(spans[1].TraceId)
+	assert.T(t).This(spans[0].Status.Code).Is(2)
+	assert.T(t).This(spans[0].Attributes[0].Value["intValue"]).Is("123")
+	assert.T(t).This(spans[1].ParentSpanId).Is("")
+	assert.T(t).This(spans[1].Kind).Is(int(Server))
+}
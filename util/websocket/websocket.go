@@ -0,0 +1,240 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+// Package websocket implements the RFC 6455 framing protocol
+// over an already upgraded connection.
+// The HTTP handshake is left to net/http (see AcceptKey).
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Opcodes
+const (
+	Continuation = 0x0
+	Text         = 0x1
+	Binary       = 0x2
+	Close        = 0x8
+	Ping         = 0x9
+	Pong         = 0xA
+)
+
+// MaxMessage is the largest message that will be accepted
+const MaxMessage = 32 * 1024 * 1024
+
+const NormalClosure = 1000
+
+var ErrTooBig = errors.New("websocket: message too large")
+var ErrProtocol = errors.New("websocket: protocol error")
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a WebSocket connection.
+// ReadMessage must only be called by one goroutine at a time.
+// Writes may be called concurrently.
+type Conn struct {
+	rwc    io.ReadWriteCloser
+	r      *bufio.Reader
+	client bool // clients must mask the frames they send
+	wlock  sync.Mutex
+	closed bool // a close frame has been sent, guarded by wlock
+}
+
+// NewConn returns a Conn for an upgraded connection.
+// If r is nil a bufio.Reader is created for rwc.
+func NewConn(rwc io.ReadWriteCloser, r *bufio.Reader, client bool) *Conn {
+	if r == nil {
+		r = bufio.NewReader(rwc)
+	}
+	return &Conn{rwc: rwc, r: r, client: client}
+}
+
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// AcceptKey returns the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + guid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// NewKey returns a random Sec-WebSocket-Key
+func NewKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// ReadMessage returns the next data message (Text or Binary),
+// reassembling fragments.
+// Pings are answered with pongs (unless we are closing)
+// and pongs are ignored.
+// A close frame is answered and io.EOF is returned.
+func (c *Conn) ReadMessage() (op int, data []byte, err error) {
+	op = -1
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case Ping:
+			err := c.WriteMessage(Pong, payload)
+			if err != nil && err != ErrClosed {
+				return 0, nil, err
+			}
+			continue
+		case Pong:
+			continue
+		case Close:
+			code := NormalClosure
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.CloseWith(code, "")
+			return 0, nil, io.EOF
+		case Continuation:
+			if op == -1 {
+				return 0, nil, ErrProtocol
+			}
+		case Text, Binary:
+			if op != -1 {
+				return 0, nil, ErrProtocol
+			}
+			op = fop
+		default:
+			return 0, nil, ErrProtocol
+		}
+		if len(data)+len(payload) > MaxMessage {
+			return 0, nil, ErrTooBig
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0f)
+	if hdr[0]&0x70 != 0 { // no extensions are negotiated
+		return false, 0, nil, ErrProtocol
+	}
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client { // clients must mask, servers must not
+		return false, 0, nil, ErrProtocol
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if op >= Close && (n > 125 || !fin) {
+		return false, 0, nil, ErrProtocol
+	}
+	if n > MaxMessage {
+		return false, 0, nil, ErrTooBig
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// WriteMessage sends a single (unfragmented) frame
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFrame(op, data)
+}
+
+func (c *Conn) writeFrame(op int, data []byte) error {
+	buf := make([]byte, 0, 14+len(data))
+	buf = append(buf, 0x80|byte(op))
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	n := len(data)
+	switch {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		buf = append(buf, mask[:]...)
+		for i, b := range data {
+			buf = append(buf, b^mask[i%4])
+		}
+	} else {
+		buf = append(buf, data...)
+	}
+	_, err := c.rwc.Write(buf)
+	return err
+}
+
+// CloseWith sends a close frame (if one has not already been sent).
+// The peer should reply with its own close frame,
+// after which ReadMessage will return io.EOF and Shutdown can be called.
+func (c *Conn) CloseWith(code int, reason string) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	data := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	data = append(data, reason...)
+	return c.writeFrame(Close, data)
+}
+
+// Shutdown closes the underlying connection
+func (c *Conn) Shutdown() error {
+	return c.rwc.Close()
+}
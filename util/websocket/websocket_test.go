@@ -0,0 +1,75 @@
+// Copyright Suneido Software Corp. All rights reserved.
+// Governed by the MIT license found in the LICENSE file.
+
+package websocket
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/apmckinlay/gsuneido/util/assert"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example from RFC 6455
+	assert.T(t).This(AcceptKey("dGhlIHNhbXBsZSBub25jZQ==")).
+		Is("s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func TestConn(t *testing.T) {
+	assert := assert.T(t)
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, nil, true)
+	server := NewConn(c2, nil, false)
+	big := strings.Repeat("x", 70000)
+	go func() {
+		client.WriteMessage(Ping, []byte("ping"))
+		client.WriteMessage(Text, []byte("hello"))
+		client.WriteMessage(Binary, []byte(big))
+		client.CloseWith(NormalClosure, "")
+	}()
+	go func() {
+		// client must read the pong and the close reply
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	op, data, err := server.ReadMessage()
+	assert.This(err).Is(nil)
+	assert.This(op).Is(Text)
+	assert.This(string(data)).Is("hello")
+	op, data, _ = server.ReadMessage()
+	assert.This(op).Is(Binary)
+	assert.This(string(data)).Is(big)
+	_, _, err = server.ReadMessage()
+	assert.This(err).Is(io.EOF)
+	assert.This(server.WriteMessage(Text, nil)).Is(ErrClosed)
+}
+
+func TestPingWhileClosing(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, nil, true)
+	server := NewConn(c2, nil, false)
+	go func() {
+		// read the server's close frame
+		client.readFrame()
+		client.WriteMessage(Ping, nil)
+		client.CloseWith(NormalClosure, "")
+	}()
+	server.CloseWith(NormalClosure, "")
+	_, _, err := server.ReadMessage()
+	assert.T(t).This(err).Is(io.EOF)
+}
+
+func TestUnmasked(t *testing.T) {
+	c1, c2 := net.Pipe()
+	notClient := NewConn(c1, nil, false)
+	server := NewConn(c2, nil, false)
+	go notClient.WriteMessage(Text, []byte("hello"))
+	_, _, err := server.ReadMessage()
+	assert.T(t).This(err).Is(ErrProtocol)
+}